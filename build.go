@@ -0,0 +1,218 @@
+package testingdock
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/builder/dockerignore"
+	"github.com/docker/docker/pkg/fileutils"
+)
+
+// BuildOpts is an option struct for building the image of a container
+// from a Dockerfile.
+type BuildOpts struct {
+	// directory sent to the docker daemon as build context
+	Context string
+	// path of the Dockerfile within the context, default is "Dockerfile"
+	Dockerfile string
+	// build time variables
+	Args map[string]*string
+}
+
+// buildImage builds the image of the container and tags it with the
+// configured image name.
+func (c *Container) buildImage(ctx context.Context) {
+	printf("(setup ) %-25s - building image from %s", c.ccfg.Image, c.build.Context)
+
+	buildCtx, err := buildContext(c.build.Context, c.build.Dockerfile)
+	if err != nil {
+		c.t.Fatalf("build context failure: %s", err.Error())
+	}
+
+	res, err := c.cli.ImageBuild(ctx, buildCtx, types.ImageBuildOptions{
+		Tags:        []string{c.ccfg.Image},
+		Dockerfile:  c.build.Dockerfile,
+		BuildArgs:   c.build.Args,
+		Remove:      true,
+		ForceRemove: true,
+		PullParent:  c.forcePull,
+	})
+	if err != nil {
+		c.t.Fatalf("image build failure of '%s': %s", c.ccfg.Image, err.Error())
+	}
+	defer res.Body.Close() // nolint: errcheck
+
+	// the build only fails in the streamed response
+	dec := json.NewDecoder(res.Body)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err = dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			c.t.Fatalf("image build response read failure: %s", err.Error())
+		}
+		if msg.Error != "" {
+			c.t.Fatalf("image build failure of '%s': %s", c.ccfg.Image, msg.Error)
+		}
+		if Verbose && msg.Stream != "" {
+			printf("(build ) %-25s - %s", c.ccfg.Image, msg.Stream)
+		}
+	}
+
+	printf("(setup ) %-25s - successfully built image", c.ccfg.Image)
+
+	// the build cache may keep the image ID, while the files changed
+	if c.reuse && !c.shared {
+		if c.contextHash, err = hashContext(c.build.Context, c.build.Dockerfile); err != nil {
+			c.t.Fatalf("build context hashing failure: %s", err.Error())
+		}
+	}
+}
+
+// hashContext hashes the names, modes and contents of the files of the build
+// context. Modification times are left out, as e.g. a checkout changes them.
+func hashContext(dir, dockerfile string) (string, error) {
+	r, err := buildContext(dir, dockerfile)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00%s\x00%d\x00", hdr.Name, hdr.Mode, hdr.Linkname, hdr.Size)
+		if _, err = io.Copy(h, tr); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildContext returns the build context directory as tar stream without the
+// files excluded by its .dockerignore file. Like the docker CLI does, the
+// Dockerfile and the .dockerignore file are always sent.
+func buildContext(dir, dockerfile string) (io.Reader, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return tarPath(dir)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	patterns, err := dockerignore.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf(".dockerignore parsing failure: %s", err.Error())
+	}
+	pm, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf(".dockerignore parsing failure: %s", err.Error())
+	}
+
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	keep := map[string]bool{filepath.Clean(dockerfile): true, ".dockerignore": true}
+	return tarPathFiltered(dir, func(rel string, info os.FileInfo) (bool, error) {
+		if keep[rel] {
+			return true, nil
+		}
+		excluded, err := pm.Matches(rel)
+		if err != nil || !excluded {
+			return true, err
+		}
+		// files of excluded directories can only be included again by exceptions
+		if info.IsDir() && !pm.Exclusions() {
+			return false, filepath.SkipDir
+		}
+		return false, nil
+	})
+}
+
+// tarPath returns the given directory or file as tar stream. The entries are
+// relative to the directory, a single file is stored under its base name.
+func tarPath(path string) (io.Reader, error) {
+	return tarPathFiltered(path, nil)
+}
+
+// tarPathFiltered is tarPath, which leaves out the entries the given filter,
+// if any, does not include.
+func tarPathFiltered(path string, filter func(rel string, info os.FileInfo) (bool, error)) (io.Reader, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := path
+	if !fi.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			if filter != nil {
+				if ok, err := filter(rel, info); !ok || err != nil {
+					return err
+				}
+			}
+
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close() // nolint: errcheck
+
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err) // nolint: errcheck
+	}()
+
+	return pr, nil
+}
@@ -0,0 +1,149 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Pause suspends all processes of the container, until it is unpaused.
+func (c *Container) Pause(ctx context.Context) error {
+	if err := c.cli.ContainerPause(ctx, c.ID); err != nil {
+		return err
+	}
+	printf("(chaos ) %-25s (%s) - container paused", c.Name, c.ID)
+	return nil
+}
+
+// Unpause resumes the processes of the paused container and blocks until it
+// passed its health check again.
+func (c *Container) Unpause(ctx context.Context) error {
+	if err := c.cli.ContainerUnpause(ctx, c.ID); err != nil {
+		return err
+	}
+	printf("(chaos ) %-25s (%s) - container unpaused", c.Name, c.ID)
+	return c.waitHealthy(ctx)
+}
+
+// Stop stops the container, killing it after the given timeout. As containers
+// are removed automatically once they stopped, Stop blocks until the container
+// has been removed, or until it stopped for containers kept after they exited,
+// like the one-shot services of compose files. Restart recreates it.
+func (c *Container) Stop(ctx context.Context, timeout time.Duration) error {
+	if err := c.clearFaults(ctx); err != nil {
+		return err
+	}
+	c.stopStats()
+	if err := c.cli.ContainerStop(ctx, c.ID, &timeout); err != nil {
+		return err
+	}
+	if err := c.waitStopped(ctx); err != nil {
+		return err
+	}
+	printf("(chaos ) %-25s (%s) - container stopped", c.Name, c.ID)
+	return nil
+}
+
+// Kill sends the given signal, e.g. "SIGKILL", to the main process of the
+// container. If the container exits, Restart recreates it. Stats are not collected
+// until it is restarted.
+func (c *Container) Kill(ctx context.Context, signal string) error {
+	if err := c.clearFaults(ctx); err != nil {
+		return err
+	}
+	c.stopStats()
+	if err := c.cli.ContainerKill(ctx, c.ID, signal); err != nil {
+		return err
+	}
+	printf("(chaos ) %-25s (%s) - container killed with: %s", c.Name, c.ID, signal)
+	return nil
+}
+
+// Restart restarts the container, or recreates it with the same configuration
+// if it has been stopped or killed, and blocks until it passed its health check again.
+// A recreated container runs through the same hooks as on start. Restarting a closed
+// container, e.g. once the suite closed, fails.
+func (c *Container) Restart(ctx context.Context) error {
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return fmt.Errorf("container %s is closed", c.Name)
+	}
+
+	if err := c.clearFaults(ctx); err != nil {
+		return err
+	}
+
+	cjson, err := c.cli.ContainerInspect(ctx, c.ID)
+	if err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+	if err == nil && (cjson.State.Running || cjson.State.Paused) {
+		if err = c.cli.ContainerRestart(ctx, c.ID, nil); err != nil {
+			return err
+		}
+		printf("(chaos ) %-25s (%s) - container restarted", c.Name, c.ID)
+		// collecting the stats resumes, e.g. after a Kill the container survived
+		c.startStats()
+	} else {
+		// the exited container is being removed if AutoRemove is set, it is
+		// removed here otherwise
+		if err == nil && !c.hcfg.AutoRemove {
+			if err = c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+				return err
+			}
+		}
+		if err = c.waitRemoved(ctx); err != nil {
+			return err
+		}
+		if err = c.createContainer(ctx); err != nil {
+			return err
+		}
+		printf("(chaos ) %-25s (%s) - container recreated", c.Name, c.ID)
+		// the logs of the removed container ended with it, they are followed
+		// again together with the stats
+		return c.afterStart(ctx)
+	}
+
+	return c.waitHealthy(ctx)
+}
+
+// waitStopped blocks until the container stopped, which means until it has
+// been removed if AutoRemove is set.
+func (c *Container) waitStopped(ctx context.Context) error {
+	if c.hcfg.AutoRemove {
+		return c.waitRemoved(ctx)
+	}
+	return c.wait(ctx, container.WaitConditionNotRunning)
+}
+
+// waitRemoved blocks until the container has been removed.
+func (c *Container) waitRemoved(ctx context.Context) error {
+	if engineOf(c.cli).Name == "podman" {
+		return waitRemovedPolling(ctx, c.cli, c.ID)
+	}
+	return c.wait(ctx, container.WaitConditionRemoved)
+}
+
+// wait blocks until the container reached the given condition, a container,
+// which does not exist anymore, satisfies all of them.
+func (c *Container) wait(ctx context.Context, condition container.WaitCondition) error {
+	statusC, errC := c.cli.ContainerWait(ctx, c.ID, condition)
+	select {
+	case <-statusC:
+		return nil
+	case err := <-errC:
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+}
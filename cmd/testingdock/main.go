@@ -0,0 +1,444 @@
+// Command testingdock brings up the docker setup of a testingdock suite outside
+// of the tests, so it can be used as local development environment or inspected
+// manually.
+//
+// The suite is read from a testingdock spec file or a docker-compose file:
+//  testingdock [-f file] [-name suite] <command> [arguments]
+//
+// Commands are:
+//  up      create the network and start the containers, -timings writes the timing report
+//  down    remove the containers and the network
+//  reset   reset the running containers like Suite.Reset
+//  ps      list the containers of the suite
+//  logs    print the logs of the containers, optionally only of the given ones
+//  prune   remove the resources of crashed test runs like testingdock.Prune
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/m4ksio/testingdock"
+)
+
+// defaultFiles are looked up in the working directory if no file is given.
+var defaultFiles = []string{
+	"testingdock.yml",
+	"testingdock.yaml",
+	"testingdock.json",
+	"docker-compose.yml",
+	"docker-compose.yaml",
+	"compose.yml",
+	"compose.yaml",
+}
+
+// flags of the command, which do not include the flags the testingdock package
+// registers for the tests, e.g. -testingdock.verbose.
+var (
+	flags = flag.NewFlagSet("testingdock", flag.ExitOnError)
+	file  = flags.String("f", "", "spec or compose file, default is the first of "+strings.Join(defaultFiles, ", ")+" in the working directory")
+	name  = flags.String("name", "", "name of the suite, default is the name in the spec or the name of the directory of the file")
+)
+
+func main() {
+	flags.Usage = usage
+	flags.Parse(os.Args[1:]) // nolint: errcheck
+	if flags.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cli, err := testingdock.NewClient()
+	if err != nil {
+		fatalf("docker client instantiation failure: %s", err.Error())
+	}
+	defer cli.Close() // nolint: errcheck
+
+	ctx := context.Background()
+	args := flags.Args()[1:]
+	switch flags.Arg(0) {
+	case "up":
+		err = up(ctx, cli, args)
+	case "down":
+		err = down(ctx, cli, args)
+	case "reset":
+		err = reset(ctx, cli, args)
+	case "ps":
+		err = ps(ctx, cli, args)
+	case "logs":
+		err = logs(ctx, cli, args)
+	case "prune":
+		err = prune(ctx, cli, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatalf("%s", err.Error())
+	}
+	if r, ok := tb.(*cliReporter); ok && r.Failed() {
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] up|down|reset|ps|logs|prune [arguments]\n", filepath.Base(os.Args[0])) // nolint: errcheck
+	flags.PrintDefaults()
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "testingdock: "+format+"\n", args...) // nolint: errcheck
+	os.Exit(1)
+}
+
+// tb is passed to the suites, the tests of the command replace it.
+var tb testingdock.Reporter = &cliReporter{}
+
+// cliReporter reports the failures of the suite on stderr. Fatal failures can
+// not be recovered from, so the command exits.
+type cliReporter struct {
+	mu     sync.Mutex
+	failed bool
+}
+
+func (*cliReporter) Logf(format string, args ...interface{}) { fmt.Printf(format+"\n", args...) }
+
+// Errorf reports failures, which do not stop the teardown, the command exits
+// with code 1 afterwards.
+func (r *cliReporter) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.failed = true
+	r.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "testingdock: "+format+"\n", args...) // nolint: errcheck
+}
+
+func (*cliReporter) Fatalf(format string, args ...interface{}) { fatalf(format, args...) }
+
+// Cleanup ignores the function, as the command never closes suites automatically.
+func (*cliReporter) Cleanup(func()) {}
+
+// Failed returns whether a failure has been reported.
+func (r *cliReporter) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+// loadSuite creates the suite from the spec or compose file. All of its
+// resources are reused, so they outlive the command and are neither removed
+// by the reapers nor by Prune of test runs, but only by down.
+func loadSuite(cli client.APIClient) (*testingdock.Suite, error) {
+	path := *file
+	if path == "" {
+		for _, f := range defaultFiles {
+			if _, err := os.Stat(f); err == nil {
+				path = f
+				break
+			}
+		}
+		if path == "" {
+			return nil, errors.New("no spec or compose file found, use -f")
+		}
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(filepath.Base(path), "compose") {
+		suiteName := *name
+		if suiteName == "" {
+			suiteName = filepath.Base(dir)
+		}
+		s, _ := testingdock.GetOrCreateSuite(tb, suiteName, testingdock.SuiteOpts{Client: cli, Reuse: true})
+		return s, s.LoadCompose(path)
+	}
+
+	sp, err := testingdock.ReadSpec(path)
+	if err != nil {
+		return nil, err
+	}
+	suiteName := *name
+	if suiteName == "" {
+		suiteName = sp.Name
+	}
+	if suiteName == "" {
+		suiteName = filepath.Base(dir)
+	}
+	if sp.Name == "" && sp.Network == nil {
+		sp.Name = suiteName
+	}
+	s, _ := testingdock.GetOrCreateSuite(tb, suiteName, testingdock.SuiteOpts{Client: cli, Reuse: true})
+	return s, s.ApplySpec(sp)
+}
+
+// suiteName returns the name of the suite, the file is only read if no name is given.
+func suiteName(cli client.APIClient) (string, error) {
+	if *name != "" {
+		return *name, nil
+	}
+	s, err := loadSuite(cli)
+	if err != nil {
+		return "", err
+	}
+	return s.Spec().Name, nil
+}
+
+func up(ctx context.Context, cli client.APIClient, args []string) error {
+	fs := flag.NewFlagSet("up", flag.ExitOnError)
+	timings := fs.String("timings", "", "write the start-up timing report to the given file, as JSON if it ends with .json or as Chrome trace if it ends with .trace")
+	fs.Parse(args) // nolint: errcheck
+
+	s, err := loadSuite(cli)
+	if err != nil {
+		return err
+	}
+	s.Start(ctx)
+
+	if *timings != "" {
+		if err = writeTimings(s.Timings(), *timings); err != nil {
+			return err
+		}
+	}
+	return list(ctx, cli, testingdock.LabelSuite+"="+s.Spec().Name)
+}
+
+func writeTimings(tr *testingdock.Timings, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".json":
+		err = tr.WriteJSON(f)
+	case ".trace":
+		err = tr.WriteChromeTrace(f)
+	default:
+		err = tr.WriteText(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func down(ctx context.Context, cli client.APIClient, args []string) error {
+	fs := flag.NewFlagSet("down", flag.ExitOnError)
+	fs.Parse(args) // nolint: errcheck
+
+	s, err := loadSuite(cli)
+	if err != nil {
+		return err
+	}
+	return s.Remove(ctx)
+}
+
+func reset(ctx context.Context, cli client.APIClient, args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	fs.Parse(args) // nolint: errcheck
+
+	s, err := loadSuite(cli)
+	if err != nil {
+		return err
+	}
+	if err = s.Attach(ctx); err != nil {
+		return err
+	}
+	s.Reset(ctx)
+	return nil
+}
+
+func ps(ctx context.Context, cli client.APIClient, args []string) error {
+	fs := flag.NewFlagSet("ps", flag.ExitOnError)
+	all := fs.Bool("a", false, "list the containers of all suites")
+	fs.Parse(args) // nolint: errcheck
+
+	label := testingdock.LabelSuite
+	if !*all {
+		suite, err := suiteName(cli)
+		if err != nil {
+			return err
+		}
+		label += "=" + suite
+	}
+
+	return list(ctx, cli, label)
+}
+
+// list prints the containers with the given label.
+func list(ctx context.Context, cli client.APIClient, label string) error {
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", label)),
+	})
+	if err != nil {
+		return fmt.Errorf("container listing failure: %s", err.Error())
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Names[0] < containers[j].Names[0]
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SUITE\tNAME\tCONTAINER ID\tIMAGE\tSTATUS\tPORTS") // nolint: errcheck
+	for _, c := range containers {
+		var ports []string
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.12s\t%s\t%s\t%s\n", c.Labels[testingdock.LabelSuite], strings.TrimPrefix(c.Names[0], "/"), c.ID, c.Image, c.Status, strings.Join(ports, ", ")) // nolint: errcheck
+	}
+	return w.Flush()
+}
+
+func logs(ctx context.Context, cli client.APIClient, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "follow the log output")
+	since := fs.String("since", "", "show logs since a timestamp or relative, e.g. 10m")
+	fs.Parse(args) // nolint: errcheck
+
+	s, err := loadSuite(cli)
+	if err != nil {
+		return err
+	}
+	if err = s.Attach(ctx); err != nil {
+		return err
+	}
+
+	names := fs.Args()
+	if len(names) == 0 {
+		for _, cs := range s.Spec().Containers {
+			names = append(names, cs.Name)
+		}
+	}
+	width := 0
+	for _, n := range names {
+		if len(n) > width {
+			width = len(n)
+		}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	for _, n := range names {
+		c, ok := s.Lookup(n)
+		if !ok {
+			return fmt.Errorf("container %s is not part of the suite", n)
+		}
+
+		wg.Add(1)
+		go func(c *testingdock.Container) {
+			defer wg.Done()
+
+			err := printLogs(ctx, cli, c, *follow, *since, fmt.Sprintf("%-*s | ", width, c.Name))
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %s", c.Name, err.Error()))
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// printLogs prints the logs of the container to stdout and stderr with every
+// line prefixed.
+func printLogs(ctx context.Context, cli client.APIClient, c *testingdock.Container, follow bool, since, prefix string) error {
+	cjson, err := cli.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	reader, err := cli.ContainerLogs(ctx, c.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Since:      since,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close() // nolint: errcheck
+
+	stdout := &prefixWriter{w: os.Stdout, prefix: prefix}
+	stderr := &prefixWriter{w: os.Stderr, prefix: prefix}
+	// the output is only multiplexed, unless the container has a TTY
+	if cjson.Config.Tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	if err != nil {
+		return err
+	}
+	stdout.flush()
+	stderr.flush()
+	return nil
+}
+
+// outputMu serializes the lines of all containers.
+var outputMu sync.Mutex
+
+// prefixWriter writes complete lines with a prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    bytes.Buffer
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf.Write(p)
+	for {
+		i := bytes.IndexByte(pw.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		pw.writeLine(pw.buf.Next(i + 1))
+	}
+}
+
+func (pw *prefixWriter) flush() {
+	if pw.buf.Len() > 0 {
+		pw.writeLine(append(pw.buf.Bytes(), '\n'))
+		pw.buf.Reset()
+	}
+}
+
+func (pw *prefixWriter) writeLine(line []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	io.WriteString(pw.w, pw.prefix) // nolint: errcheck
+	pw.w.Write(line)                // nolint: errcheck
+}
+
+func prune(ctx context.Context, cli client.APIClient, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", time.Hour, "only remove resources of sessions on other hosts older than this, sessions on this host are removed once their process exited")
+	fs.Parse(args) // nolint: errcheck
+
+	return testingdock.PruneClient(ctx, cli, *olderThan)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	pw := &prefixWriter{w: &out, prefix: "db | "}
+
+	for _, chunk := range []string{"first li", "ne\nsecond line\nthi", "rd"} {
+		if n, err := pw.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("write failure: %d, %v", n, err)
+		}
+	}
+	pw.flush()
+
+	if expected := "db | first line\ndb | second line\ndb | third\n"; out.String() != expected {
+		t.Errorf("wrong output: %q", out.String())
+	}
+}
+
+const specYAML = `
+volumes:
+  - name: data
+containers:
+  - name: db
+    image: postgres
+    volumes:
+      - volume: data
+        target: /var/lib/postgresql/data
+`
+
+const composeYAML = `
+services:
+  db:
+    image: postgres
+`
+
+// setup writes the given files into the directory "shop" of a new temporary
+// directory, makes the test report the failures of the suites and resets the
+// flags and the suites when the test finishes. Returns the directory.
+func setup(t *testing.T, files map[string]string) string {
+	tmp, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "shop")
+	if err = os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tb = t
+	t.Cleanup(func() {
+		testingdock.UnregisterAll()
+		tb = &cliReporter{}
+		*file, *name = "", ""
+		os.RemoveAll(tmp) // nolint: errcheck
+	})
+	return dir
+}
+
+func TestFlags(t *testing.T) {
+	var out bytes.Buffer
+	flags.SetOutput(&out)
+	defer flags.SetOutput(nil)
+	flags.PrintDefaults()
+
+	// the flags of the tests do not apply to the command
+	if strings.Contains(out.String(), "-testingdock.") || !strings.Contains(out.String(), "-name") {
+		t.Errorf("wrong flags:\n%s", out.String())
+	}
+}
+
+func TestCliReporter(t *testing.T) {
+	r := &cliReporter{}
+	r.Cleanup(func() { t.Error("cleanup functions are never called") })
+	if r.Failed() {
+		t.Error("no failure reported yet")
+	}
+	r.Errorf("container removal failure: %s", "gone")
+	if !r.Failed() {
+		t.Error("the failure should be recorded")
+	}
+}
+
+func TestLoadSuite(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"testingdock.yml":    specYAML,
+		"named.yml":          "name: named\n" + specYAML,
+		"docker-compose.yml": composeYAML,
+	})
+
+	for _, tc := range []struct {
+		file, name, expected string
+	}{
+		// suites are named after the directory of the file by default
+		{filepath.Join(dir, "testingdock.yml"), "", "shop"},
+		{filepath.Join(dir, "named.yml"), "", "named"},
+		{filepath.Join(dir, "named.yml"), "flag", "flag"},
+		{filepath.Join(dir, "docker-compose.yml"), "", "shop"},
+		{filepath.Join(dir, "docker-compose.yml"), "flag", "flag"},
+	} {
+		*file, *name = tc.file, tc.name
+		s, err := loadSuite(fake.NewEngine())
+		if err != nil {
+			t.Fatalf("%s: suite loading failure: %s", tc.file, err.Error())
+		}
+		if s.Spec().Name != tc.expected {
+			t.Errorf("%s: wrong suite name %s, expected %s", tc.file, s.Spec().Name, tc.expected)
+		}
+		if _, ok := s.Lookup("db"); !ok {
+			t.Errorf("%s: container db should be in the suite", tc.file)
+		}
+		testingdock.UnregisterAll()
+	}
+}
+
+func TestLoadSuite_DefaultFile(t *testing.T) {
+	dir := setup(t, map[string]string{
+		"docker-compose.yml": composeYAML,
+		"testingdock.yml":    specYAML,
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd) // nolint: errcheck
+
+	// the spec is preferred over the compose file
+	s, err := loadSuite(fake.NewEngine())
+	if err != nil {
+		t.Fatalf("suite loading failure: %s", err.Error())
+	}
+	if len(s.Spec().Volumes) != 1 {
+		t.Errorf("the spec should be loaded, got %+v", s.Spec())
+	}
+	testingdock.UnregisterAll()
+
+	if err = os.Remove("testingdock.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadSuite(fake.NewEngine()); err != nil {
+		t.Fatalf("suite loading failure: %s", err.Error())
+	}
+	testingdock.UnregisterAll()
+
+	if err = os.Remove("docker-compose.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadSuite(fake.NewEngine()); err == nil {
+		t.Error("loading should fail without any file")
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	dir := setup(t, map[string]string{"testingdock.yml": specYAML})
+	*file = filepath.Join(dir, "testingdock.yml")
+
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	if err := up(ctx, engine, nil); err != nil {
+		t.Fatalf("up failure: %s", err.Error())
+	}
+	// every command runs in its own process
+	testingdock.UnregisterAll()
+
+	resources, err := listResources(ctx, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"containers", "networks", "volumes"} {
+		labels := resources[kind]
+		if len(labels) != 1 {
+			t.Errorf("one of the %s expected, got %d", kind, len(labels))
+		}
+		for _, l := range labels {
+			if l[testingdock.LabelSuite] != "shop" || l["testingdock.reuse"] != "true" {
+				t.Errorf("%s are not labelled as reused resources of the suite: %v", kind, l)
+			}
+			if _, ok := l["testingdock.session"]; ok {
+				t.Errorf("%s must not belong to the session of the command: %v", kind, l)
+			}
+		}
+	}
+	ids := engine.ContainerIDs()
+
+	// the setup outlives the command, even if its process exited
+	if err := testingdock.PruneClient(ctx, engine, 0); err != nil {
+		t.Fatal(err)
+	}
+	if containers, networks := engine.Counts(); containers != 1 || networks != 1 || engine.VolumeCount() != 1 {
+		t.Fatalf("prune should keep the setup, got containers %v, %d networks and %d volumes", engine.ContainerNames(), networks, engine.VolumeCount())
+	}
+
+	// a second up adopts the running containers
+	if err := up(ctx, engine, nil); err != nil {
+		t.Fatalf("up failure: %s", err.Error())
+	}
+	testingdock.UnregisterAll()
+	if adopted := engine.ContainerIDs(); !reflect.DeepEqual(adopted, ids) {
+		t.Errorf("containers should be adopted, got %v instead of %v", adopted, ids)
+	}
+
+	if err := down(ctx, engine, nil); err != nil {
+		t.Fatalf("down failure: %s", err.Error())
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 || engine.VolumeCount() != 0 {
+		t.Errorf("down should remove everything, got containers %v, %d networks and %d volumes", engine.ContainerNames(), networks, engine.VolumeCount())
+	}
+}
+
+func TestLogs(t *testing.T) {
+	dir := setup(t, map[string]string{"testingdock.yml": specYAML})
+	*file = filepath.Join(dir, "testingdock.yml")
+
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	if err := up(ctx, engine, nil); err != nil {
+		t.Fatalf("up failure: %s", err.Error())
+	}
+	testingdock.UnregisterAll()
+
+	for _, tty := range []bool{false, true} {
+		db := engine.ByName("db")
+		db.Config.Tty = tty
+		engine.Log(db.ID, "ready")
+
+		out := captureStdout(t, func() {
+			if err := logs(ctx, engine, nil); err != nil {
+				t.Errorf("logs failure with tty %t: %s", tty, err.Error())
+			}
+		})
+		testingdock.UnregisterAll()
+		if !strings.HasSuffix(out, "db | ready\n") {
+			t.Errorf("wrong logs with tty %t: %q", tty, out)
+		}
+	}
+}
+
+// captureStdout returns what fn printed to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	fn()
+	os.Stdout = stdout
+	w.Close() // nolint: errcheck
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// listResources returns the labels of the containers, networks and volumes of
+// the engine by kind.
+func listResources(ctx context.Context, engine *fake.Engine) (map[string][]map[string]string, error) {
+	resources := make(map[string][]map[string]string)
+	containers, err := engine.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		resources["containers"] = append(resources["containers"], c.Labels)
+	}
+	networks, err := engine.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range networks {
+		resources["networks"] = append(resources["networks"], n.Labels)
+	}
+	volumes, err := engine.VolumeList(ctx, filters.NewArgs())
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range volumes.Volumes {
+		resources["volumes"] = append(resources["volumes"], v.Labels)
+	}
+	return resources, nil
+}
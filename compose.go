@@ -0,0 +1,904 @@
+package testingdock
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/go-connections/nat"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// compose depends_on conditions
+const (
+	composeServiceStarted   = "service_started"
+	composeServiceHealthy   = "service_healthy"
+	composeServiceCompleted = "service_completed_successfully"
+)
+
+// composeConditionRank orders the depends_on conditions by strength, a service
+// depended upon with several conditions has to satisfy the strongest one.
+var composeConditionRank = map[string]int{
+	composeServiceStarted:   1,
+	composeServiceHealthy:   2,
+	composeServiceCompleted: 3,
+}
+
+// LoadCompose populates the suite with the services of the given docker-compose
+// file. Every service becomes a container named after the service in the network
+// of the suite. If the suite has no network yet, one named after the compose
+// project is created.
+//
+// The supported service keys are image, build, command, entrypoint, environment,
+// ports, depends_on, healthcheck, volumes, tmpfs and networks, all other keys are
+// ignored. Named volumes are created by the suite and removed when it closes,
+// volumes declared as external have to exist and are mounted as they are.
+// Variables like ${VAR}, ${VAR:-default} and ${VAR:?message} in the values are
+// substituted from the environment after parsing, like compose does, a missing
+// required variable is an error. Substituted values are strings, unless the
+// key takes a number or a boolean.
+//
+// A service is created once all of its dependencies passed their health check.
+// A compose healthcheck is used as the health check of the container, services
+// another one depends on with "service_healthy" rely on the HEALTHCHECK of their
+// image otherwise, and services another one depends on with
+// "service_completed_successfully" have to exit with code 0. A service depended
+// upon with several conditions has to satisfy the strongest one. As the suite only
+// has one network, all services are attached to it and the aliases of all their
+// compose networks are applied there.
+func (s *Suite) LoadCompose(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	return s.loadCompose(b, dir)
+}
+
+// loadCompose populates the suite from the given compose file content, relative
+// paths are resolved against dir.
+func (s *Suite) loadCompose(b []byte, dir string) error { // nolint: gocyclo
+	b, err := interpolateYAML(b, reflect.TypeOf(composeFile{}))
+	if err != nil {
+		return fmt.Errorf("compose file parsing failure: %s", err.Error())
+	}
+
+	var cf composeFile
+	if err = yaml.Unmarshal(b, &cf); err != nil {
+		return fmt.Errorf("compose file parsing failure: %s", err.Error())
+	}
+
+	project := cf.Name
+	if project == "" {
+		project = filepath.Base(dir)
+	}
+	project = strings.ToLower(invalidNameChars.ReplaceAllString(project, "_"))
+
+	// how the services are depended upon decides about their health checks
+	conditions := make(map[string]string)
+	for name, svc := range cf.Services {
+		for dep, cond := range svc.DependsOn {
+			if _, ok := cf.Services[dep]; !ok {
+				return fmt.Errorf("service %s depends on undefined service %s", name, dep)
+			}
+			if composeConditionRank[cond] > composeConditionRank[conditions[dep]] {
+				conditions[dep] = cond
+			}
+		}
+	}
+
+	order, err := composeOrder(cf.Services)
+	if err != nil {
+		return err
+	}
+
+	// external volumes are mounted as they are, they are neither created nor removed
+	external := make(map[string]string)
+	for name, vc := range cf.Volumes {
+		if vc == nil || !vc.External {
+			continue
+		}
+		dockerName := vc.Name
+		if dockerName == "" {
+			dockerName = name
+		}
+		vv, err := findVolumeByName(context.Background(), s.cli, dockerName)
+		if err != nil {
+			return fmt.Errorf("volume listing failure: %s", err.Error())
+		}
+		if vv == nil {
+			return fmt.Errorf("external volume %s does not exist", dockerName)
+		}
+		external[name] = dockerName
+	}
+
+	n := s.getNetwork()
+	if n == nil {
+		n = s.Network(NetworkOpts{Name: project})
+	}
+
+	// named volumes are scoped to the project, as in compose
+	volumes := make(map[string]*Volume)
+	volume := func(name string) *Volume {
+		if volumes[name] == nil {
+			volumes[name] = s.Volume(VolumeOpts{Name: project + "_" + name})
+		}
+		return volumes[name]
+	}
+
+	containers := make(map[string]*Container, len(order))
+	for _, name := range order {
+		opts, err := cf.Services[name].containerOpts(project, name, dir, conditions[name], external, volume)
+		if err != nil {
+			return fmt.Errorf("service %s: %s", name, err.Error())
+		}
+		c := s.Container(opts)
+		hc := cf.Services[name].Healthcheck
+		switch {
+		case conditions[name] == composeServiceCompleted:
+			// the exit code has to be inspected after the container exited
+			c.hcfg.AutoRemove = false
+			c.healthSpec = &HealthCheckSpec{Type: HealthCheckTypeExited, Timeout: Duration(opts.HealthCheckTimeout)}
+		case conditions[name] == composeServiceHealthy || hc != nil && !hc.Disable:
+			c.healthSpec = &HealthCheckSpec{Type: HealthCheckTypeDocker, Timeout: Duration(opts.HealthCheckTimeout)}
+		}
+		containers[name] = c
+
+		var deps []*Container
+		for dep := range cf.Services[name].DependsOn {
+			deps = append(deps, containers[dep])
+		}
+		if len(deps) == 0 {
+			n.After(c)
+			continue
+		}
+
+		// the dependency which is started last in sequential mode becomes the parent,
+		// so all other dependencies are already started when waiting for them
+		end := subtreeEnds(n)
+		sort.Slice(deps, func(i, j int) bool {
+			return end[deps[i]] > end[deps[j]]
+		})
+		deps[0].After(c)
+		c.Needs(deps[1:]...)
+	}
+
+	return nil
+}
+
+// composeOrder sorts the services topologically by their dependencies.
+func composeOrder(services map[string]composeService) ([]string, error) {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		order []string
+		done  = make(map[string]bool)
+	)
+	for len(order) < len(names) {
+		progress := false
+		for _, name := range names {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for dep := range services[name].DependsOn {
+				ready = ready && done[dep]
+			}
+			if ready {
+				order = append(order, name)
+				done[name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("services have cyclic dependencies")
+		}
+	}
+
+	return order, nil
+}
+
+// subtreeEnds returns for every container of the network the position after its
+// last descendant, in the order the containers are started in sequential mode.
+func subtreeEnds(n *Network) map[*Container]int {
+	end := make(map[*Container]int)
+	pos := 0
+
+	var visit func(c *Container)
+	visit = func(c *Container) {
+		pos++
+		for _, cc := range c.children {
+			visit(cc)
+		}
+		end[c] = pos
+	}
+	for _, c := range n.children {
+		visit(c)
+	}
+
+	return end
+}
+
+// interpolateYAML substitutes the environment variables in the values of the
+// given YAML document, which is decoded into a value of the given schema type
+// afterwards. The variables are substituted after parsing, so that values
+// containing YAML syntax like ":" or "#" can't corrupt the document.
+func interpolateYAML(b []byte, schema reflect.Type) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	doc, err := interpolate(doc, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// interpolate substitutes the environment variables in all string values of
+// the parsed document, keys are left as they are. Substituted values stay
+// strings like in docker-compose, unless the field of the schema type they are
+// decoded into is a number or a boolean, e.g. "retries: ${RETRIES}".
+func interpolate(v interface{}, t reflect.Type) (interface{}, error) {
+	t = schemaOf(t)
+	switch v := v.(type) {
+	case string:
+		s, err := expandEnv(v)
+		if err != nil || s == v || t == nil {
+			return s, err
+		}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, nil
+			}
+		case reflect.Float32, reflect.Float64:
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, nil
+			}
+		case reflect.Bool:
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b, nil
+			}
+		}
+		return s, nil
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			value, err := interpolate(value, fieldOf(t, fmt.Sprint(key)))
+			if err != nil {
+				return nil, err
+			}
+			v[key] = value
+		}
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		for i, value := range v {
+			value, err := interpolate(value, elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+	}
+	return v, nil
+}
+
+// composeSchemas are the long forms of the compose types, which decode
+// themselves and would hide the types of their fields otherwise.
+var composeSchemas = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(composePort("")):      reflect.TypeOf(composePortLong{}),
+	reflect.TypeOf(composeHealthcheck{}): reflect.TypeOf(composeHealthcheckRaw{}),
+	reflect.TypeOf(composeVolume{}):      reflect.TypeOf(composeVolumeLong{}),
+}
+
+// schemaOf returns the type the value of the given type is decoded from, nil
+// if it decodes itself and the types of its fields are unknown.
+func schemaOf(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil
+	}
+	if schema, ok := composeSchemas[t]; ok {
+		return schema
+	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()) ||
+		reflect.PtrTo(t).Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()) {
+		return nil
+	}
+	return t
+}
+
+// fieldOf returns the type of the value with the given key in a map or struct
+// of the given type, nil if it is unknown.
+func fieldOf(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if name == key {
+				return f.Type
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv substitutes ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?message},
+// ${VAR?message} and $VAR with environment variables, $$ is an escaped $. The
+// forms with a colon treat empty variables like unset ones, the ones with a
+// question mark fail if the variable is unset.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := os.Expand(s, func(key string) string {
+		if key == "$" {
+			return "$"
+		}
+		i := strings.IndexAny(key, ":-?")
+		if i < 0 {
+			return os.Getenv(key)
+		}
+
+		name, op, arg := key[:i], key[i:i+1], key[i+1:]
+		value, ok := os.LookupEnv(name)
+		if op == ":" && arg != "" {
+			op, arg = arg[:1], arg[1:]
+			ok = ok && value != ""
+		}
+		switch {
+		case ok:
+			return value
+		case op == "-":
+			return arg
+		case op == "?" && err == nil:
+			err = fmt.Errorf("required variable %s is missing a value: %s", name, arg)
+		}
+		return ""
+	})
+
+	return expanded, err
+}
+
+type composeFile struct {
+	Name     string                          `yaml:"name"`
+	Services map[string]composeService       `yaml:"services"`
+	Volumes  map[string]*composeVolumeConfig `yaml:"volumes"`
+}
+
+// composeVolumeConfig is the declaration of a named volume.
+type composeVolumeConfig struct {
+	// whether the volume exists already and is managed outside of the project
+	External bool `yaml:"external"`
+	// name of an external volume, default is the name of the declaration
+	Name string `yaml:"name"`
+}
+
+type composeService struct {
+	Image       string              `yaml:"image"`
+	Build       *composeBuild       `yaml:"build"`
+	Command     composeCommand      `yaml:"command"`
+	Entrypoint  composeCommand      `yaml:"entrypoint"`
+	Environment composeEnvironment  `yaml:"environment"`
+	Ports       []composePort       `yaml:"ports"`
+	DependsOn   composeDependsOn    `yaml:"depends_on"`
+	Healthcheck *composeHealthcheck `yaml:"healthcheck"`
+	Volumes     []composeVolume     `yaml:"volumes"`
+	Tmpfs       composeStrings      `yaml:"tmpfs"`
+	Networks    composeNetworks     `yaml:"networks"`
+}
+
+// containerOpts converts the service to container options, named volumes
+// are looked up with the given function, unless they are external ones.
+func (svc composeService) containerOpts(project, name, dir, condition string, external map[string]string, volume func(name string) *Volume) (ContainerOpts, error) {
+	opts := ContainerOpts{
+		Name: name,
+		Config: &container.Config{
+			Image:      svc.Image,
+			Env:        []string(svc.Environment),
+			Cmd:        strslice.StrSlice(svc.Command),
+			Entrypoint: strslice.StrSlice(svc.Entrypoint),
+		},
+		HostConfig: &container.HostConfig{},
+		Aliases:    []string(svc.Networks),
+		Tmpfs:      []string(svc.Tmpfs),
+	}
+
+	if svc.Build != nil {
+		if opts.Config.Image == "" {
+			opts.Config.Image = project + "_" + strings.ToLower(invalidNameChars.ReplaceAllString(name, "_"))
+		}
+		opts.Build = &BuildOpts{
+			Context:    absPath(dir, svc.Build.Context),
+			Dockerfile: svc.Build.Dockerfile,
+			Args:       svc.Build.Args,
+		}
+	}
+	if opts.Config.Image == "" {
+		return opts, fmt.Errorf("neither image nor build is set")
+	}
+
+	if len(svc.Ports) > 0 {
+		specs := make([]string, len(svc.Ports))
+		for i, p := range svc.Ports {
+			specs[i] = string(p)
+		}
+		exposed, bindings, err := nat.ParsePortSpecs(specs)
+		if err != nil {
+			return opts, err
+		}
+		opts.Config.ExposedPorts = exposed
+		opts.HostConfig.PortBindings = bindings
+	}
+
+	for _, v := range svc.Volumes {
+		switch {
+		case v.Type == mount.TypeVolume && v.Source == "":
+			if opts.Config.Volumes == nil {
+				opts.Config.Volumes = make(map[string]struct{})
+			}
+			opts.Config.Volumes[v.Target] = struct{}{}
+		case v.Type == mount.TypeVolume && external[v.Source] != "":
+			opts.HostConfig.Mounts = append(opts.HostConfig.Mounts, mount.Mount{
+				Type:     mount.TypeVolume,
+				Source:   external[v.Source],
+				Target:   v.Target,
+				ReadOnly: v.ReadOnly,
+			})
+		case v.Type == mount.TypeVolume:
+			opts.Volumes = append(opts.Volumes, VolumeMount{
+				Volume:   volume(v.Source),
+				Target:   v.Target,
+				ReadOnly: v.ReadOnly,
+			})
+		default:
+			if v.Type == mount.TypeBind {
+				v.Source = absPath(dir, v.Source)
+			}
+			opts.HostConfig.Mounts = append(opts.HostConfig.Mounts, mount.Mount{
+				Type:     v.Type,
+				Source:   v.Source,
+				Target:   v.Target,
+				ReadOnly: v.ReadOnly,
+			})
+		}
+	}
+
+	hc := svc.Healthcheck
+	switch {
+	case condition == composeServiceCompleted:
+		opts.HealthCheck = healthCheckExited()
+	case hc != nil && hc.Disable:
+		opts.Config.Healthcheck = &container.HealthConfig{Test: []string{"NONE"}}
+	case hc != nil:
+		opts.Config.Healthcheck = &container.HealthConfig{
+			Test:        hc.Test,
+			Interval:    hc.Interval,
+			Timeout:     hc.Timeout,
+			StartPeriod: hc.StartPeriod,
+			Retries:     hc.Retries,
+		}
+		opts.HealthCheck = HealthCheckDocker()
+	case condition == composeServiceHealthy:
+		opts.HealthCheck = HealthCheckDocker()
+	}
+
+	// give slow health checks enough time
+	if hc != nil && !hc.Disable {
+		interval := hc.Interval
+		if interval == 0 {
+			interval = 30 * time.Second // docker default
+		}
+		retries := hc.Retries
+		if retries == 0 {
+			retries = 3 // docker default
+		}
+		if d := hc.StartPeriod + interval*time.Duration(retries+1); d > 30*time.Second {
+			opts.HealthCheckTimeout = d
+		}
+	}
+
+	return opts, nil
+}
+
+// absPath resolves path relative to dir, unless it is absolute.
+func absPath(dir, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// composeBuild is either the context path or a map.
+type composeBuild struct {
+	Context    string
+	Dockerfile string
+	Args       map[string]*string
+}
+
+func (b *composeBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&b.Context); err == nil {
+		return nil
+	}
+
+	var long struct {
+		Context    string             `yaml:"context"`
+		Dockerfile string             `yaml:"dockerfile"`
+		Args       composeEnvironment `yaml:"args"`
+	}
+	if err := unmarshal(&long); err != nil {
+		return err
+	}
+
+	b.Context = long.Context
+	b.Dockerfile = long.Dockerfile
+	b.Args = make(map[string]*string)
+	for _, arg := range long.Args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) == 2 {
+			b.Args[kv[0]] = &kv[1]
+		} else {
+			b.Args[kv[0]] = nil
+		}
+	}
+	return nil
+}
+
+// composeCommand is either a string, which is split like a shell would, or a list.
+type composeCommand []string
+
+func (c *composeCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*c = list
+		return nil
+	}
+
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	*c = splitCommand(str)
+	return nil
+}
+
+// splitCommand splits the command into words, honouring single and double quotes.
+func splitCommand(s string) []string {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// composeStrings is either a single string or a list.
+type composeStrings []string
+
+func (s *composeStrings) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		*s = []string{str}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// composeEnvironment is either a list of KEY=VALUE or a map. Keys without a
+// value are taken from the environment.
+type composeEnvironment []string
+
+func (e *composeEnvironment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		var m map[string]interface{}
+		if err = unmarshal(&m); err != nil {
+			return err
+		}
+		for key, value := range m {
+			if value == nil {
+				list = append(list, key)
+			} else {
+				list = append(list, fmt.Sprintf("%s=%v", key, value))
+			}
+		}
+		// keep the configuration hash stable
+		sort.Strings(list)
+	}
+
+	for i, kv := range list {
+		if !strings.Contains(kv, "=") {
+			if value, ok := os.LookupEnv(kv); ok {
+				list[i] = kv + "=" + value
+			}
+		}
+	}
+
+	*e = list
+	return nil
+}
+
+// composePort is either a short port spec or a map, and is converted to the
+// port spec format understood by nat.ParsePortSpecs.
+type composePort string
+
+func (p *composePort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short string
+	if err := unmarshal(&short); err == nil {
+		*p = composePort(short)
+		return nil
+	}
+
+	var long composePortLong
+	if err := unmarshal(&long); err != nil {
+		return err
+	}
+
+	spec := strconv.Itoa(long.Target)
+	if long.Published != "" {
+		spec = long.Published + ":" + spec
+		if long.HostIP != "" {
+			spec = long.HostIP + ":" + spec
+		}
+	}
+	if long.Protocol != "" {
+		spec += "/" + long.Protocol
+	}
+	*p = composePort(spec)
+	return nil
+}
+
+// composePortLong is the long form of a port.
+type composePortLong struct {
+	Target    int    `yaml:"target"`
+	Published string `yaml:"published"`
+	Protocol  string `yaml:"protocol"`
+	HostIP    string `yaml:"host_ip"`
+}
+
+// composeDependsOn maps the services depended on to their condition,
+// it is either a list or a map.
+type composeDependsOn map[string]string
+
+func (d *composeDependsOn) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*d = make(composeDependsOn)
+
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, name := range list {
+			(*d)[name] = composeServiceStarted
+		}
+		return nil
+	}
+
+	var m map[string]struct {
+		Condition string `yaml:"condition"`
+	}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	for name, dep := range m {
+		if dep.Condition == "" {
+			dep.Condition = composeServiceStarted
+		}
+		(*d)[name] = dep.Condition
+	}
+	return nil
+}
+
+type composeHealthcheck struct {
+	Test        []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+	Disable     bool
+}
+
+// composeHealthcheckRaw is a health check as written in the compose file.
+type composeHealthcheckRaw struct {
+	Test        interface{} `yaml:"test"`
+	Interval    string      `yaml:"interval"`
+	Timeout     string      `yaml:"timeout"`
+	StartPeriod string      `yaml:"start_period"`
+	Retries     int         `yaml:"retries"`
+	Disable     bool        `yaml:"disable"`
+}
+
+func (h *composeHealthcheck) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw composeHealthcheckRaw
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	switch test := raw.Test.(type) {
+	case string:
+		h.Test = []string{"CMD-SHELL", test}
+	case []interface{}:
+		for _, arg := range test {
+			h.Test = append(h.Test, fmt.Sprint(arg))
+		}
+	}
+	if len(h.Test) > 0 && h.Test[0] == "NONE" {
+		raw.Disable = true
+	}
+
+	for _, d := range []struct {
+		in  string
+		out *time.Duration
+	}{
+		{raw.Interval, &h.Interval},
+		{raw.Timeout, &h.Timeout},
+		{raw.StartPeriod, &h.StartPeriod},
+	} {
+		if d.in == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.in)
+		if err != nil {
+			return err
+		}
+		*d.out = v
+	}
+
+	h.Retries = raw.Retries
+	h.Disable = raw.Disable
+	return nil
+}
+
+// composeVolume is either a short volume spec or a map.
+type composeVolume struct {
+	Type     mount.Type
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// composeVolumeLong is the long form of a volume.
+type composeVolumeLong struct {
+	Type     string `yaml:"type"`
+	Source   string `yaml:"source"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only"`
+}
+
+func (v *composeVolume) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var short string
+	if err := unmarshal(&short); err != nil {
+		var long composeVolumeLong
+		if err = unmarshal(&long); err != nil {
+			return err
+		}
+		v.Type = mount.Type(long.Type)
+		v.Source = long.Source
+		v.Target = long.Target
+		v.ReadOnly = long.ReadOnly
+		return nil
+	}
+
+	parts := strings.Split(short, ":")
+	switch len(parts) {
+	case 1:
+		v.Type = mount.TypeVolume
+		v.Target = parts[0]
+		return nil
+	case 2, 3:
+		v.Source = parts[0]
+		v.Target = parts[1]
+		v.ReadOnly = len(parts) == 3 && strings.Contains(parts[2], "ro")
+	default:
+		return fmt.Errorf("invalid volume: %s", short)
+	}
+
+	if strings.HasPrefix(v.Source, ".") || strings.HasPrefix(v.Source, "/") || strings.HasPrefix(v.Source, "~") {
+		v.Type = mount.TypeBind
+	} else {
+		v.Type = mount.TypeVolume
+	}
+	return nil
+}
+
+// composeNetworks holds the aliases of all networks of a service, the networks
+// themselves are either a list or a map.
+type composeNetworks []string
+
+func (n *composeNetworks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		return nil
+	}
+
+	var m map[string]*struct {
+		Aliases []string `yaml:"aliases"`
+	}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if m[name] != nil {
+			*n = append(*n, m[name].Aliases...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package testingdock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+const composeYAML = `
+name: shop
+services:
+  db:
+    image: postgres:9.6
+    environment:
+      POSTGRES_PASSWORD: ${TESTINGDOCK_COMPOSE_PASSWORD:-secret}
+    ports:
+      - "5432"
+    healthcheck:
+      test: pg_isready
+      interval: 1s
+  cache:
+    image: redis
+  migrate:
+    image: oneshot
+    command: migrate -path "/migrations dir" up
+    depends_on:
+      db:
+        condition: service_healthy
+  app:
+    image: app
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+      cache:
+        condition: service_started
+    networks:
+      default:
+        aliases: [api]
+`
+
+func TestSuite_LoadCompose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "docker-compose.yml")
+	if err = ioutil.WriteFile(path, []byte(composeYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadCompose", testingdock.SuiteOpts{Client: engine})
+	if err = s.LoadCompose(path); err != nil {
+		t.Fatalf("compose loading failure: %s", err.Error())
+	}
+	for _, name := range []string{"db", "cache", "migrate", "app"} {
+		if _, ok := s.Lookup(name); !ok {
+			t.Errorf("service %s should be in the suite", name)
+		}
+	}
+
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	order := engine.CreationOrder()
+	pos := make(map[string]int)
+	for i, name := range order {
+		pos[name] = i
+	}
+	if len(order) != 4 || pos["migrate"] < pos["db"] || pos["app"] < pos["migrate"] || pos["app"] < pos["cache"] {
+		t.Errorf("services created in wrong order: %v", order)
+	}
+
+	if env := engine.ByName("db").Config.Env; !reflect.DeepEqual(env, []string{"POSTGRES_PASSWORD=secret"}) {
+		t.Errorf("wrong environment: %v", env)
+	}
+	if _, ok := engine.ByName("db").HostConfig.PortBindings["5432/tcp"]; !ok {
+		t.Error("port should be published")
+	}
+	if cmd := []string(engine.ByName("migrate").Config.Cmd); !reflect.DeepEqual(cmd, []string{"migrate", "-path", "/migrations dir", "up"}) {
+		t.Errorf("wrong command: %v", cmd)
+	}
+	if aliases := engine.ByName("app").Aliases; !reflect.DeepEqual(aliases, []string{"api"}) {
+		t.Errorf("wrong aliases: %v", aliases)
+	}
+}
+
+// writeCompose writes the compose file into a new directory, which is removed
+// when the test finishes.
+func writeCompose(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) }) // nolint: errcheck
+
+	path := filepath.Join(dir, "docker-compose.yml")
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSuite_LoadComposeInterpolation(t *testing.T) {
+	// values with YAML syntax must not corrupt the document
+	os.Setenv("TESTINGDOCK_COMPOSE_DSN", `postgres://user:p#ss@db:5432/"shop"`) // nolint: errcheck
+	os.Setenv("TESTINGDOCK_COMPOSE_RETRIES", "7")                               // nolint: errcheck
+	os.Setenv("TESTINGDOCK_COMPOSE_EMPTY", "")                                  // nolint: errcheck
+	os.Setenv("TESTINGDOCK_COMPOSE_ZIP", "0123")                                // nolint: errcheck
+	os.Setenv("TESTINGDOCK_COMPOSE_YES", "yes")                                 // nolint: errcheck
+	os.Setenv("TESTINGDOCK_COMPOSE_TRUE", "true")                               // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_DSN")                                // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_RETRIES")                            // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_EMPTY")                              // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_ZIP")                                // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_YES")                                // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_TRUE")                               // nolint: errcheck
+
+	path := writeCompose(t, `
+services:
+  app:
+    image: app
+    environment:
+      DSN: ${TESTINGDOCK_COMPOSE_DSN}
+      EMPTY: ${TESTINGDOCK_COMPOSE_EMPTY-unset}
+      DEFAULT: ${TESTINGDOCK_COMPOSE_EMPTY:-empty}
+      PRICE: $$5
+      ZIP: ${TESTINGDOCK_COMPOSE_ZIP}
+      CONFIRM: ${TESTINGDOCK_COMPOSE_YES}
+    command: ["serve", "--debug=${TESTINGDOCK_COMPOSE_TRUE}", "${TESTINGDOCK_COMPOSE_TRUE}"]
+    healthcheck:
+      test: ["CMD", "true"]
+      retries: ${TESTINGDOCK_COMPOSE_RETRIES}
+    volumes:
+      - type: bind
+        source: /etc/hosts
+        target: /etc/hosts
+        read_only: ${TESTINGDOCK_COMPOSE_TRUE}
+`)
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadComposeInterpolation", testingdock.SuiteOpts{Client: engine})
+	if err := s.LoadCompose(path); err != nil {
+		t.Fatalf("compose loading failure: %s", err.Error())
+	}
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	// substituted values stay strings, unless the key takes a number or boolean
+	expected := []string{
+		"CONFIRM=yes",
+		"DEFAULT=empty",
+		`DSN=postgres://user:p#ss@db:5432/"shop"`,
+		"EMPTY=",
+		"PRICE=$5",
+		"ZIP=0123",
+	}
+	if env := engine.ByName("app").Config.Env; !reflect.DeepEqual(env, expected) {
+		t.Errorf("wrong environment: %v, expected %v", env, expected)
+	}
+	if cmd := []string(engine.ByName("app").Config.Cmd); !reflect.DeepEqual(cmd, []string{"serve", "--debug=true", "true"}) {
+		t.Errorf("wrong command: %v", cmd)
+	}
+	if retries := engine.ByName("app").Config.Healthcheck.Retries; retries != 7 {
+		t.Errorf("wrong health check retries: %d", retries)
+	}
+	if mounts := engine.ByName("app").HostConfig.Mounts; len(mounts) != 1 || !mounts[0].ReadOnly {
+		t.Errorf("bind mount should be read-only: %v", mounts)
+	}
+}
+
+func TestSuite_LoadComposeRequiredVariable(t *testing.T) {
+	os.Setenv("TESTINGDOCK_COMPOSE_EMPTY", "")     // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_COMPOSE_EMPTY") // nolint: errcheck
+
+	for _, tc := range []struct {
+		value string
+		fails bool
+	}{
+		{"${TESTINGDOCK_COMPOSE_UNSET?the tag is required}", true},
+		{"${TESTINGDOCK_COMPOSE_UNSET:?the tag is required}", true},
+		{"${TESTINGDOCK_COMPOSE_EMPTY:?the tag is required}", true},
+		{"${TESTINGDOCK_COMPOSE_EMPTY?the tag is required}", false},
+	} {
+		path := writeCompose(t, "services:\n  app:\n    image: app:"+tc.value+"\n")
+
+		s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadComposeRequiredVariable", testingdock.SuiteOpts{Client: fake.NewEngine()})
+		err := s.LoadCompose(path)
+		if tc.fails && (err == nil || !strings.Contains(err.Error(), "the tag is required")) {
+			t.Errorf("%s: expected missing variable error, got %v", tc.value, err)
+		}
+		if !tc.fails && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.value, err.Error())
+		}
+		testingdock.UnregisterAll()
+	}
+}
+
+func TestSuite_LoadComposeStrongestCondition(t *testing.T) {
+	path := writeCompose(t, `
+services:
+  db:
+    image: postgres
+  api:
+    image: api
+    depends_on:
+      db:
+        condition: service_healthy
+  worker:
+    image: worker
+    depends_on:
+      db:
+        condition: service_started
+`)
+
+	// the services are iterated in random order, the result must not depend on it
+	for i := 0; i < 20; i++ {
+		s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadComposeStrongestCondition", testingdock.SuiteOpts{Client: fake.NewEngine()})
+		if err := s.LoadCompose(path); err != nil {
+			t.Fatalf("compose loading failure: %s", err.Error())
+		}
+		for _, cs := range s.Spec().Containers {
+			if cs.Name != "db" {
+				continue
+			}
+			if cs.HealthCheck == nil || cs.HealthCheck.Type != testingdock.HealthCheckTypeDocker {
+				t.Fatalf("db should be health checked by docker, got %+v", cs.HealthCheck)
+			}
+		}
+		testingdock.UnregisterAll()
+	}
+}
+
+func TestSuite_LoadComposeVolumes(t *testing.T) {
+	path := writeCompose(t, `
+name: shop
+services:
+  db:
+    image: postgres
+    volumes:
+      - /var/lib/postgresql/data
+      - data:/srv/data
+      - ./init:/docker-entrypoint-initdb.d:ro
+      - /etc/hosts:/etc/hosts
+      - type: tmpfs
+        target: /tmp
+      - type: volume
+        source: data
+        target: /backup
+        read_only: true
+`)
+	dir := filepath.Dir(path)
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadComposeVolumes", testingdock.SuiteOpts{Client: engine})
+	if err := s.LoadCompose(path); err != nil {
+		t.Fatalf("compose loading failure: %s", err.Error())
+	}
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	db := engine.ByName("db")
+	if _, ok := db.Config.Volumes["/var/lib/postgresql/data"]; !ok || len(db.Config.Volumes) != 1 {
+		t.Errorf("anonymous volume expected, got %v", db.Config.Volumes)
+	}
+	if engine.VolumeCount() != 1 || !db.Mounts("shop_data") {
+		t.Errorf("one named volume shop_data expected, got %d", engine.VolumeCount())
+	}
+
+	expected := map[string]mount.Mount{
+		"/srv/data":                   {Type: mount.TypeVolume, Source: "shop_data", Target: "/srv/data"},
+		"/docker-entrypoint-initdb.d": {Type: mount.TypeBind, Source: filepath.Join(dir, "init"), Target: "/docker-entrypoint-initdb.d", ReadOnly: true},
+		"/etc/hosts":                  {Type: mount.TypeBind, Source: "/etc/hosts", Target: "/etc/hosts"},
+		"/tmp":                        {Type: mount.TypeTmpfs, Target: "/tmp"},
+		"/backup":                     {Type: mount.TypeVolume, Source: "shop_data", Target: "/backup", ReadOnly: true},
+	}
+	mounts := make(map[string]mount.Mount)
+	for _, m := range db.HostConfig.Mounts {
+		mounts[m.Target] = mount.Mount{Type: m.Type, Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly}
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("wrong mounts: %v, expected %v", mounts, expected)
+	}
+}
+
+func TestSuite_LoadComposeBuild(t *testing.T) {
+	path := writeCompose(t, `
+name: shop
+services:
+  app:
+    build: ./app
+  worker:
+    image: shop/worker:dev
+    build:
+      context: worker
+      dockerfile: Dockerfile.dev
+      args:
+        VERSION: "1.2"
+        TOKEN:
+`)
+	dir := filepath.Dir(path)
+	for _, file := range []string{"app/Dockerfile", "worker/Dockerfile.dev", "worker/main.go", "worker/README.md", "worker/keep.md", "worker/tmp/cache"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte("FROM scratch\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the Dockerfile and the .dockerignore file are sent anyway
+	dockerignore := "*.md\n!keep.md\ntmp\nDockerfile.dev\n.dockerignore\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "worker/.dockerignore"), []byte(dockerignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadComposeBuild", testingdock.SuiteOpts{Client: engine})
+	if err := s.LoadCompose(path); err != nil {
+		t.Fatalf("compose loading failure: %s", err.Error())
+	}
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	// images without a name are named after the project and the service
+	if image := engine.ByName("app").Config.Image; image != "shop_app" {
+		t.Errorf("wrong image of the built service: %s", image)
+	}
+	if files := engine.BuildFiles["shop_app"]; !reflect.DeepEqual(files, []string{"Dockerfile"}) {
+		t.Errorf("wrong build context: %v", files)
+	}
+
+	build, ok := engine.Builds["shop/worker:dev"]
+	if !ok {
+		t.Fatal("worker image should be built")
+	}
+	if build.Dockerfile != "Dockerfile.dev" {
+		t.Errorf("wrong dockerfile: %s", build.Dockerfile)
+	}
+	if v := build.BuildArgs["VERSION"]; v == nil || *v != "1.2" || len(build.BuildArgs) != 2 || build.BuildArgs["TOKEN"] != nil {
+		t.Errorf("wrong build args: %v", build.BuildArgs)
+	}
+	if files := engine.BuildFiles["shop/worker:dev"]; !reflect.DeepEqual(files, []string{".dockerignore", "Dockerfile.dev", "keep.md", "main.go"}) {
+		t.Errorf("wrong build context: %v", files)
+	}
+}
+
+func TestSuite_LoadComposeExternalVolume(t *testing.T) {
+	path := writeCompose(t, `
+name: shop
+services:
+  db:
+    image: postgres
+    volumes:
+      - cache:/var/cache
+      - data:/var/lib/postgresql/data
+volumes:
+  data:
+  cache:
+    external: true
+    name: shared-cache
+`)
+
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadComposeExternalVolume", testingdock.SuiteOpts{Client: engine})
+	if err := s.LoadCompose(path); err == nil || !strings.Contains(err.Error(), "external volume shared-cache does not exist") {
+		t.Fatalf("missing external volume should fail, got %v", err)
+	}
+
+	if _, err := engine.VolumeCreate(ctx, volume.VolumeCreateBody{Name: "shared-cache"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadCompose(path); err != nil {
+		t.Fatalf("compose loading failure: %s", err.Error())
+	}
+	s.Start(ctx)
+
+	db := engine.ByName("db")
+	if !db.Mounts("shared-cache") || !db.Mounts("shop_data") {
+		t.Errorf("external and named volume should be mounted: %v", db.HostConfig.Mounts)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the external volume is not owned by the suite
+	if vl, _ := engine.VolumeList(ctx, filters.NewArgs()); len(vl.Volumes) != 1 || vl.Volumes[0].Name != "shared-cache" { // nolint: errcheck
+		t.Errorf("only the external volume should be left, got %v", vl.Volumes)
+	}
+}
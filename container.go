@@ -1,24 +1,31 @@
 package testingdock
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	b64 "encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
-	"testing"
 	"time"
 
 	clicfg "github.com/docker/cli/cli/config"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
 )
 
 // HealthCheckFunc is the type of a health checking function, which is supposed
@@ -52,6 +59,27 @@ func HealthCheckHTTP(url string) HealthCheckFunc {
 	}
 }
 
+// HealthCheckTCP is a pre-implemented HealthCheckFunc which checks if a
+// connection to the given address can be established.
+func HealthCheckTCP(addr string) HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HealthCheckExec is a pre-implemented HealthCheckFunc which checks if the
+// given command exits with code 0 when executed in the container.
+func HealthCheckExec(cmd ...string) HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		return execute(ctx, c.cli, c.ID, cmd)
+	}
+}
+
 // HealthCheckCustom is just a convenience wrapper to set a HealthCheckFunc without any arguments.
 func HealthCheckCustom(fn func() error) HealthCheckFunc {
 	return func(ctx context.Context, c *Container) error {
@@ -70,6 +98,14 @@ func resetRestart() ResetFunc {
 	}
 }
 
+// ResetExec is a pre-implemented ResetFunc, which executes the given command
+// in the container and fails unless it exits with code 0.
+func ResetExec(cmd ...string) ResetFunc {
+	return func(ctx context.Context, c *Container) error {
+		return execute(ctx, c.cli, c.ID, cmd)
+	}
+}
+
 // ResetCustom is just a convenience wrapper to set a ResetFunc.
 func ResetCustom(fn func() error) ResetFunc {
 	return func(ctx context.Context, c *Container) error {
@@ -95,6 +131,81 @@ type ContainerOpts struct {
 	// Function called when the containers are reset. The zero value is
 	// a function, which will restart the container completely.
 	Reset ResetFunc
+	// Reuse keeps the container running after Close, so the next test run
+	// can adopt it instead of creating a new one. The container configuration,
+	// the ID of its image and its build context are hashed into a label and the
+	// container is only recreated if the hash changes. This only has an effect if the network is reused as well, see
+	// NetworkOpts.Reuse.
+	Reuse bool
+	// Build builds the image from a Dockerfile instead of pulling it,
+	// Config.Image is used as tag of the built image.
+	Build *BuildOpts
+	// additional aliases of the container within the network
+	Aliases []string
+	// files copied into the container before it is started
+	Fixtures []Fixture
+	// volumes of the suite mounted into the container
+	Volumes []VolumeMount
+	// directories backed by memory instead of disk, e.g. the data directory
+	// of a database, which speeds up writes a lot
+	Tmpfs []string
+	// Lifecycle hooks, a failing hook fails the test. PostCreate is called after
+	// the container was created and the fixtures were copied, but before it is
+	// started. It is not called for adopted reused containers. The hooks up to
+	// PostHealthy are called again when Container.Restart or Suite.Restore recreates
+	// the container, their failures are returned by it.
+	PostCreate HookFunc
+	// called after the container was started or adopted
+	PostStart HookFunc
+	// called after the container passed its health check on start, before
+	// the children are started, e.g. to run migrations
+	PostHealthy HookFunc
+	// called before the ResetFunc
+	PreReset HookFunc
+	// called after the container passed its health check on reset, before the
+	// children are reset, e.g. to load fixtures again
+	PostReset HookFunc
+	// called before the container and its children are closed, a failure
+	// is reported, but does not stop the teardown
+	PreClose HookFunc
+	// CPU, memory and pids limits, overriding the ones in HostConfig
+	Resources *Resources
+}
+
+// HookFunc is the type of the functions called during the lifecycle of a container,
+// e.g. to run migrations or load fixtures.
+type HookFunc func(ctx context.Context, c *Container) error
+
+// ChainHooks returns a hook calling the given hooks one after another, until
+// one of them fails. Nil hooks are skipped, e.g. to run the hooks of a user
+// after the ones of a module.
+func ChainHooks(hooks ...HookFunc) HookFunc {
+	return func(ctx context.Context, c *Container) error {
+		for _, hook := range hooks {
+			if hook == nil {
+				continue
+			}
+			if err := hook(ctx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// hooks are the lifecycle hooks of a container, see ContainerOpts.
+type hooks struct {
+	postCreate, postStart, postHealthy HookFunc
+	preReset, postReset, preClose      HookFunc
+}
+
+// Fixture is a file or directory on the host, which is copied into a container.
+type Fixture struct {
+	// path of the file or directory on the host
+	Source string `yaml:"source" json:"source"`
+	// directory in the container the file or the content of the directory is
+	// copied to, it has to exist in the image
+	Target string `yaml:"target" json:"target"`
 }
 
 // Container is a docker container configuration,
@@ -102,9 +213,9 @@ type ContainerOpts struct {
 // This should usually be created via the NewContainer
 // function.
 type Container struct { // nolint: maligned
-	t                  testing.TB
+	t                  Reporter
 	forcePull          bool
-	cli                *client.Client
+	cli                client.APIClient
 	network            *Network
 	ccfg               *container.Config
 	hcfg               *container.HostConfig
@@ -113,13 +224,61 @@ type Container struct { // nolint: maligned
 	healthchecktimeout time.Duration
 	// children are dependencies that are started after the main container
 	children []*Container
-	cancel   func()
-	resetF   ResetFunc
-	closed   bool
+	// mu guards cancel, closed and logCtx, which are accessed by start and close
+	// from different goroutines
+	mu     sync.Mutex
+	cancel func(ctx context.Context) error
+	resetF ResetFunc
+	closed bool
+	// logCtx is the context of the start, the logs of the container are followed
+	// with it, also once it has been recreated by Restart or Suite.Restore
+	logCtx context.Context
+	// lifecycle serializes Restart and close, so that a container is not
+	// closed while it is being recreated
+	lifecycle sync.Mutex
+	// dockerName is the name of the docker container, which differs from
+	// the logical Name if the suite isolates names
+	dockerName string
+	reuse      bool
+	build      *BuildOpts
+	aliases    []string
+	// whether the container belongs to a shared suite, whose running containers
+	// are attached to regardless of the image, which the first session decides on
+	shared bool
+	// ID of the image and hash of the build context, which are part of the hash
+	// of reused containers once resolved
+	imageID, contextHash string
+	// needs are started elsewhere in the tree, but have to be ready before
+	// this container is created, ready is closed once it passed its health check
+	needs     []*Container
+	ready     chan struct{}
+	readyOnce sync.Once
+	fixtures  []Fixture
+	volumes   []VolumeMount
+	tmpfs     []string
+	// mounts of the host config, without the volumes of the suite
+	mounts []mount.Mount
+	// strategies the container was configured with by a Spec, which are
+	// written back when dumping the suite
+	healthSpec *HealthCheckSpec
+	resetSpec  *ResetSpec
+	hooks      hooks
+	// network faults injected by the tests, which are removed on reset and close
+	faults faults
+	// written back when dumping the suite
+	resources *Resources
+	// limits the number of containers of the suite starting at once, if set
+	sem chan struct{}
+	// phases of starting the container, see Suite.Timings
+	timings timings
+	// samples the resource usage, nil unless the suite collects stats
+	stats *statsCollector
+	// set while the container is recreated from a snapshot of the suite
+	snapshot *containerSnapshot
 }
 
 // Creates a new container configuration with the given options.
-func newContainer(t testing.TB, c *client.Client, opts ContainerOpts) *Container {
+func newContainer(t Reporter, c client.APIClient, opts ContainerOpts) *Container {
 	// set default
 	if opts.HealthCheckTimeout == 0 { // zero value
 		opts.HealthCheckTimeout = 30 * time.Second
@@ -128,11 +287,48 @@ func newContainer(t testing.TB, c *client.Client, opts ContainerOpts) *Container
 	// always autoremove
 	if opts.HostConfig == nil {
 		opts.HostConfig = &container.HostConfig{}
+	} else {
+		// the host config may be shared by several containers, so the mounts,
+		// tmpfs and limits of this one are added to a copy
+		hcfg := *opts.HostConfig
+		hcfg.Mounts = append([]mount.Mount(nil), hcfg.Mounts...)
+		if hcfg.Tmpfs != nil {
+			hcfg.Tmpfs = make(map[string]string, len(opts.HostConfig.Tmpfs))
+			for path, options := range opts.HostConfig.Tmpfs {
+				hcfg.Tmpfs[path] = options
+			}
+		}
+		opts.HostConfig = &hcfg
 	}
 	opts.HostConfig.AutoRemove = true
 
+	if opts.Resources != nil {
+		opts.Resources.apply(opts.HostConfig)
+	}
+
+	mounts := opts.HostConfig.Mounts
+	for _, vm := range opts.Volumes {
+		opts.HostConfig.Mounts = append(opts.HostConfig.Mounts, mount.Mount{
+			Type:          mount.TypeVolume,
+			Source:        vm.Volume.dockerName,
+			Target:        vm.Target,
+			ReadOnly:      vm.ReadOnly,
+			VolumeOptions: &mount.VolumeOptions{NoCopy: vm.NoCopy},
+		})
+	}
+	if len(opts.Tmpfs) > 0 && opts.HostConfig.Tmpfs == nil {
+		opts.HostConfig.Tmpfs = make(map[string]string)
+	}
+	for _, path := range opts.Tmpfs {
+		opts.HostConfig.Tmpfs[path] = ""
+	}
+
 	// set testingdock label
-	opts.Config.Labels = createTestingLabel()
+	if opts.Reuse {
+		opts.Config.Labels = createReuseLabel()
+	} else {
+		opts.Config.Labels = createTestingLabel()
+	}
 
 	// set default resetFunc
 	if opts.Reset == nil {
@@ -143,6 +339,7 @@ func newContainer(t testing.TB, c *client.Client, opts ContainerOpts) *Container
 		t:                  t,
 		forcePull:          opts.ForcePull,
 		Name:               opts.Name,
+		dockerName:         opts.Name,
 		healthcheck:        opts.HealthCheck,
 		healthchecktimeout: opts.HealthCheckTimeout,
 		cli:                c,
@@ -150,6 +347,23 @@ func newContainer(t testing.TB, c *client.Client, opts ContainerOpts) *Container
 		hcfg:               opts.HostConfig,
 		resetF:             opts.Reset,
 		Image:              opts.Config.Image,
+		reuse:              opts.Reuse,
+		build:              opts.Build,
+		aliases:            opts.Aliases,
+		ready:              make(chan struct{}),
+		fixtures:           opts.Fixtures,
+		volumes:            opts.Volumes,
+		tmpfs:              opts.Tmpfs,
+		mounts:             mounts,
+		resources:          opts.Resources,
+		hooks: hooks{
+			postCreate:  opts.PostCreate,
+			postStart:   opts.PostStart,
+			postHealthy: opts.PostHealthy,
+			preReset:    opts.PreReset,
+			postReset:   opts.PostReset,
+			preClose:    opts.PreClose,
+		},
 	}
 
 	// set default healthcheck
@@ -165,18 +379,89 @@ func (c *Container) start(ctx context.Context) { // nolint: gocyclo
 	if c.network == nil {
 		c.t.Fatalf("Container %s not added to any network!", c.Name)
 	}
+	c.mu.Lock()
+	c.logCtx = ctx
+	c.mu.Unlock()
+
+	if c.build != nil {
+		now := time.Now()
+		c.buildImage(ctx)
+		c.timings.record("build", now)
+	} else {
+		c.pullImage(ctx)
+	}
+
+	// wait for the containers this one needs besides its parent
+	now := time.Now()
+	for _, cc := range c.needs {
+		select {
+		case <-cc.ready:
+		case <-ctx.Done():
+			c.t.Fatalf("container %s was not ready in time for %s: %s", cc.Name, c.Name, ctx.Err())
+		}
+	}
+	if len(c.needs) > 0 {
+		c.timings.record("needs", now)
+	}
+
+	release := c.acquire(ctx)
+	// a failing create, hook or health check ends the goroutine, which
+	// must not keep the slot of the other containers
+	defer release()
+	if c.reuse {
+		if !c.shared {
+			c.resolveImage(ctx)
+		}
+		c.ccfg.Labels[labelHash] = c.configHash()
+	}
+	now = time.Now()
+	if c.reuse && c.adopt(ctx) {
+		c.timings.record("adopt", now)
+	} else {
+		c.initialCleanup(ctx)
+		c.timings.record("cleanup", now)
+		c.create(ctx)
+	}
+	if err := c.afterStart(ctx); err != nil {
+		c.t.Fatalf("%s", err.Error())
+	}
+	release()
+	c.timings.markReady()
+	c.readyOnce.Do(func() {
+		close(c.ready)
+	})
+
+	// start children
+	if !SpawnSequential {
+		printf("(setup ) %-25s (%s) - container is spawning %d child containers in parallel", c.Name, c.ID, len(c.children))
+	}
+	now = time.Now()
+	spawn(c.t, c.children, func(cont *Container) {
+		cont.start(ctx)
+	})
+	if len(c.children) > 0 {
+		c.timings.record("children", now)
+	}
+}
 
+// pullImage pulls the image of the container, unless it already exists locally
+// and pulling is not forced.
+func (c *Container) pullImage(ctx context.Context) {
 	imageListArgs := filters.NewArgs()
 	imageListArgs.Add("reference", c.ccfg.Image)
 
+	now := time.Now()
 	images, err := c.cli.ImageList(ctx, types.ImageListOptions{Filters: imageListArgs})
 	if err != nil {
 		c.t.Fatalf("image listing failure: %s", err.Error())
 	}
+	c.timings.record("resolve", now)
 
 	if len(images) == 0 || c.forcePull {
+		now = time.Now()
+		defer c.timings.record("pull", now)
 		printf("(setup) %-25s - pulling image", c.ccfg.Image)
-		img, err := c.imagePull(ctx)
+		img, err := imagePull(ctx, c.cli, c.ccfg.Image)
 		if err != nil {
 			c.t.Fatalf("image downloading failure of '%s': %s", c.ccfg.Image, err.Error())
 		}
@@ -188,98 +473,222 @@ func (c *Container) start(ctx context.Context) { // nolint: gocyclo
 		}
 		printf("(setup) %-25s - successfully pulled image", c.ccfg.Image)
 	}
+}
 
-	c.initialCleanup(ctx)
+// resolveImage records the ID of the image of the container, so that a reused
+// container is not adopted once another image is tagged with its name, e.g. after
+// a rebuild or a pull.
+func (c *Container) resolveImage(ctx context.Context) {
+	img, _, err := c.cli.ImageInspectWithRaw(ctx, c.ccfg.Image)
+	if err != nil {
+		c.t.Fatalf("image inspect failure of '%s': %s", c.ccfg.Image, err.Error())
+	}
+	c.imageID = img.ID
+}
 
+// afterStart runs the post start hook, starts collecting stats and following the
+// logs of the created container and blocks until it passed its health check and the
+// post healthy hook. Failures are returned instead of failing the test, as Restart
+// runs it as well.
+func (c *Container) afterStart(ctx context.Context) error {
+	if err := c.callHook(ctx, "post start", c.hooks.postStart); err != nil {
+		return err
+	}
+	c.startStats()
+
+	// start container logging, which outlives the context of a Restart
+	if Verbose {
+		c.mu.Lock()
+		logCtx := c.logCtx
+		c.mu.Unlock()
+		if logCtx == nil {
+			logCtx = context.Background()
+		}
+		go c.followLogs(logCtx)
+	}
+
+	now := time.Now()
+	if err := c.waitHealthy(ctx); err != nil {
+		return err
+	}
+	c.timings.record("health check", now)
+	return c.callHook(ctx, "post healthy", c.hooks.postHealthy)
+}
+
+// create creates and starts the docker container.
+func (c *Container) create(ctx context.Context) {
+	if err := c.createContainer(ctx); err != nil {
+		c.t.Fatalf("%s", err.Error())
+	}
+}
+
+// createContainer creates and starts the docker container, failures are
+// returned instead of failing the test.
+func (c *Container) createContainer(ctx context.Context) error {
 	hcfg := *c.hcfg
-	hcfg.NetworkMode = container.NetworkMode(c.network.name)
+	hcfg.NetworkMode = container.NetworkMode(c.network.dockerName)
+	engine := engineOf(c.cli)
+	if engine.Name == "docker" && !engine.Desktop {
+		hcfg.ExtraHosts = withHostInternal(hcfg.ExtraHosts, c.network.gateway)
+	}
+	if !engine.Limits && clearLimits(&hcfg) {
+		printf("(setup ) %-25s - resource limits are not supported by the engine and ignored", c.Name)
+	}
 
-	cont, err := c.cli.ContainerCreate(ctx, c.ccfg, &hcfg, nil, c.Name)
+	aliases := c.networkAliases()
+	var ncfg *network.NetworkingConfig
+	if len(aliases) > 0 {
+		ncfg = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				c.network.dockerName: {Aliases: aliases},
+			},
+		}
+	}
+
+	ccfg := c.ccfg
+	if c.snapshot != nil {
+		// keep the host ports, so that addresses known to the tests stay valid
+		cfg := *c.ccfg
+		cfg.Image = c.snapshot.image
+		ccfg = &cfg
+		hcfg.PortBindings = c.snapshot.ports
+	}
+
+	now := time.Now()
+	cont, err := c.cli.ContainerCreate(ctx, ccfg, &hcfg, ncfg, c.dockerName)
 	if err != nil {
-		c.t.Fatalf("container creation failure: %s", err.Error())
+		return fmt.Errorf("container creation failure: %s", err.Error())
 	}
 
-	c.ID = cont.ID
+	c.setCancel(cont.ID)
 
-	c.cancel = func() {
-		if c.closed {
-			return
-		}
-		if err := c.cli.NetworkDisconnect(ctx, c.network.id, c.ID, true); err != nil {
-			c.t.Fatalf("container disconnect failure: %s", err.Error())
+	for _, f := range c.fixtures {
+		content, err := tarPath(f.Source)
+		if err != nil {
+			return fmt.Errorf("fixture failure: %s", err.Error())
 		}
-		printf("(cancel) %-25s (%s) - container disconnected from: %s", c.Name, c.ID, c.network.name)
-		if err := c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
-			c.t.Fatalf("container removal failure: %s", err.Error())
+		if err = c.cli.CopyToContainer(ctx, c.ID, f.Target, content, types.CopyToContainerOptions{}); err != nil {
+			return fmt.Errorf("fixture copy failure of '%s': %s", f.Source, err.Error())
 		}
-		printf("(cancel) %-25s (%s) - container removed", c.Name, c.ID)
+		printf("(setup ) %-25s (%s) - fixture copied: %s", c.Name, c.ID, f.Source)
+	}
+	c.timings.record("create", now)
+	if err = c.callHook(ctx, "post create", c.hooks.postCreate); err != nil {
+		return err
 	}
 
 	// start the container finally
+	now = time.Now()
 	if err = c.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
-		c.t.Fatalf("container start failure: %s", err.Error())
+		return fmt.Errorf("container start failure: %s", err.Error())
 	}
+	c.timings.record("start", now)
 
 	printf("(setup ) %-25s (%s) - container started", c.Name, c.ID)
+	return nil
+}
 
-	// start container logging
-	if Verbose {
-		go func() {
-			reader, gerr := c.cli.ContainerLogs(ctx, cont.ID, types.ContainerLogsOptions{
-				ShowStdout: true,
-				ShowStderr: true,
-				Follow:     true,
-			})
-			if gerr != nil {
-				c.t.Fatalf("container logging failure: %s", gerr.Error())
-			}
-			printf("(loggi ) %-25s (%s) - container logging started", c.Name, c.ID)
+// networkAliases returns the aliases of the container in the network, which make
+// it reachable under its logical name.
+func (c *Container) networkAliases() []string {
+	if c.dockerName != c.Name {
+		return append([]string{c.Name}, c.aliases...)
+	}
+	return c.aliases
+}
 
-			scanner := bufio.NewScanner(reader)
-			for scanner.Scan() { // scanner loop
-				if line := scanner.Text(); len(line) > 0 {
-					printf("(clogs ) %-25s (%s) - %s", c.Name, c.ID, line)
+// setCancel makes the docker container with the given ID the one of this
+// configuration and sets the cancel function, which removes it on close, unless
+// it is reused.
+func (c *Container) setCancel(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-				}
-			}
+	c.ID = id
 
-			serr := scanner.Err()
-			if serr != nil && serr != io.EOF {
-				c.t.Fatalf("container logging failure: %s", serr.Error())
-			} else {
-				printf("(loggi ) %-25s (%s) - %s", c.Name, c.ID, "EOF reached, stopping logging")
-				return // io.EOF, stop goroutine
-			}
-		}()
+	if c.reuse {
+		c.cancel = func(ctx context.Context) error {
+			printf("(cancel) %-25s (%s) - container kept for reuse", c.Name, c.ID)
+			return nil
+		}
+		return
 	}
 
-	c.executeHealthCheck(ctx)
+	// the teardown context is passed in by close, so that a cancelled or
+	// expired start context does not prevent the container from being removed
+	// containers already removed otherwise, e.g. by Suite.Remove, are skipped.
+	// Failures are returned instead of failing the test, as close also runs
+	// from the signal handler and from t.Cleanup after the test finished.
+	c.cancel = func(ctx context.Context) error {
+		// a container, which has already been detached from the network, is still
+		// removed, removing it disconnects it anyway
+		if err := c.cli.NetworkDisconnect(ctx, c.network.id, c.ID, true); err == nil {
+			printf("(cancel) %-25s (%s) - container disconnected from: %s", c.Name, c.ID, c.network.name)
+		} else if !client.IsErrNotFound(err) {
+			printf("(cancel) %-25s (%s) - container disconnect failure: %s", c.Name, c.ID, err.Error())
+		}
+		if err := c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); client.IsErrNotFound(err) {
+			printf("(cancel) %-25s (%s) - container already removed", c.Name, c.ID)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("container removal failure of %s: %s", c.Name, err.Error())
+		}
+		printf("(cancel) %-25s (%s) - container removed", c.Name, c.ID)
+		return nil
+	}
+}
 
-	// start children
-	if SpawnSequential {
-		for _, cont := range c.children {
-			cont.start(ctx)
+// adopt makes a running container, which was created by an earlier test run
+// with the same configuration hash, the docker container of this configuration.
+// Returns false if there is no such container.
+func (c *Container) adopt(ctx context.Context) bool {
+	containers, err := findContainerByName(ctx, c.cli, c.dockerName)
+	if err != nil {
+		c.t.Fatalf("container listing failure: %s", err.Error())
+	}
+	for _, cont := range containers {
+		if !isOwnedByTestingdock(cont.Labels) || cont.Labels[labelHash] != c.ccfg.Labels[labelHash] || cont.State != "running" {
+			continue
 		}
-	} else {
-		printf("(setup ) %-25s (%s) - container is spawning %d child containers in parallel", c.Name, c.ID, len(c.children))
 
-		var wg sync.WaitGroup
+		c.setCancel(cont.ID)
+		printf("(setup ) %-25s (%s) - container reused", c.Name, c.ID)
+		return true
+	}
 
-		wg.Add(len(c.children))
-		for _, cont := range c.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.start(ctx)
-			}(cont)
-		}
-		wg.Wait()
+	return false
+}
+
+// configHash hashes the container configuration, without the labels,
+// which contain the hash itself, and the image and build context, once
+// resolved. The ones of shared containers are left out.
+func (c *Container) configHash() string {
+	ccfg := *c.ccfg
+	ccfg.Labels = nil
+	var image, buildCtx string
+	if !c.shared {
+		image, buildCtx = c.imageID, c.contextHash
+	}
+
+	b, err := json.Marshal(struct {
+		Config       *container.Config
+		HostConfig   *container.HostConfig
+		Image        string `json:",omitempty"`
+		BuildContext string `json:",omitempty"`
+	}{&ccfg, c.hcfg, image, buildCtx})
+	if err != nil {
+		c.t.Fatalf("container configuration hashing failure: %s", err.Error())
 	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
 }
 
-// Find containers by the given name.
-func findContainerByName(ctx context.Context, cli *client.Client, name string) ([]types.Container, error) {
+// Find containers by the given name. The name filter of docker matches substrings,
+// so the name is anchored to not match e.g. "postgres2" for "postgres".
+func findContainerByName(ctx context.Context, cli client.APIClient, name string) ([]types.Container, error) {
 	containerListArgs := filters.NewArgs()
-	containerListArgs.Add("name", name)
+	containerListArgs.Add("name", "^/"+regexp.QuoteMeta(name)+"$")
 	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
 		Filters: containerListArgs,
 	})
@@ -294,7 +703,7 @@ func findContainerByName(ctx context.Context, cli *client.Client, name string) (
 // the current Container configuration. Only containers with the
 // label "owner=testingdock" are removed.
 func (c *Container) initialCleanup(ctx context.Context) {
-	containers, err := findContainerByName(ctx, c.cli, c.Name)
+	containers, err := findContainerByName(ctx, c.cli, c.dockerName)
 	if err != nil {
 		c.t.Fatalf("container listing failure: %s", err.Error())
 	}
@@ -308,39 +717,53 @@ func (c *Container) initialCleanup(ctx context.Context) {
 			}
 			printf("(setup ) %-25s (%s) - container removed", cont.Names[0], cont.ID)
 		} else {
-			c.t.Fatalf("container with name %s already exists, but wasn't started by tesingdock, aborting!", c.Name)
+			c.t.Fatalf("container with name %s already exists, but wasn't started by tesingdock, aborting!", c.dockerName)
 		}
 	}
 }
 
 // Closes a container and its children. This calls the
-// 'cancel' function set in the Container struct.
-// Implements io.Closer interface.
-func (c *Container) close() error {
-	if SpawnSequential {
-		for _, cont := range c.children {
-			cont.close() // nolint: errcheck
+// 'cancel' function set in the Container struct with the
+// given teardown context. Closing a container more than once is a no-op.
+// Failures do not stop the teardown, they are all returned at the end.
+func (c *Container) close(ctx context.Context) error {
+	// a container being recreated by Restart is closed once it is done
+	c.lifecycle.Lock()
+	defer c.lifecycle.Unlock()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	cancel := c.cancel
+	c.mu.Unlock()
+
+	var errs []error
+	// only started containers are closed by the hook
+	if cancel != nil && c.hooks.preClose != nil {
+		if err := c.hooks.preClose(ctx, c); err != nil {
+			errs = append(errs, fmt.Errorf("container pre close hook failure of %s: %s", c.Name, err.Error()))
 		}
-	} else {
-		var wg sync.WaitGroup
+	}
 
-		wg.Add(len(c.children))
-		for _, cont := range c.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.close() // nolint: errcheck
-			}(cont)
-		}
-		wg.Wait()
+	if err := closeAll(ctx, c.children); err != nil {
+		errs = append(errs, err)
 	}
 
-	// if the container failed to start c.cancel will not be set
-	if c.cancel != nil {
-		c.cancel()
+	// if the container failed to start cancel will not be set
+	if cancel != nil {
+		if err := c.clearFaults(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("container fault removal failure of %s: %s", c.Name, err.Error()))
+		}
+		c.stopStats()
+		if err := cancel(ctx); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	c.closed = true
-	return nil
+	return joinErrors(errs)
 }
 
 // After adds a child container (dependency, sort of)
@@ -350,14 +773,27 @@ func (c *Container) After(cc *Container) {
 	c.children = append(c.children, cc)
 }
 
+// Needs makes the container wait until the given containers passed their health
+// check, before it is created. Unlike After, this allows a container to depend on
+// containers in other branches of the tree. The given containers have to be
+// started elsewhere in the same network and must not wait for this one.
+func (c *Container) Needs(cc ...*Container) {
+	c.needs = append(c.needs, cc...)
+}
+
 // Calls the ResetFunc set in the Container struct for the
 // whole configuration, including children containers.
 // Aborts early if there is any error during reset.
 func (c *Container) reset(ctx context.Context) {
+	if err := c.clearFaults(ctx); err != nil {
+		c.t.Fatalf("container fault removal failure: %s", err.Error())
+	}
+	c.runHook(ctx, "pre reset", c.hooks.preReset)
 	if err := c.resetF(ctx, c); err != nil {
 		c.t.Fatalf("container reset failure: %s", err.Error())
 	}
 	c.executeHealthCheck(ctx)
+	c.runHook(ctx, "post reset", c.hooks.postReset)
 
 	for _, cc := range c.children {
 		cc.reset(ctx)
@@ -366,28 +802,86 @@ func (c *Container) reset(ctx context.Context) {
 	printf("(reset ) %-25s (%s) - container reset", c.Name, c.ID)
 }
 
+// runHook calls the given hook, if it is set.
+func (c *Container) runHook(ctx context.Context, name string, hook HookFunc) {
+	if err := c.callHook(ctx, name, hook); err != nil {
+		c.t.Fatalf("%s", err.Error())
+	}
+}
+
+// callHook is like runHook, but returns the failure instead of failing the test.
+func (c *Container) callHook(ctx context.Context, name string, hook HookFunc) error {
+	if hook == nil {
+		return nil
+	}
+	now := time.Now()
+	if err := hook(ctx, c); err != nil {
+		return fmt.Errorf("container %s hook failure: %s", name, err.Error())
+	}
+	c.timings.record(name+" hook", now)
+	printf("(hook  ) %-25s (%s) - %s hook finished", c.Name, c.ID, name)
+	return nil
+}
+
+// Exec runs the command in the running container and returns an error containing
+// its output, unless it exits with code 0.
+func (c *Container) Exec(ctx context.Context, cmd ...string) error {
+	return execute(ctx, c.cli, c.ID, cmd)
+}
+
+// execute runs the command in the container with the given ID and returns an
+// error containing its output, unless it exits with code 0.
+func execute(ctx context.Context, cli client.APIClient, id string, cmd []string) error {
+	ex, err := cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := cli.ContainerExecAttach(ctx, ex.ID, types.ExecStartCheck{})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var out bytes.Buffer
+	if _, err = stdcopy.StdCopy(&out, &out, res.Reader); err != nil {
+		return err
+	}
+
+	ins, err := cli.ContainerExecInspect(ctx, ex.ID)
+	if err != nil {
+		return err
+	}
+	if ins.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d: %s", strings.Join(cmd, " "), ins.ExitCode, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
 // Blocks until either the healthcheck returns no error or the context
 // is cancelled.
 func (c *Container) executeHealthCheck(ctx context.Context) {
-	ctx, cancel := context.WithTimeout(ctx, c.healthchecktimeout)
-	defer cancel()
-InfLoop:
-	for {
-		select {
-		case <-ctx.Done():
-			c.t.Fatalf("health check failure: %s", ctx.Err())
-		case <-time.After(1 * time.Second):
-			if err := c.healthcheck(ctx, c); err != nil {
-				printf("(setup ) %-25s (%s) - container health failure: %s", c.Name, c.ID, err.Error())
-				continue InfLoop
-			}
-			break InfLoop
-		}
+	if err := c.waitHealthy(ctx); err != nil {
+		c.t.Fatalf("%s", err.Error())
+	}
+}
+
+// waitHealthy is like executeHealthCheck, but returns the failure instead of
+// failing the test.
+func (c *Container) waitHealthy(ctx context.Context) error {
+	opts := WaitOpts{Interval: time.Second, Timeout: c.healthchecktimeout}
+	if err := c.poll(ctx, c.healthcheck, opts, "(setup ) %-25s (%s) - container health failure: %s"); err != nil {
+		return fmt.Errorf("health check failure: %s", err.Error())
 	}
+	return nil
 }
 
 // wrapper around cli.ImagePull to fill ImagePullOptions with authentication information, if any.
-func (c *Container) imagePull(ctx context.Context) (io.ReadCloser, error) {
+func imagePull(ctx context.Context, cli client.APIClient, image string) (io.ReadCloser, error) {
 	pullOptions := types.ImagePullOptions{}
 
 	// https://github.com/docker/distribution/blob/master/reference/reference.go#L7
@@ -397,7 +891,7 @@ func (c *Container) imagePull(ctx context.Context) (io.ReadCloser, error) {
 	//
 	// There is an undocumented hack to determine whether the first component is an actual domain, but it's
 	// shit: https://github.com/docker/distribution/blob/545102ea07aa9796f189d82f606b7c27d7aa3ed3/reference/normalize.go#L62
-	nameParts := strings.SplitN(c.ccfg.Image, "/", 2)
+	nameParts := strings.SplitN(image, "/", 2)
 
 	// get the credentials
 	if len(nameParts) >= 2 { // e.g.: quay.io/hans/myimage:latest
@@ -410,11 +904,11 @@ func (c *Container) imagePull(ctx context.Context) (io.ReadCloser, error) {
 		if err == nil {
 			pullOptions.RegistryAuth = token
 		} else {
-			printf("(setup) %-25s - failed to get credentials, not fatal (%s)", c.ccfg.Image, err)
+			printf("(setup) %-25s - failed to get credentials, not fatal (%s)", image, err)
 		}
 	}
 
-	return c.cli.ImagePull(ctx, c.ccfg.Image, pullOptions)
+	return cli.ImagePull(ctx, image, pullOptions)
 }
 
 // get credentials from ~/.docker/config.json
@@ -467,6 +961,47 @@ func healthCheckRunning() HealthCheckFunc {
 	}
 }
 
+// HealthCheckDocker is a pre-implemented HealthCheckFunc, which checks the
+// status of the HEALTHCHECK defined in the image or in Config.Healthcheck.
+func HealthCheckDocker() HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		cjson, err := c.Inspect(ctx)
+		if err != nil {
+			return err
+		}
+
+		health := cjson.ContainerJSONBase.State.Health
+		if health == nil {
+			return fmt.Errorf("container has no docker health check")
+		}
+		if health.Status != types.Healthy {
+			return fmt.Errorf("container health status: %s", health.Status)
+		}
+		return nil
+	}
+}
+
+// healthCheckExited is a pre-implemented HealthCheckFunc for one-off containers,
+// e.g. running migrations, which checks if the container exited successfully.
+// The container must not be removed automatically for this to work.
+func healthCheckExited() HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		cjson, err := c.Inspect(ctx)
+		if err != nil {
+			return err
+		}
+
+		state := cjson.ContainerJSONBase.State
+		if state.Running || state.Status == "created" {
+			return fmt.Errorf("container not exited yet")
+		}
+		if state.ExitCode != 0 {
+			return fmt.Errorf("container exited with code %d", state.ExitCode)
+		}
+		return nil
+	}
+}
+
 // Inspect gives container information in JSON format, similar to the 'docker inspect'
 // command. The container must be running for this to work, otherwise it will return
 // an error.
@@ -477,3 +1012,82 @@ func (c *Container) Inspect(ctx context.Context) (*types.ContainerJSON, error) {
 	}
 	return &cjson, nil
 }
+
+// HostPort returns the port on the docker host the given container port, e.g.
+// "5432/tcp", is published on. This is useful if docker picked a random host port.
+func (c *Container) HostPort(ctx context.Context, port nat.Port) (string, error) {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range cjson.NetworkSettings.Ports[port] {
+		if b.HostPort != "" {
+			return b.HostPort, nil
+		}
+	}
+	return "", fmt.Errorf("port %s of container %s is not published", port, c.Name)
+}
+
+// IP returns the IP address of the container in the network of the suite.
+func (c *Container) IP(ctx context.Context) (string, error) {
+	ips, err := c.IPs(ctx)
+	if err != nil {
+		return "", err
+	}
+	ip, ok := ips[c.network.dockerName]
+	if !ok {
+		return "", fmt.Errorf("container %s is not connected to network %s", c.Name, c.network.dockerName)
+	}
+	return ip, nil
+}
+
+// IPs returns the IP addresses of the container by the names of the docker
+// networks it is connected to.
+func (c *Container) IPs(ctx context.Context) (map[string]string, error) {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make(map[string]string)
+	for name, es := range cjson.NetworkSettings.Networks {
+		ips[name] = es.IPAddress
+	}
+	return ips, nil
+}
+
+// Ports returns the published ports of the container, mapping the container
+// ports, e.g. "5432/tcp", to the ports on the docker host.
+func (c *Container) Ports(ctx context.Context) (map[nat.Port]string, error) {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[nat.Port]string)
+	for port, bindings := range cjson.NetworkSettings.Ports {
+		for _, b := range bindings {
+			if b.HostPort != "" {
+				ports[port] = b.HostPort
+				break
+			}
+		}
+	}
+	return ports, nil
+}
+
+// HostAddr returns the address, under which the given published container port
+// is reachable from the tests, e.g. "localhost:32768".
+func (c *Container) HostAddr(ctx context.Context, port nat.Port) (string, error) {
+	p, err := c.HostPort(ctx, port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(daemonHostname(c.cli), p), nil
+}
+
+// DockerName returns the name of the docker container. It equals Name, unless
+// the suite isolates names, in which case Name is only used as network alias.
+func (c *Container) DockerName() string {
+	return c.dockerName
+}
@@ -3,13 +3,22 @@ package testingdock_test
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
 	_ "github.com/lib/pq"
 
 	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
 )
 
 func TestContainer_Start(t *testing.T) {
@@ -115,3 +124,360 @@ func testQueries(t *testing.T, db *sql.DB) {
 		t.Fatalf("insert error: %s", err.Error())
 	}
 }
+
+func TestContainer_Reuse(t *testing.T) {
+	name := "TestContainer_Reuse"
+	engine := fake.NewEngine()
+
+	run := func(env string) string {
+		var id string
+		t.Run("run", func(t *testing.T) {
+			s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+			n := s.Network(testingdock.NetworkOpts{Name: name, Reuse: true})
+			c := s.Container(testingdock.ContainerOpts{
+				Name:   name,
+				Config: &container.Config{Image: "fake", Env: []string{env}},
+				Reuse:  true,
+			})
+			n.After(c)
+			s.Start(context.TODO())
+			id = c.ID
+		})
+		return id
+	}
+
+	first := run("A=1")
+	if containers, networks := engine.Counts(); containers != 1 || networks != 1 {
+		t.Fatalf("reused resources should be kept, got %d containers and %d networks", containers, networks)
+	}
+	if second := run("A=1"); second != first {
+		t.Errorf("container should have been reused, got %s instead of %s", second, first)
+	}
+	third := run("A=2")
+	if third == first {
+		t.Error("container should have been recreated after the configuration changed")
+	}
+	if containers, _ := engine.Counts(); containers != 1 {
+		t.Errorf("outdated container should have been removed, got %d containers", containers)
+	}
+
+	// another image behind the same tag, e.g. after a pull
+	engine.ImageIDs["fake"] = "sha256:pulled"
+	if fourth := run("A=2"); fourth == third {
+		t.Error("container should have been recreated after the image changed")
+	}
+}
+
+func TestContainer_ReuseBuild(t *testing.T) {
+	name := "TestContainer_ReuseBuild"
+	engine := fake.NewEngine()
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	run := func(code string) string {
+		if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var id string
+		t.Run("run", func(t *testing.T) {
+			s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine, Reuse: true})
+			n := s.Network(testingdock.NetworkOpts{Name: name})
+			c := s.Container(testingdock.ContainerOpts{
+				Name:   name,
+				Config: &container.Config{Image: name},
+				Build:  &testingdock.BuildOpts{Context: dir},
+			})
+			n.After(c)
+			s.Start(context.TODO())
+			id = c.ID
+		})
+		return id
+	}
+
+	first := run("package main")
+	if second := run("package main"); second != first {
+		t.Errorf("container should have been reused, got %s instead of %s", second, first)
+	}
+	// the fake engine keeps the image ID, the changed context has to be noticed anyway
+	if third := run("package main // changed"); third == first {
+		t.Error("container should have been recreated after the build context changed")
+	}
+}
+
+func TestContainer_Hooks(t *testing.T) {
+	name := "TestContainer_Hooks"
+
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	hook := func(call string) testingdock.HookFunc {
+		return func(ctx context.Context, c *testingdock.Container) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, c.Name+" "+call)
+			return nil
+		}
+	}
+	opts := func(name string) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name:        name,
+			Config:      &container.Config{Image: "fake"},
+			PostCreate:  hook("post create"),
+			PostStart:   hook("post start"),
+			PostHealthy: hook("post healthy"),
+			PreReset:    hook("pre reset"),
+			PostReset:   hook("post reset"),
+			PreClose:    hook("pre close"),
+		}
+	}
+
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	parent := s.Container(opts("parent"))
+	n.After(parent)
+	parent.After(s.Container(opts("child")))
+
+	s.Start(context.TODO())
+	s.Reset(context.TODO())
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"parent post create", "parent post start", "parent post healthy",
+		"child post create", "child post start", "child post healthy",
+		"parent pre reset", "parent post reset",
+		"child pre reset", "child post reset",
+		"parent pre close", "child pre close",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("hooks called in wrong order:\n%v\nexpected:\n%v", calls, expected)
+	}
+}
+
+func TestContainer_Close(t *testing.T) {
+	name := "TestContainer_Close"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	detached := s.Container(testingdock.ContainerOpts{Name: "detached", Config: &container.Config{Image: "fake"}})
+	failing := s.Container(testingdock.ContainerOpts{
+		Name:   "failing",
+		Config: &container.Config{Image: "fake"},
+		PreClose: func(ctx context.Context, c *testingdock.Container) error {
+			return errors.New("hook failed")
+		},
+	})
+	n.After(detached)
+	n.After(failing)
+	s.Start(context.TODO())
+
+	// detached containers are still removed
+	if err := engine.NetworkDisconnect(context.TODO(), n.ID(), detached.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// failures are returned instead of failing the test and do not stop the teardown
+	err := s.Close()
+	if err == nil || !strings.Contains(err.Error(), "hook failed") {
+		t.Errorf("the hook failure should be returned, got %v", err)
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("expected all resources to be removed, got %d containers and %d networks", containers, networks)
+	}
+}
+
+func TestContainer_Chaos(t *testing.T) {
+	name := "TestContainer_Chaos"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}})
+	n.After(c)
+	s.Start(context.TODO())
+
+	ctx := context.TODO()
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cjson, err := c.Inspect(ctx); err != nil || !cjson.State.Paused {
+		t.Fatalf("container should be paused: %v", err)
+	}
+	if err := c.Unpause(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// stopped containers are removed and recreated on restart
+	id := c.ID
+	if err := c.Stop(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if containers, _ := engine.Counts(); containers != 0 {
+		t.Fatalf("stopped container should be removed, got %d containers", containers)
+	}
+	if err := c.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == id {
+		t.Error("container should have been recreated")
+	}
+	if cjson, err := c.Inspect(ctx); err != nil || !cjson.State.Running {
+		t.Fatalf("container should be running: %v", err)
+	}
+
+	if err := c.Kill(ctx, "SIGKILL"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("expected all resources to be removed, got %d containers and %d networks", containers, networks)
+	}
+}
+
+func TestContainer_ChaosKept(t *testing.T) {
+	name := "TestContainer_ChaosKept"
+	path := writeCompose(t, `
+services:
+  db:
+    image: postgres
+  migrate:
+    image: oneshot
+    depends_on:
+      db:
+        condition: service_started
+  app:
+    image: app
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+`)
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	if err := s.LoadCompose(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	// the one-shot service is kept after it exited, it is never removed
+	c, _ := s.Lookup("migrate")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	id := c.ID
+	if err := c.Stop(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Inspect(ctx); err != nil {
+		t.Fatalf("stopped container should be kept: %v", err)
+	}
+	if err := c.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID == id {
+		t.Error("container should have been recreated")
+	}
+	if containers, _ := engine.Counts(); containers != 3 {
+		t.Errorf("expected 3 containers, got %d", containers)
+	}
+}
+
+func TestContainer_ChaosHealthFailure(t *testing.T) {
+	name := "TestContainer_ChaosHealthFailure"
+	var (
+		mu     sync.Mutex
+		broken bool
+	)
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{
+		Name:   name,
+		Config: &container.Config{Image: "fake"},
+		HealthCheck: func(ctx context.Context, c *testingdock.Container) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if broken {
+				return errors.New("broken")
+			}
+			return nil
+		},
+		HealthCheckTimeout: 1500 * time.Millisecond,
+	})
+	n.After(c)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	mu.Lock()
+	broken = true
+	mu.Unlock()
+
+	// health failures are returned instead of failing the test
+	ctx := context.TODO()
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unpause(ctx); err == nil || !strings.Contains(err.Error(), "health check failure") {
+		t.Errorf("unpause should return the health failure, got %v", err)
+	}
+	if err := c.Stop(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(ctx); err == nil || !strings.Contains(err.Error(), "health check failure") {
+		t.Errorf("restart should return the health failure, got %v", err)
+	}
+}
+
+func TestContainer_ChaosHooks(t *testing.T) {
+	name := "TestContainer_ChaosHooks"
+	var (
+		mu     sync.Mutex
+		called []string
+	)
+	hook := func(name string) testingdock.HookFunc {
+		return func(ctx context.Context, c *testingdock.Container) error {
+			mu.Lock()
+			defer mu.Unlock()
+			called = append(called, name)
+			return nil
+		}
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{
+		Name:        name,
+		Config:      &container.Config{Image: "fake"},
+		PostCreate:  hook("post create"),
+		PostStart:   hook("post start"),
+		PostHealthy: hook("post healthy"),
+		HealthCheck: func(ctx context.Context, c *testingdock.Container) error {
+			return hook("health check")(ctx, c)
+		},
+	})
+	n.After(c)
+	s.Start(context.TODO())
+
+	// the recreated container goes through the hooks and the health check again
+	ctx := context.TODO()
+	if err := c.Stop(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := []string{"post create", "post start", "health check", "post healthy"}
+	if expected := append(start, start...); !reflect.DeepEqual(called, expected) {
+		t.Errorf("unexpected hooks:\n%v\nexpected:\n%v", called, expected)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart(ctx); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("restarting a closed container should fail, got %v", err)
+	}
+}
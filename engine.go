@@ -0,0 +1,291 @@
+package testingdock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Engine describes the container engine serving the docker API, which is
+// either docker or podman, running as root or rootless.
+type Engine struct {
+	// "docker" or "podman"
+	Name    string
+	Version string
+	// whether the engine runs without root privileges
+	Rootless bool
+	// whether CPU, memory and pids limits can be applied, which rootless
+	// engines without cgroup delegation cannot
+	Limits bool
+	// whether the engine is Docker Desktop, which runs the containers in a VM
+	// and resolves HostInternal to the host itself
+	Desktop bool
+}
+
+// engineDetectionTimeout bounds the detection of an engine, which blocks all
+// callers asking for the same engine.
+const engineDetectionTimeout = 5 * time.Second
+
+var (
+	enginesMu sync.Mutex
+	// engines caches the detected engine by daemon host, which are few, so
+	// the entries are never removed
+	engines = make(map[string]*engineEntry)
+)
+
+// engineEntry is the engine of a daemon host, which is detected once.
+type engineEntry struct {
+	once   sync.Once
+	engine Engine
+}
+
+// NewClient returns a docker client for the endpoint given by DockerHost. The
+// API version is negotiated with the engine, unless DOCKER_API_VERSION is set,
+// as podman and older docker engines support different versions than the client.
+func NewClient() (client.APIClient, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if os.Getenv("DOCKER_HOST") == "" {
+		if host := DockerHost(); host != "" {
+			opts = append(opts, client.WithHost(host))
+		}
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// DockerHost returns the endpoint of the container engine, which is looked up in
+// this order and is empty if none is found:
+//
+//	DOCKER_HOST
+//	the docker context selected by DOCKER_CONTEXT or the docker CLI config,
+//	unless it is the default context
+//	/var/run/docker.sock, if it is a socket
+//	$XDG_RUNTIME_DIR/docker.sock of rootless docker
+//	~/.docker/run/docker.sock of Docker Desktop
+//	$XDG_RUNTIME_DIR/podman/podman.sock of rootless podman
+//	/run/podman/podman.sock of podman
+//
+// TLS settings of docker contexts are not supported.
+func DockerHost() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	if host := contextHost(); host != "" {
+		return host
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	home, _ := os.UserHomeDir() // nolint: errcheck
+	sockets := []string{"/var/run/docker.sock"}
+	if runtimeDir != "" {
+		sockets = append(sockets, filepath.Join(runtimeDir, "docker.sock"))
+	}
+	if home != "" {
+		sockets = append(sockets, filepath.Join(home, ".docker", "run", "docker.sock"))
+	}
+	if runtimeDir != "" {
+		sockets = append(sockets, filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+	sockets = append(sockets, "/run/podman/podman.sock")
+
+	for _, path := range sockets {
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return "unix://" + path
+		}
+	}
+	return ""
+}
+
+// contextHost returns the docker endpoint of the current docker context, if
+// it is not the default one.
+func contextHost() string {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configDir = filepath.Join(home, ".docker")
+	}
+
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		b, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+		if err != nil {
+			return ""
+		}
+		var config struct {
+			CurrentContext string `json:"currentContext"`
+		}
+		if err = json.Unmarshal(b, &config); err != nil {
+			return ""
+		}
+		name = config.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return ""
+	}
+
+	// the metadata of a context is stored in a directory named by the digest of its name
+	sum := sha256.Sum256([]byte(name))
+	b, err := ioutil.ReadFile(filepath.Join(configDir, "contexts", "meta", hex.EncodeToString(sum[:]), "meta.json"))
+	if err != nil {
+		printf("(setup ) %-25s - docker context not found: %s", name, err.Error())
+		return ""
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host string
+		}
+	}
+	if err = json.Unmarshal(b, &meta); err != nil {
+		printf("(setup ) %-25s - docker context decoding failure: %s", name, err.Error())
+		return ""
+	}
+	return meta.Endpoints["docker"].Host
+}
+
+// engineOf returns the engine behind the client, which is detected once per
+// daemon host. If detection fails, a docker engine running as root is assumed
+// for the host, so that an unreachable engine is not asked again on every call.
+// As the result is cached, the detection does not depend on the context of the
+// caller, which may be about to end, e.g. on teardown.
+func engineOf(cli client.APIClient) Engine {
+	host := cli.DaemonHost()
+	enginesMu.Lock()
+	entry, ok := engines[host]
+	if !ok {
+		entry = &engineEntry{}
+		engines[host] = entry
+	}
+	enginesMu.Unlock()
+
+	// the detection does not hold the lock, so that a slow engine does not
+	// block the detection of others
+	entry.once.Do(func() {
+		entry.engine = detectEngineOf(cli)
+	})
+	return entry.engine
+}
+
+// detectEngineOf detects the engine behind the client within engineDetectionTimeout
+// and falls back to a docker engine running as root.
+func detectEngineOf(cli client.APIClient) Engine {
+	ctx, cancel := context.WithTimeout(context.Background(), engineDetectionTimeout)
+	defer cancel()
+	e, err := detectEngine(ctx, cli)
+	if err != nil {
+		printf("(setup ) %-25s - engine detection failure: %s", cli.DaemonHost(), err.Error())
+		return Engine{Name: "docker", Limits: true}
+	}
+
+	rootless := ""
+	if e.Rootless {
+		rootless = ", rootless"
+	}
+	printf("(setup ) %-25s - engine detected: %s %s%s", cli.DaemonHost(), e.Name, e.Version, rootless)
+	return e
+}
+
+// detectEngine asks the engine for its version and security options.
+func detectEngine(ctx context.Context, cli client.APIClient) (Engine, error) {
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return Engine{}, err
+	}
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return Engine{}, err
+	}
+
+	e := Engine{Name: "docker", Version: v.Version}
+	// podman lists itself as a component of its docker compatible API
+	for _, comp := range v.Components {
+		if strings.HasPrefix(comp.Name, "Podman") {
+			e.Name, e.Version = "podman", comp.Version
+		}
+	}
+	opts, err := types.DecodeSecurityOptions(info.SecurityOptions)
+	if err != nil {
+		return Engine{}, err
+	}
+	for _, opt := range opts {
+		if opt.Name == "rootless" {
+			e.Rootless = true
+		}
+	}
+	// rootless docker without cgroup v2 runs containers without cgroups
+	e.Limits = info.CgroupDriver != "none"
+	// older versions were called Docker for Mac and Docker for Windows
+	e.Desktop = strings.HasPrefix(info.OperatingSystem, "Docker Desktop") || strings.HasPrefix(info.OperatingSystem, "Docker for ")
+	return e, nil
+}
+
+// Engine returns the container engine the suite runs on, e.g. to skip tests
+// relying on features rootless engines lack. The engine is detected once per
+// daemon host within a timeout of its own.
+func (s *Suite) Engine() Engine {
+	return engineOf(s.cli)
+}
+
+// daemonSocket returns the path of the unix socket of the engine, which is
+// mounted into helper containers talking to it, e.g. the reaper. Engines reached
+// otherwise, e.g. via tcp or ssh, have no socket known to be on their host.
+func daemonSocket(cli client.APIClient) (string, error) {
+	u, err := url.Parse(cli.DaemonHost())
+	if err != nil {
+		return "", fmt.Errorf("daemon host parsing failure: %s", err.Error())
+	}
+	if u.Scheme != "unix" {
+		return "", fmt.Errorf("the engine at %s is not reached via a unix socket, which could be mounted", cli.DaemonHost())
+	}
+	return u.Path, nil
+}
+
+// waitRemovedPolling blocks until the container has been removed, for engines
+// like podman, which do not support waiting for the removal of a container.
+func waitRemovedPolling(ctx context.Context, cli client.APIClient, id string) error {
+	for {
+		if _, err := cli.ContainerInspect(ctx, id); client.IsErrNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// networkAddresses returns the gateway and subnet of the first IPv4 pool of the
+// network. Podman may omit the gateway, in which case the first address of the
+// subnet is used as it is by default.
+func networkAddresses(ni types.NetworkResource) (gateway, subnet string) {
+	for _, cfg := range ni.IPAM.Config {
+		_, ipnet, err := net.ParseCIDR(cfg.Subnet)
+		if err != nil || ipnet.IP.To4() == nil {
+			continue
+		}
+		gateway, subnet = cfg.Gateway, cfg.Subnet
+		if gateway == "" {
+			ip := ipnet.IP.To4()
+			gateway = net.IPv4(ip[0], ip[1], ip[2], ip[3]+1).String()
+		}
+		return gateway, subnet
+	}
+	return "", ""
+}
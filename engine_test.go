@@ -0,0 +1,159 @@
+package testingdock_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+// setenv sets the environment variable until the test finished.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old) // nolint: errcheck
+		} else {
+			os.Unsetenv(key) // nolint: errcheck
+		}
+	})
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDockerHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	setenv(t, "DOCKER_HOST", "tcp://docker.example.com:2375")
+	if host := testingdock.DockerHost(); host != "tcp://docker.example.com:2375" {
+		t.Errorf("DOCKER_HOST should be used, got %s", host)
+	}
+
+	// the current docker context
+	setenv(t, "DOCKER_HOST", "")
+	setenv(t, "DOCKER_CONTEXT", "")
+	setenv(t, "DOCKER_CONFIG", dir)
+	sum := sha256.Sum256([]byte("remote"))
+	meta := filepath.Join(dir, "contexts", "meta", hex.EncodeToString(sum[:]))
+	if err = os.MkdirAll(meta, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(meta, "meta.json"), []byte(`{"Name":"remote","Endpoints":{"docker":{"Host":"ssh://ci@build"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"remote"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if host := testingdock.DockerHost(); host != "ssh://ci@build" {
+		t.Errorf("docker context should be used, got %s", host)
+	}
+
+	// the socket of rootless podman
+	setenv(t, "DOCKER_CONTEXT", "default")
+	if fi, err := os.Stat("/var/run/docker.sock"); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		t.Skip("docker socket exists")
+	}
+	setenv(t, "HOME", dir)
+	setenv(t, "XDG_RUNTIME_DIR", dir)
+	if err = os.Mkdir(filepath.Join(dir, "podman"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "podman", "podman.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() // nolint: errcheck
+	if host := testingdock.DockerHost(); host != "unix://"+filepath.Join(dir, "podman", "podman.sock") {
+		t.Errorf("podman socket should be used, got %s", host)
+	}
+}
+
+func TestSuite_Engine(t *testing.T) {
+	name := "TestSuite_Engine"
+	engine := fake.NewEngine()
+	engine.Podman = true
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine, Resources: &testingdock.ResourcesSmall})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{
+		Name:   name,
+		Config: &container.Config{Image: "fake"},
+		HostConfig: &container.HostConfig{Resources: container.Resources{
+			CPUShares: 512, CPUQuota: 50000, CPUPeriod: 100000, MemoryReservation: 64 << 20,
+		}},
+	})
+	n.After(c)
+	s.Start(context.TODO())
+
+	ctx := context.TODO()
+	if e := s.Engine(); e.Name != "podman" || e.Version != "4.9.3" || !e.Rootless || e.Limits {
+		t.Errorf("unexpected engine: %+v", e)
+	}
+	if hcfg := engine.ByName(name).HostConfig; hcfg.Memory != 0 || hcfg.NanoCPUs != 0 || hcfg.PidsLimit != nil {
+		t.Errorf("resource limits should be dropped: memory %d, cpus %d", hcfg.Memory, hcfg.NanoCPUs)
+	} else if r := hcfg.Resources; r.CPUShares != 0 || r.CPUQuota != 0 || r.CPUPeriod != 0 || r.MemoryReservation != 0 {
+		t.Errorf("limits of the host config should be dropped as well: %+v", r)
+	}
+
+	// podman does not wait for the removal, which is polled instead
+	if err := c.Stop(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSuite_EngineFallback(t *testing.T) {
+	engine := fake.NewEngine()
+	engine.Unreachable = true
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_EngineFallback", testingdock.SuiteOpts{Client: engine})
+	defer s.Close() // nolint: errcheck
+
+	// the fallback is cached, the engine is asked only once
+	for i := 0; i < 3; i++ {
+		if e := s.Engine(); e.Name != "docker" || !e.Limits || e.Rootless {
+			t.Errorf("unexpected fallback engine: %+v", e)
+		}
+	}
+	if engine.VersionCalls != 1 {
+		t.Errorf("engine should be detected once, got %d detections", engine.VersionCalls)
+	}
+}
+
+// uncomparableClient is a client, which cannot be used as a map key.
+type uncomparableClient struct {
+	*fake.Engine
+	opts []string
+}
+
+func TestSuite_EngineCache(t *testing.T) {
+	engine := fake.NewEngine()
+	engine.Podman = true
+	for _, name := range []string{"TestSuite_EngineCache_1", "TestSuite_EngineCache_2"} {
+		s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: uncomparableClient{Engine: engine}})
+		if e := s.Engine(); e.Name != "podman" {
+			t.Errorf("unexpected engine: %+v", e)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the clients of the same daemon host share the detected engine
+	if engine.VersionCalls != 1 {
+		t.Errorf("engine should be detected once, got %d detections", engine.VersionCalls)
+	}
+}
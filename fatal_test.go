@@ -0,0 +1,47 @@
+package testingdock_test
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// fatalT records the first fatal failure instead of failing the test, see expectFatal.
+type fatalT struct {
+	testing.TB
+	mu  sync.Mutex
+	msg string
+}
+
+func (t *fatalT) Fatalf(format string, args ...interface{}) {
+	t.mu.Lock()
+	if t.msg == "" {
+		t.msg = fmt.Sprintf(format, args...)
+	}
+	t.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (t *fatalT) Fatal(args ...interface{}) {
+	t.Fatalf("%s", fmt.Sprint(args...))
+}
+
+// expectFatal calls fn with a testing.TB, which records fatal failures, and
+// returns the message of the first one. It fails the test, if there is none.
+func expectFatal(t *testing.T, fn func(tb testing.TB)) string {
+	ft := &fatalT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ft)
+	}()
+	<-done
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.msg == "" {
+		t.Fatal("expected a fatal failure")
+	}
+	return ft.msg
+}
@@ -0,0 +1,258 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// FaultImage is the image of the sidecar containers, which degrade the network
+// of containers with tc netem and iptables. It shares the network namespace of
+// the degraded container, so the image of the container itself needs neither tools
+// nor capabilities.
+var FaultImage = "nicolaka/netshoot:v0.8"
+
+// faultInterface is the network interface of a container in the suite network.
+const faultInterface = "eth0"
+
+// faults is the state of the network faults injected into a container.
+type faults struct {
+	mu sync.Mutex
+	// ID of the sidecar container, once a fault has been injected
+	sidecar string
+	latency time.Duration
+	loss    float64
+	// IP addresses of the partitioned containers
+	blocked      []string
+	disconnected bool
+}
+
+// AddLatency delays all packets sent by the container by the given duration,
+// until the network heals. Zero removes the latency again.
+func (c *Container) AddLatency(ctx context.Context, d time.Duration) error {
+	c.faults.mu.Lock()
+	defer c.faults.mu.Unlock()
+
+	c.faults.latency = d
+	if err := c.applyNetem(ctx); err != nil {
+		return err
+	}
+	printf("(fault ) %-25s (%s) - latency added: %s", c.Name, c.ID, d)
+	return nil
+}
+
+// PacketLoss drops the given percentage of the packets sent by the container,
+// until the network heals. Zero removes the packet loss again.
+func (c *Container) PacketLoss(ctx context.Context, percent float64) error {
+	c.faults.mu.Lock()
+	defer c.faults.mu.Unlock()
+
+	c.faults.loss = percent
+	if err := c.applyNetem(ctx); err != nil {
+		return err
+	}
+	printf("(fault ) %-25s (%s) - packet loss added: %g%%", c.Name, c.ID, percent)
+	return nil
+}
+
+// applyNetem replaces the queueing discipline of the container with netem, using
+// the current latency and packet loss. c.faults.mu must be held.
+func (c *Container) applyNetem(ctx context.Context) error {
+	if c.faults.latency == 0 && c.faults.loss == 0 {
+		if c.faults.sidecar == "" {
+			return nil
+		}
+		return execute(ctx, c.cli, c.faults.sidecar, []string{"sh", "-c", "tc qdisc del dev " + faultInterface + " root 2>/dev/null || true"})
+	}
+
+	cmd := []string{"tc", "qdisc", "replace", "dev", faultInterface, "root", "netem"}
+	if c.faults.latency > 0 {
+		cmd = append(cmd, "delay", strconv.FormatInt(c.faults.latency.Microseconds(), 10)+"us")
+	}
+	if c.faults.loss > 0 {
+		cmd = append(cmd, "loss", strconv.FormatFloat(c.faults.loss, 'f', -1, 64)+"%")
+	}
+	return c.faultExec(ctx, cmd...)
+}
+
+// Partition cuts the traffic between the given containers of the network in both
+// directions, until the network heals.
+func (n *Network) Partition(ctx context.Context, a, b *Container) error {
+	ipA, err := a.IP(ctx)
+	if err != nil {
+		return err
+	}
+	ipB, err := b.IP(ctx)
+	if err != nil {
+		return err
+	}
+	if err = a.block(ctx, ipB); err != nil {
+		return err
+	}
+	if err = b.block(ctx, ipA); err != nil {
+		// no half partition is left behind
+		return joinErrors([]error{err, a.unblock(ctx, ipB)})
+	}
+	printf("(fault ) %-25s (%s) - network partitioned between: %s and %s", n.name, n.id, a.Name, b.Name)
+	return nil
+}
+
+// block drops all packets from and to the given IP address in the container.
+func (c *Container) block(ctx context.Context, ip string) error {
+	c.faults.mu.Lock()
+	defer c.faults.mu.Unlock()
+
+	if err := c.faultExec(ctx, "sh", "-c", iptables("-A", ip)); err != nil {
+		return err
+	}
+	c.faults.blocked = append(c.faults.blocked, ip)
+	return nil
+}
+
+// unblock removes the rules of block for the given IP address in the container.
+func (c *Container) unblock(ctx context.Context, ip string) error {
+	c.faults.mu.Lock()
+	defer c.faults.mu.Unlock()
+
+	if err := c.faultExec(ctx, "sh", "-c", iptables("-D", ip)); err != nil {
+		return err
+	}
+	for i := len(c.faults.blocked) - 1; i >= 0; i-- {
+		if c.faults.blocked[i] == ip {
+			c.faults.blocked = append(c.faults.blocked[:i], c.faults.blocked[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// iptables returns the commands, which add (-A) or delete (-D) the rules dropping
+// the packets from and to the given IP address.
+func iptables(op, ip string) string {
+	return fmt.Sprintf("iptables %s INPUT -s %s -j DROP && iptables %s OUTPUT -d %s -j DROP", op, ip, op, ip)
+}
+
+// Disconnect disconnects the container from the network, until the network heals.
+func (n *Network) Disconnect(ctx context.Context, c *Container) error {
+	c.faults.mu.Lock()
+	defer c.faults.mu.Unlock()
+
+	if err := n.cli.NetworkDisconnect(ctx, n.id, c.ID, false); err != nil {
+		return err
+	}
+	c.faults.disconnected = true
+	printf("(fault ) %-25s (%s) - container disconnected: %s", n.name, n.id, c.Name)
+	return nil
+}
+
+// Heal removes all faults injected into the containers of the network. Faults
+// are also removed when a container resets or closes.
+func (n *Network) Heal(ctx context.Context) error {
+	var err error
+	walk(n.children, func(c *Container) {
+		if e := c.clearFaults(ctx); e != nil && err == nil {
+			err = e
+		}
+	})
+	if err != nil {
+		return err
+	}
+	printf("(fault ) %-25s (%s) - network healed", n.name, n.id)
+	return nil
+}
+
+// walk calls f for the given containers and all their children.
+func walk(containers []*Container, f func(c *Container)) {
+	for _, c := range containers {
+		f(c)
+		walk(c.children, f)
+	}
+}
+
+// faultExec runs the command in the sidecar container of the container, which
+// is started if needed. c.faults.mu must be held.
+func (c *Container) faultExec(ctx context.Context, cmd ...string) error {
+	if c.faults.sidecar == "" {
+		id, err := startFaultSidecar(ctx, c.cli, c.ID, c.ccfg.Labels[LabelSuite])
+		if err != nil {
+			return fmt.Errorf("fault sidecar failure: %s", err.Error())
+		}
+		c.faults.sidecar = id
+		printf("(fault ) %-25s (%s) - fault sidecar started: %s", c.Name, c.ID, id)
+	}
+	return execute(ctx, c.cli, c.faults.sidecar, cmd)
+}
+
+// startFaultSidecar starts a sidecar container in the network namespace of the
+// container with the given ID.
+func startFaultSidecar(ctx context.Context, cli client.APIClient, id, suite string) (string, error) {
+	if err := pullMissing(ctx, cli, FaultImage); err != nil {
+		return "", err
+	}
+
+	labels := createTestingLabel()
+	if suite != "" {
+		labels[LabelSuite] = suite
+	}
+	cont, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:  FaultImage,
+		Cmd:    []string{"sleep", "2147483647"},
+		Labels: labels,
+	}, &container.HostConfig{
+		NetworkMode: container.NetworkMode("container:" + id),
+		CapAdd:      []string{"NET_ADMIN"},
+	}, nil, "")
+	if err != nil {
+		return "", err
+	}
+	if err = cli.ContainerStart(ctx, cont.ID, types.ContainerStartOptions{}); err != nil {
+		cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true}) // nolint: errcheck
+		return "", err
+	}
+	return cont.ID, nil
+}
+
+// clearFaults reconnects the container, if it has been disconnected, reverts the
+// rules added to it and removes its sidecar container.
+func (c *Container) clearFaults(ctx context.Context) error {
+	c.faults.mu.Lock()
+	defer c.faults.mu.Unlock()
+
+	if c.faults.disconnected {
+		if err := c.cli.NetworkConnect(ctx, c.network.id, c.ID, &network.EndpointSettings{
+			Aliases: c.networkAliases(),
+		}); err != nil {
+			return err
+		}
+		c.faults.disconnected = false
+		printf("(fault ) %-25s (%s) - container reconnected to: %s", c.Name, c.ID, c.network.name)
+	}
+	if c.faults.sidecar == "" {
+		return nil
+	}
+
+	c.faults.latency, c.faults.loss = 0, 0
+	if err := c.applyNetem(ctx); err != nil {
+		return err
+	}
+	for _, ip := range c.faults.blocked {
+		if err := execute(ctx, c.cli, c.faults.sidecar, []string{"sh", "-c", iptables("-D", ip)}); err != nil {
+			return err
+		}
+	}
+	c.faults.blocked = nil
+
+	if err := c.cli.ContainerRemove(ctx, c.faults.sidecar, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+		return err
+	}
+	printf("(fault ) %-25s (%s) - fault sidecar removed: %s", c.Name, c.ID, c.faults.sidecar)
+	c.faults.sidecar = ""
+	return nil
+}
@@ -1,10 +1,14 @@
 package testingdock
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"runtime/debug"
 	"strconv"
-	"testing"
+	"strings"
+	"sync"
 )
 
 // printf just wraps fmt.Printf.
@@ -13,13 +17,13 @@ func printf(format string, args ...interface{}) {
 }
 
 // RandomPort returns a random available port as a string.
-func RandomPort(t testing.TB) string {
+func RandomPort(t Reporter) string {
 	return strconv.FormatInt(int64(randomPort(t)), 10)
 
 }
 
 // randomPort returns random available port as an int.
-func randomPort(t testing.TB) int {
+func randomPort(t Reporter) int {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
 	if err != nil {
 		t.Fatalf("testingdock: resolve failure: %s", err.Error())
@@ -37,6 +41,16 @@ func randomPort(t testing.TB) int {
 	return l.Addr().(*net.TCPAddr).Port
 }
 
+// labels of resources which are reused across sessions.
+const (
+	labelReuse = "testingdock.reuse"
+	labelHash  = "testingdock.hash"
+)
+
+// LabelSuite is the label set to the name of the suite on all containers,
+// networks and volumes of a suite.
+const LabelSuite = "testingdock.suite"
+
 // Check whether a map containing labels has the "owner=testingdock" label.
 func isOwnedByTestingdock(labels map[string]string) bool {
 	for key, value := range labels {
@@ -47,9 +61,107 @@ func isOwnedByTestingdock(labels map[string]string) bool {
 	return false
 }
 
-// Create a map of labels containting the "owner=testingdock" label.
+// Create a map of labels containting the "owner=testingdock" label and
+// the labels identifying the current session.
 func createTestingLabel() map[string]string {
+	labels := session.labels()
+	labels["owner"] = "testingdock"
+	return labels
+}
+
+// Create a map of labels for resources which are reused across sessions. These
+// don't belong to any session, so they are neither removed by the reaper nor by Prune.
+func createReuseLabel() map[string]string {
 	labels := make(map[string]string)
 	labels["owner"] = "testingdock"
+	labels[labelReuse] = "true"
 	return labels
 }
+
+// spawn calls fn for each of the given containers, either sequentially or in
+// parallel, depending on SpawnSequential. A panic in one of the parallel
+// goroutines is recovered and reported via t.Fatalf once all of them are done,
+// instead of crashing the test binary without any teardown.
+func spawn(t Reporter, containers []*Container, fn func(c *Container)) {
+	if SpawnSequential {
+		for _, c := range containers {
+			fn(c)
+		}
+		return
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		panics []string
+	)
+
+	wg.Add(len(containers))
+	for _, c := range containers {
+		go func(c *Container) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					panics = append(panics, fmt.Sprintf("%s: %v\n%s", c.Name, r, debug.Stack()))
+					mu.Unlock()
+				}
+			}()
+			fn(c)
+		}(c)
+	}
+	wg.Wait()
+
+	if len(panics) > 0 {
+		t.Fatalf("testingdock: panic in container goroutine: %s", strings.Join(panics, "\n"))
+	}
+}
+
+// closeAll closes the given containers, either sequentially or in parallel,
+// depending on SpawnSequential. Unlike spawn, it never fails the test, as it
+// also runs from the signal handler and after the test finished. Failures and
+// panics are returned once all containers are closed.
+func closeAll(ctx context.Context, containers []*Container) error {
+	errs := make([]error, len(containers))
+	closeOne := func(i int) {
+		defer func() {
+			if r := recover(); r != nil {
+				errs[i] = fmt.Errorf("panic closing %s: %v", containers[i].Name, r)
+			}
+		}()
+		errs[i] = containers[i].close(ctx)
+	}
+
+	if SpawnSequential {
+		for i := range containers {
+			closeOne(i)
+		}
+		return joinErrors(errs)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(containers))
+	for i := range containers {
+		go func(i int) {
+			defer wg.Done()
+			closeOne(i)
+		}(i)
+	}
+	wg.Wait()
+	return joinErrors(errs)
+}
+
+// joinErrors combines the given errors into one, ignoring nil ones. Returns
+// nil if there are none.
+func joinErrors(errs []error) error {
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
@@ -0,0 +1,85 @@
+package testingdock
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// HostInternal is the host name, under which the docker host is reachable from
+// the containers of a suite. On docker engines running on linux it is mapped to
+// the gateway of the suite network in the hosts file of every container, unless
+// the container maps it in its HostConfig.ExtraHosts itself. Docker Desktop
+// resolves it to the host itself. Podman does not know it, but resolves
+// hostInternalPodman instead, see Network.HostAddr.
+const HostInternal = "host.docker.internal"
+
+// hostInternalPodman is the host name podman adds to the hosts file of every
+// container for the host.
+const hostInternalPodman = "host.containers.internal"
+
+// ForwardImage is the image of the sidecar container, which forwards a port
+// within the suite network to a listener on the docker host, see Suite.ExposeHost.
+var ForwardImage = "alpine/socat:1.7.3.4-r0"
+
+// HostAddr returns the address, under which the given listener on the docker
+// host, e.g. of an httptest.Server, is reachable from the containers in the network.
+// On linux the listener has to accept connections on the gateway of the network,
+// so it should listen on all interfaces rather than on the loopback interface.
+// The host name is HostInternal on docker engines and the one podman resolves
+// on podman.
+func (n *Network) HostAddr(addr net.Addr) string {
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		n.t.Fatalf("invalid host address '%s': %s", addr.String(), err.Error())
+	}
+	if engineOf(n.cli).Name == "podman" {
+		return net.JoinHostPort(hostInternalPodman, port)
+	}
+	return net.JoinHostPort(HostInternal, port)
+}
+
+// ExposeHost makes the given listener on the docker host reachable under name:port
+// within the suite network, e.g. for services with a hard-coded callback URL.
+// A small forwarding sidecar container is added to the network, which has to
+// be created before. The sidecar is started with the suite, so ExposeHost fails
+// the test once the network started.
+func (s *Suite) ExposeHost(name string, port int, addr net.Addr) *Container {
+	n := s.getNetwork()
+	if n == nil {
+		s.t.Fatalf("suite %s has no network to expose the host in", s.name)
+	}
+	if n.ID() != "" {
+		s.t.Fatalf("network %s started already, the host has to be exposed as %s before the suite is started", n.name, name)
+	}
+
+	c := s.Container(ContainerOpts{
+		Name: name,
+		Config: &container.Config{
+			Image: ForwardImage,
+			Cmd: []string{
+				"TCP-LISTEN:" + strconv.Itoa(port) + ",fork,reuseaddr",
+				"TCP:" + n.HostAddr(addr),
+			},
+		},
+	})
+	n.After(c)
+	return c
+}
+
+// withHostInternal maps HostInternal to the gateway of the network, unless the
+// given extra hosts map it already.
+func withHostInternal(extraHosts []string, gateway string) []string {
+	if gateway == "" {
+		return extraHosts
+	}
+	for _, h := range extraHosts {
+		// newer docker versions also accept "=" as separator
+		if strings.HasPrefix(h, HostInternal+":") || strings.HasPrefix(h, HostInternal+"=") {
+			return extraHosts
+		}
+	}
+	return append(append([]string(nil), extraHosts...), HostInternal+":"+gateway)
+}
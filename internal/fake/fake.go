@@ -0,0 +1,942 @@
+// Package fake provides an in-memory docker engine for the tests of testingdock,
+// its command and its modules.
+package fake
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// Engine is an in-memory docker engine, which implements the parts of the
+// docker API used by testingdock. Calling any other method panics.
+type Engine struct {
+	client.APIClient
+
+	mu         sync.Mutex
+	host       string
+	seq        int
+	containers map[string]*Container
+	networks   map[string]*fakeNetwork
+	volumes    map[string]*types.Volume
+	// paths of the files copied into the volumes
+	VolumeFiles map[string][]string
+	// names of the created containers in order of creation
+	created []string
+	// committed images by reference
+	images map[string]*container.Config
+	// options and context files of the built images by tag
+	Builds     map[string]types.ImageBuildOptions
+	BuildFiles map[string][]string
+	// whether to pose as rootless podman without cgroups
+	Podman bool
+	// whether to pose as Docker Desktop
+	Desktop bool
+	// whether the version can't be queried, and how often it was tried
+	Unreachable  bool
+	VersionCalls int
+	// decides which commands executed in containers fail, if set
+	FailExec func(cmd []string) bool
+	// whether volume copies fail
+	FailCopy bool
+	// IDs of the images by reference, "sha256:fake" if not set
+	ImageIDs map[string]string
+}
+
+// OneShotImage is the image of containers, which exit successfully
+// right after they have been started. They are removed if AutoRemove is set.
+const OneShotImage = "oneshot"
+
+// UnpausableImage is the image of containers, which fail to be paused.
+const UnpausableImage = "unpausable"
+
+// SlowImage is the image of containers, whose image takes a while to resolve.
+const SlowImage = "slow"
+
+// Container is a container of the engine.
+type Container struct {
+	ID, Name   string
+	Labels     map[string]string
+	Network    string
+	Aliases    []string
+	Running    bool
+	Paused     bool
+	Exited     bool
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	// paths of the files copied into the container
+	Files []string
+	IP    string
+	Ports nat.PortMap
+	// commands executed in the container
+	Execs   [][]string
+	logs    []logLine
+	created time.Time
+
+	// number of stats streams of the container, which have not been closed yet
+	StatsStreams int
+}
+
+type logLine struct {
+	time   time.Time
+	line   string
+	stderr bool
+}
+
+type fakeNetwork struct {
+	id, name string
+	labels   map[string]string
+	created  time.Time
+}
+
+// engines numbers the fake engines, whose daemon hosts have to differ, as
+// the detected engine is cached by host.
+var engines int32
+
+// NewEngine returns an empty engine with a daemon host of its own.
+func NewEngine() *Engine {
+	return &Engine{
+		host:        fmt.Sprintf("unix:///var/run/fake-%d.sock", atomic.AddInt32(&engines, 1)),
+		containers:  make(map[string]*Container),
+		networks:    make(map[string]*fakeNetwork),
+		volumes:     make(map[string]*types.Volume),
+		VolumeFiles: make(map[string][]string),
+		images:      make(map[string]*container.Config),
+		Builds:      make(map[string]types.ImageBuildOptions),
+		BuildFiles:  make(map[string][]string),
+		ImageIDs:    make(map[string]string),
+	}
+}
+
+func (e *Engine) nextID() string {
+	e.seq++
+	return fmt.Sprintf("%064d", e.seq)
+}
+
+// Counts returns the number of containers and networks in the engine.
+// Volumes are not counted, see VolumeCount.
+func (e *Engine) Counts() (int, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.containers), len(e.networks)
+}
+
+// matchFilters checks the "name" and "label" filters used by testingdock.
+func matchFilters(args filters.Args, name string, labels map[string]string) bool {
+	for _, pattern := range args.Get("name") {
+		if ok, _ := regexp.MatchString(pattern, name); !ok {
+			return false
+		}
+	}
+	for _, label := range args.Get("label") {
+		kv := strings.SplitN(label, "=", 2)
+		value, ok := labels[kv[0]]
+		if !ok || (len(kv) == 2 && value != kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Engine) DaemonHost() string {
+	return e.host
+}
+
+func (e *Engine) Close() error {
+	return nil
+}
+
+func (e *Engine) ServerVersion(ctx context.Context) (types.Version, error) {
+	e.mu.Lock()
+	e.VersionCalls++
+	e.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return types.Version{}, err
+	}
+	if e.Unreachable {
+		return types.Version{}, errdefs.Unavailable(errors.New("engine unreachable"))
+	}
+	if e.Podman {
+		return types.Version{Version: "3.0.1", Components: []types.ComponentVersion{{Name: "Podman Engine", Version: "4.9.3"}}}, nil
+	}
+	return types.Version{Version: "19.03.8", Components: []types.ComponentVersion{{Name: "Engine", Version: "19.03.8"}}}, nil
+}
+
+func (e *Engine) Info(ctx context.Context) (types.Info, error) {
+	if e.Podman {
+		return types.Info{CgroupDriver: "none", SecurityOptions: []string{"name=seccomp,profile=default", "name=rootless"}}, nil
+	}
+	if e.Desktop {
+		return types.Info{OperatingSystem: "Docker Desktop", CgroupDriver: "cgroupfs", SecurityOptions: []string{"name=seccomp,profile=default"}}, nil
+	}
+	return types.Info{OperatingSystem: "Ubuntu 20.04 LTS", CgroupDriver: "cgroupfs", SecurityOptions: []string{"name=seccomp,profile=default"}}, nil
+}
+
+func (e *Engine) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	if refs := options.Filters.Get("reference"); len(refs) == 1 && refs[0] == SlowImage {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return []types.ImageSummary{{ID: "sha256:fake"}}, nil
+}
+
+func (e *Engine) ImageInspectWithRaw(ctx context.Context, ref string) (types.ImageInspect, []byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id, ok := e.ImageIDs[ref]
+	if !ok {
+		id = "sha256:fake"
+	}
+	return types.ImageInspect{ID: id, RepoTags: []string{ref}}, nil, nil
+}
+
+func (e *Engine) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, c := range e.containers {
+		// docker generates a name, unless one is given
+		if containerName != "" && c.Name == containerName {
+			return container.ContainerCreateCreatedBody{}, fmt.Errorf("conflict: container name %s already in use", containerName)
+		}
+	}
+	c := &Container{
+		ID:         e.nextID(),
+		Name:       containerName,
+		Labels:     config.Labels,
+		Network:    string(hostConfig.NetworkMode),
+		Config:     config,
+		HostConfig: hostConfig,
+		IP:         fmt.Sprintf("172.30.0.%d", len(e.containers)+2),
+		Ports:      make(nat.PortMap),
+		created:    time.Now(),
+	}
+	// docker picks the host ports, which are not given
+	for port, bindings := range hostConfig.PortBindings {
+		for _, b := range bindings {
+			if b.HostPort == "" {
+				b.HostPort = strconv.Itoa(32768 + len(e.containers))
+			}
+			c.Ports[port] = append(c.Ports[port], nat.PortBinding{HostIP: "0.0.0.0", HostPort: b.HostPort})
+		}
+	}
+	if networkingConfig != nil {
+		for _, es := range networkingConfig.EndpointsConfig {
+			c.Aliases = append(c.Aliases, es.Aliases...)
+		}
+	}
+	e.containers[c.ID] = c
+	e.created = append(e.created, containerName)
+
+	return container.ContainerCreateCreatedBody{ID: c.ID}, nil
+}
+
+func (e *Engine) container(id string) (*Container, error) {
+	c, ok := e.containers[id]
+	if !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+	return c, nil
+}
+
+func (e *Engine) ContainerStart(ctx context.Context, id string, options types.ContainerStartOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	c.Running = c.Config.Image != OneShotImage
+	c.Exited = !c.Running
+	if c.Exited && c.HostConfig.AutoRemove {
+		delete(e.containers, id)
+		return nil
+	}
+
+	// the volume copy helper copies the files of /from to /to
+	var from, to string
+	for _, m := range c.HostConfig.Mounts {
+		switch m.Target {
+		case "/from":
+			from = m.Source
+		case "/to":
+			to = m.Source
+		}
+	}
+	if from != "" && to != "" {
+		if e.FailCopy {
+			return fmt.Errorf("copy failure")
+		}
+		e.VolumeFiles[to] = append([]string(nil), e.VolumeFiles[from]...)
+	}
+	return nil
+}
+
+func (e *Engine) ContainerPause(ctx context.Context, id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	if c.Config.Image == UnpausableImage {
+		return errdefs.System(fmt.Errorf("cannot pause container %s", id))
+	}
+	c.Paused = true
+	return nil
+}
+
+func (e *Engine) ContainerUnpause(ctx context.Context, id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	c.Paused = false
+	return nil
+}
+
+func (e *Engine) ContainerStop(ctx context.Context, id string, timeout *time.Duration) error {
+	return e.ContainerKill(ctx, id, "SIGTERM")
+}
+
+// ContainerKill stops the container for any signal, removing it if AutoRemove is set.
+func (e *Engine) ContainerKill(ctx context.Context, id, signal string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	c.Running, c.Paused, c.Exited = false, false, true
+	if c.HostConfig.AutoRemove {
+		delete(e.containers, id)
+	}
+	return nil
+}
+
+// ContainerWait reports the conditions reached when it is called, as the fake
+// containers never change their state on their own. It blocks until the
+// context is done otherwise.
+func (e *Engine) ContainerWait(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statusC := make(chan container.ContainerWaitOKBody, 1)
+	errC := make(chan error, 1)
+	c, err := e.container(id)
+	switch {
+	case err != nil:
+		errC <- err
+	case condition == container.WaitConditionRemoved || (condition == container.WaitConditionNotRunning && c.Running):
+		go func() {
+			<-ctx.Done()
+			errC <- ctx.Err()
+		}()
+	default:
+		statusC <- container.ContainerWaitOKBody{}
+	}
+	return statusC, errC
+}
+
+// Log appends the line to the stdout logs of the container with the given ID.
+func (e *Engine) Log(id, line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.containers[id]
+	c.logs = append(c.logs, logLine{time: time.Now(), line: line})
+}
+
+// LogStderr appends the line to the stderr logs of the container with the given ID.
+func (e *Engine) LogStderr(id, line string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.containers[id]
+	c.logs = append(c.logs, logLine{time: time.Now(), line: line, stderr: true})
+}
+
+// ContainerLogs returns the multiplexed logs, or the raw ones of containers with
+// a TTY like docker does. Following is not supported.
+func (e *Engine) ContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return nil, err
+	}
+	var since time.Time
+	if options.Since != "" {
+		parts := strings.SplitN(options.Since, ".", 2)
+		sec, _ := strconv.ParseInt(parts[0], 10, 64)
+		nsec, _ := strconv.ParseInt(parts[1], 10, 64)
+		since = time.Unix(sec, nsec)
+	}
+
+	var buf bytes.Buffer
+	var stdout, stderr io.Writer = &buf, &buf
+	if !c.Config.Tty {
+		stdout = stdcopy.NewStdWriter(&buf, stdcopy.Stdout)
+		stderr = stdcopy.NewStdWriter(&buf, stdcopy.Stderr)
+	}
+	for _, l := range c.logs {
+		if l.time.Before(since) {
+			continue
+		}
+		w := stdout
+		if l.stderr {
+			w = stderr
+		}
+		if options.Timestamps {
+			fmt.Fprint(w, l.time.Format(time.RFC3339Nano)+" ") // nolint: errcheck
+		}
+		fmt.Fprintln(w, l.line) // nolint: errcheck
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+// ContainerStats streams three samples, the first one without previous CPU usage.
+// The CPU usage is 20% and 60%, the memory usage without cache 80 and 100 MiB.
+func (e *Engine) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := uint64(0); i < 3; i++ {
+		var s types.StatsJSON
+		s.Read = time.Unix(int64(i), 0)
+		s.CPUStats = types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: i * i * 1e8}, SystemUsage: (i + 1) * 1e9, OnlineCPUs: 2}
+		if i > 0 {
+			s.PreCPUStats = types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: (i - 1) * (i - 1) * 1e8}, SystemUsage: i * 1e9, OnlineCPUs: 2}
+		}
+		s.MemoryStats = types.MemoryStats{Usage: (80 + 20*i) << 20, Stats: map[string]uint64{"cache": 20 << 20}}
+		s.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: 1000 * i, TxBytes: 500 * i}}
+		enc.Encode(s) // nolint: errcheck
+	}
+
+	// like docker, the stream is kept open after the samples until it is cancelled
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(buf.Bytes()) // nolint: errcheck
+		<-ctx.Done()
+		pw.CloseWithError(ctx.Err()) // nolint: errcheck
+	}()
+	c.StatsStreams++
+	body := &statsBody{PipeReader: pr, close: func() {
+		e.mu.Lock()
+		c.StatsStreams--
+		e.mu.Unlock()
+	}}
+	return types.ContainerStats{Body: body}, nil
+}
+
+// statsBody is a stats stream, which is counted until it is closed.
+type statsBody struct {
+	*io.PipeReader
+	once  sync.Once
+	close func()
+}
+
+func (b *statsBody) Close() error {
+	b.once.Do(b.close)
+	return b.PipeReader.Close()
+}
+
+func (e *Engine) CopyToContainer(ctx context.Context, id, path string, content io.Reader, options types.CopyToContainerOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(content)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		c.Files = append(c.Files, path+"/"+hdr.Name)
+		for _, m := range c.HostConfig.Mounts {
+			if m.Type == mount.TypeVolume && m.Target == path {
+				e.VolumeFiles[m.Source] = append(e.VolumeFiles[m.Source], hdr.Name)
+			}
+		}
+	}
+}
+
+// Mounts returns whether the container mounts all of the given volumes.
+func (c *Container) Mounts(volumes ...string) bool {
+	for _, v := range volumes {
+		found := false
+		for _, m := range c.HostConfig.Mounts {
+			found = found || (m.Type == mount.TypeVolume && m.Source == v)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// mountPoints returns the mounts of the container as listed by docker. The volumes
+// of the config without a mount, like the ones of a VOLUME of the image, are
+// anonymous volumes named after the container.
+func (c *Container) mountPoints() []types.MountPoint {
+	anonymous := func(path string) string {
+		return c.ID + strings.Replace(path, "/", "-", -1)
+	}
+	var (
+		mounts  []types.MountPoint
+		mounted = make(map[string]bool)
+	)
+	for _, m := range c.HostConfig.Mounts {
+		mounted[m.Target] = true
+		mp := types.MountPoint{Type: m.Type, Destination: m.Target, RW: !m.ReadOnly}
+		switch {
+		case m.Type == mount.TypeVolume && m.Source == "":
+			mp.Name = anonymous(m.Target)
+		case m.Type == mount.TypeVolume:
+			mp.Name = m.Source
+		default:
+			mp.Source = m.Source
+		}
+		mounts = append(mounts, mp)
+	}
+	var paths []string
+	for path := range c.Config.Volumes {
+		if !mounted[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		mounts = append(mounts, types.MountPoint{Type: mount.TypeVolume, Name: anonymous(path), Destination: path, RW: true})
+	}
+	return mounts
+}
+
+// ByName returns the container with the given name.
+func (e *Engine) ByName(name string) *Container {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, c := range e.containers {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Sidecar returns the container sharing the network namespace of the container
+// with the given ID, if any.
+func (e *Engine) Sidecar(id string) *Container {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, c := range e.containers {
+		if c.Network == "container:"+id {
+			return c
+		}
+	}
+	return nil
+}
+
+// ContainerNames returns the sorted names of the containers in the engine.
+func (e *Engine) ContainerNames() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var names []string
+	for _, c := range e.containers {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ContainerIDs returns the sorted IDs of the containers in the engine.
+func (e *Engine) ContainerIDs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var ids []string
+	for id := range e.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// CreationOrder returns the names of the created containers in order of creation.
+func (e *Engine) CreationOrder() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.created...)
+}
+
+func (e *Engine) ContainerCommit(ctx context.Context, id string, options types.ContainerCommitOptions) (types.IDResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return types.IDResponse{}, err
+	}
+	e.images[options.Reference] = c.Config
+	return types.IDResponse{ID: "sha256:" + e.nextID()}, nil
+}
+
+func (e *Engine) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	var files []string
+	tr := tar.NewReader(buildContext)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return types.ImageBuildResponse{}, err
+		}
+		files = append(files, hdr.Name)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, tag := range options.Tags {
+		e.Builds[tag] = options
+		e.BuildFiles[tag] = files
+	}
+	return types.ImageBuildResponse{Body: ioutil.NopCloser(strings.NewReader(`{"stream":"built"}`))}, nil
+}
+
+func (e *Engine) ImageRemove(ctx context.Context, id string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.images[id]; !ok {
+		return nil, errdefs.NotFound(fmt.Errorf("no such image: %s", id))
+	}
+	delete(e.images, id)
+	return []types.ImageDeleteResponseItem{{Deleted: id}}, nil
+}
+
+// ImageCount returns the number of committed images in the engine.
+func (e *Engine) ImageCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.images)
+}
+
+func (e *Engine) ContainerRestart(ctx context.Context, id string, timeout *time.Duration) error {
+	return e.ContainerStart(ctx, id, types.ContainerStartOptions{})
+}
+
+func (e *Engine) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	state := &types.ContainerState{Running: c.Running, Paused: c.Paused, Status: "created"}
+	switch {
+	case c.Paused:
+		state.Status = "paused"
+	case c.Running:
+		state.Status = "running"
+	case c.Exited:
+		state.Status = "exited"
+	}
+	if c.Config.Healthcheck != nil {
+		state.Health = &types.Health{Status: types.Healthy}
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.ID,
+			Name:       "/" + c.Name,
+			State:      state,
+			HostConfig: c.HostConfig,
+		},
+		Mounts: c.mountPoints(),
+		Config: c.Config,
+		NetworkSettings: &types.NetworkSettings{
+			NetworkSettingsBase: types.NetworkSettingsBase{Ports: c.Ports},
+			Networks: map[string]*network.EndpointSettings{
+				c.Network: {NetworkID: e.networkID(c.Network), IPAddress: c.IP},
+			},
+		},
+	}, nil
+}
+
+func (e *Engine) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list []types.Container
+	for _, c := range e.containers {
+		if !matchFilters(options.Filters, "/"+c.Name, c.Labels) || !c.Mounts(options.Filters.Get("volume")...) {
+			continue
+		}
+		state := "created"
+		if c.Running {
+			state = "running"
+		}
+		list = append(list, types.Container{
+			ID:      c.ID,
+			Names:   []string{"/" + c.Name},
+			Labels:  c.Labels,
+			State:   state,
+			Created: c.created.Unix(),
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: map[string]*network.EndpointSettings{
+					c.Network: {NetworkID: e.networkID(c.Network)},
+				},
+			},
+		})
+	}
+	return list, nil
+}
+
+func (e *Engine) ContainerRemove(ctx context.Context, id string, options types.ContainerRemoveOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.container(id); err != nil {
+		return err
+	}
+	delete(e.containers, id)
+	return nil
+}
+
+// networkID returns the ID of the network with the given name or ID.
+func (e *Engine) networkID(name string) string {
+	for _, n := range e.networks {
+		if n.name == name || n.id == name {
+			return n.id
+		}
+	}
+	return ""
+}
+
+func (e *Engine) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.networkID(name) != "" {
+		return types.NetworkCreateResponse{}, fmt.Errorf("network with name %s already exists", name)
+	}
+	n := &fakeNetwork{
+		id:      e.nextID(),
+		name:    name,
+		labels:  options.Labels,
+		created: time.Now(),
+	}
+	e.networks[n.id] = n
+
+	return types.NetworkCreateResponse{ID: n.id}, nil
+}
+
+func (e *Engine) NetworkInspect(ctx context.Context, id string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n, ok := e.networks[e.networkID(id)]
+	if !ok {
+		return types.NetworkResource{}, errdefs.NotFound(fmt.Errorf("no such network: %s", id))
+	}
+	return types.NetworkResource{
+		ID:     n.id,
+		Name:   n.name,
+		Labels: n.labels,
+		IPAM: network.IPAM{
+			Config: []network.IPAMConfig{{Subnet: "172.30.0.0/16", Gateway: "172.30.0.1"}},
+		},
+	}, nil
+}
+
+func (e *Engine) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list []types.NetworkResource
+	for _, n := range e.networks {
+		if matchFilters(options.Filters, n.name, n.labels) {
+			list = append(list, types.NetworkResource{ID: n.id, Name: n.name, Labels: n.labels, Created: n.created})
+		}
+	}
+	return list, nil
+}
+
+func (e *Engine) NetworkConnect(ctx context.Context, id, containerID string, config *network.EndpointSettings) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(containerID)
+	if err != nil {
+		return err
+	}
+	n, ok := e.networks[e.networkID(id)]
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("no such network: %s", id))
+	}
+	c.Network = n.name
+	if config != nil {
+		c.Aliases = config.Aliases
+	}
+	return nil
+}
+
+func (e *Engine) NetworkDisconnect(ctx context.Context, id, containerID string, force bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(containerID)
+	if err != nil {
+		return err
+	}
+	if c.Network == "" {
+		return errdefs.Forbidden(fmt.Errorf("container %s is not connected to network %s", containerID, id))
+	}
+	c.Network = ""
+	return nil
+}
+
+func (e *Engine) ContainerExecCreate(ctx context.Context, id string, config types.ExecConfig) (types.IDResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return types.IDResponse{}, err
+	}
+	if e.FailExec != nil && e.FailExec(config.Cmd) {
+		return types.IDResponse{}, errdefs.System(fmt.Errorf("exec failure: %s", strings.Join(config.Cmd, " ")))
+	}
+	c.Execs = append(c.Execs, config.Cmd)
+	return types.IDResponse{ID: e.nextID()}, nil
+}
+
+func (e *Engine) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	conn, _ := net.Pipe()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(strings.NewReader(""))}, nil
+}
+
+func (e *Engine) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	return types.ContainerExecInspect{ExecID: execID}, nil
+}
+
+func (e *Engine) NetworkRemove(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	nid := e.networkID(id)
+	if nid == "" {
+		return errdefs.NotFound(fmt.Errorf("no such network: %s", id))
+	}
+	delete(e.networks, nid)
+	return nil
+}
+
+// VolumeCount returns the number of volumes in the engine.
+func (e *Engine) VolumeCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.volumes)
+}
+
+func (e *Engine) VolumeCreate(ctx context.Context, options volume.VolumeCreateBody) (types.Volume, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if options.Name == "" {
+		options.Name = e.nextID()
+	}
+	v := &types.Volume{
+		Name:      options.Name,
+		Labels:    options.Labels,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	e.volumes[v.Name] = v
+
+	return *v, nil
+}
+
+func (e *Engine) VolumeList(ctx context.Context, filter filters.Args) (volume.VolumeListOKBody, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list volume.VolumeListOKBody
+	for _, v := range e.volumes {
+		if matchFilters(filter, v.Name, v.Labels) {
+			list.Volumes = append(list.Volumes, v)
+		}
+	}
+	return list, nil
+}
+
+func (e *Engine) VolumeRemove(ctx context.Context, id string, force bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.volumes[id]; !ok {
+		return errdefs.NotFound(fmt.Errorf("no such volume: %s", id))
+	}
+	delete(e.volumes, id)
+	return nil
+}
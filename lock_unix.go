@@ -0,0 +1,27 @@
+// +build !windows
+
+package testingdock
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an exclusive lock on the file with the given path, blocking
+// until it is available. The lock is released by the operating system if the
+// process dies.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN) // nolint: errcheck
+		f.Close()                                   // nolint: errcheck
+	}, nil
+}
@@ -0,0 +1,8 @@
+package testingdock
+
+import "errors"
+
+// lockFile is not supported on windows, so suites can't be shared there.
+func lockFile(path string) (func(), error) {
+	return nil, errors.New("file locks are not supported on windows")
+}
@@ -0,0 +1,119 @@
+package testingdock
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// maxLogLine is the length after which a log line without line break is
+// printed in parts.
+const maxLogLine = 64 * 1024
+
+// followLogs prints the logs of the container with the stream and the timestamp
+// of every line, until the container stops or the context is done.
+func (c *Container) followLogs(ctx context.Context) {
+	reader, err := c.cli.ContainerLogs(ctx, c.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	})
+	if err != nil {
+		printf("(loggi ) %-25s (%s) - container logging failure: %s", c.Name, c.ID, err.Error())
+		return
+	}
+	defer reader.Close() // nolint: errcheck
+	printf("(loggi ) %-25s (%s) - container logging started", c.Name, c.ID)
+
+	var mu sync.Mutex
+	stdout := &logWriter{c: c, stream: "stdout", mu: &mu}
+	stderr := &logWriter{c: c, stream: "stderr", mu: &mu}
+	// the output is only multiplexed, unless the container has a TTY
+	if c.ccfg.Tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	stdout.flush()
+	stderr.flush()
+
+	if err != nil && ctx.Err() == nil {
+		printf("(loggi ) %-25s (%s) - container logging failure: %s", c.Name, c.ID, err.Error())
+		return
+	}
+	printf("(loggi ) %-25s (%s) - %s", c.Name, c.ID, "EOF reached, stopping logging")
+}
+
+// logWriter prints every line written to it as log line of a stream of the container.
+// Lines longer than maxLogLine are printed in parts, which are cut between characters.
+type logWriter struct {
+	c      *Container
+	stream string
+	buf    []byte
+	// mu keeps the lines of both streams from interleaving
+	mu *sync.Mutex
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	rest := w.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		switch {
+		case i >= 0 && i <= maxLogLine:
+			w.print(rest[:i], false)
+			rest = rest[i+1:]
+		case len(rest) > maxLogLine:
+			i = cutLogLine(rest)
+			w.print(rest[:i], true)
+			rest = rest[i:]
+		default:
+			// keep the incomplete line at the start of the buffer
+			w.buf = append(w.buf[:0], rest...)
+			return len(p), nil
+		}
+	}
+}
+
+// cutLogLine returns where a line longer than maxLogLine is cut, which is the start
+// of the rune at maxLogLine, so that no UTF-8 encoded character is printed in parts.
+func cutLogLine(line []byte) int {
+	for i := maxLogLine; i > maxLogLine-utf8.UTFMax; i-- {
+		if utf8.RuneStart(line[i]) {
+			return i
+		}
+	}
+	// not UTF-8 encoded
+	return maxLogLine
+}
+
+// flush prints the rest of a line not terminated by a line break.
+func (w *logWriter) flush() {
+	if len(w.buf) > 0 {
+		w.print(w.buf, false)
+		w.buf = nil
+	}
+}
+
+// print prints the line, which starts with the timestamp added by docker, while
+// parts of lines after the first one do not. Parts are marked with a trailing "…".
+func (w *logWriter) print(line []byte, partial bool) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) == 0 {
+		return
+	}
+	suffix := ""
+	if partial {
+		suffix = "…"
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	printf("(clogs ) %-25s (%s) - %s %s%s", w.c.Name, w.c.ID, w.stream, line, suffix)
+}
@@ -0,0 +1,110 @@
+package testingdock_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+// syncBuffer is a buffer, which can be written and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestContainer_FollowLogs(t *testing.T) {
+	name := "TestContainer_FollowLogs"
+
+	// capture the output
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	var out syncBuffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, r) // nolint: errcheck
+		close(copied)
+	}()
+	testingdock.Verbose = true
+	defer func() {
+		testingdock.Verbose = false
+		os.Stdout = stdout
+		w.Close() // nolint: errcheck
+		<-copied
+	}()
+
+	engine := fake.NewEngine()
+	long := strings.Repeat("x", 100*1024)
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:   name,
+		Config: &container.Config{Image: "fake"},
+		PostCreate: func(ctx context.Context, c *testingdock.Container) error {
+			engine.Log(c.ID, "listening")
+			engine.LogStderr(c.ID, "warning: no config")
+			engine.Log(c.ID, long)
+			// whatever the length of the timestamp, one of the lines is cut at
+			// the limit in the middle of a character
+			for _, prefix := range []string{"", "a", "aa"} {
+				engine.Log(c.ID, prefix+strings.Repeat("€", 30*1024))
+			}
+			return nil
+		},
+	}))
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(out.String(), "EOF reached"); {
+		if time.Now().After(deadline) {
+			t.Fatalf("logging did not finish:\n%s", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	logs := out.String()
+	timestamp := `\d{4}-\d\d-\d\dT\S+ `
+	for _, pattern := range []string{
+		`stdout ` + timestamp + `listening\n`,
+		`stderr ` + timestamp + `warning: no config\n`,
+		`stdout ` + timestamp + `x+…\n`,
+		`stdout x{1000,}\n`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(logs) {
+			t.Errorf("logs do not match %s", pattern)
+		}
+	}
+	if !utf8.ValidString(logs) {
+		t.Error("logs contain characters cut in parts")
+	}
+	if strings.Contains(logs, "\x01\x00\x00\x00") {
+		t.Error("logs contain stream headers")
+	}
+}
@@ -0,0 +1,15 @@
+// Package modules contains ready-made containers for common services, which
+// come with the right image, environment, health check and a ResetFunc wiping
+// all data, e.g.:
+//  pg := postgres.New(s, postgres.Opts{})
+//  n.After(pg.Container)
+//  s.Start(ctx)
+//  db, err := sql.Open("postgres", pg.ConnectionString())
+//
+// The health checks and resets are executed within the containers, so no client
+// library of the service is needed. The services are published on a random port
+// of the docker host, unless Opts.Port is set, which is required for reused
+// containers, as a different port changes their configuration. The lifecycle hooks
+// of the Opts, e.g. PostHealthy running the migrations, are called after the ones
+// of the modules.
+package modules
@@ -0,0 +1,70 @@
+// Package hostaddr records the address on the docker host, under which a
+// published port of a module container is reachable from the tests.
+package hostaddr
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/docker/go-connections/nat"
+
+	"github.com/m4ksio/testingdock"
+)
+
+// Addr is the address of a published container port on the docker host. It
+// is recorded by Record, which is used as hook of the container, as docker
+// may pick another random host port whenever the container starts.
+type Addr struct {
+	port nat.Port
+	// mu guards host and hostPort
+	mu             sync.Mutex
+	host, hostPort string
+}
+
+// New returns the address of the given container port, e.g. "5432/tcp".
+func New(port nat.Port) *Addr {
+	return &Addr{port: port}
+}
+
+// Record looks up the address of the port. It is used as PostStart hook of the
+// container, and as PostReset hook as well if the reset restarts the container.
+func (a *Addr) Record(ctx context.Context, c *testingdock.Container) error {
+	addr, err := c.HostAddr(ctx, a.port)
+	if err != nil {
+		return err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.host, a.hostPort = host, port
+	a.mu.Unlock()
+	return nil
+}
+
+// Host returns the docker host, once the address has been recorded.
+func (a *Addr) Host() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.host
+}
+
+// Port returns the port on the docker host, once the address has been recorded.
+func (a *Addr) Port() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.hostPort
+}
+
+// String returns the address in the form "host:port", once it has been recorded.
+func (a *Addr) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return net.JoinHostPort(a.host, a.hostPort)
+}
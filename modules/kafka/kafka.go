@@ -0,0 +1,127 @@
+// Package kafka provides a ready-made single node Kafka container, which runs
+// without ZooKeeper in KRaft mode.
+package kafka
+
+import (
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/m4ksio/testingdock"
+)
+
+// DefaultImage is the image used if Opts.Image is not set.
+const DefaultImage = "bitnami/kafka:3.4"
+
+// port of the listener advertised on the docker host
+const port = nat.Port("9094/tcp")
+
+// Opts is an option struct for creating a Kafka container.
+type Opts struct {
+	// default is "kafka"
+	Name string
+	// default is DefaultImage
+	Image     string
+	ForcePull bool
+	Reuse     bool
+	// host port, default is a random port. It has to be known before the
+	// container is created, as the broker advertises it to clients, so it
+	// is part of the configuration and has to be set for reused containers.
+	Port string
+	// default is 90s
+	HealthCheckTimeout time.Duration
+	// lifecycle hooks, see testingdock.ContainerOpts, called after the ones of
+	// the module, e.g. PostHealthy to create the topics
+	PostCreate, PostStart, PostHealthy testingdock.HookFunc
+	PreReset, PostReset, PreClose      testingdock.HookFunc
+}
+
+// Container is a Kafka container. It is healthy once the broker lists its
+// topics and reset by a restart, as the data is kept in memory.
+type Container struct {
+	*testingdock.Container
+	opts Opts
+	// host under which the broker is reachable from the tests
+	host string
+}
+
+// New creates a Kafka container configuration in the suite, which still has
+// to be added to the network. Containers in the network reach the broker at
+// Opts.Name on port 9092.
+func New(s *testingdock.Suite, opts Opts) *Container {
+	if opts.Name == "" {
+		opts.Name = "kafka"
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.Port == "" {
+		// a random port would change the configuration on every run, so the
+		// container would never be adopted
+		if opts.Reuse {
+			s.T().Fatalf("kafka: Opts.Port has to be set for the reused container %s", opts.Name)
+		}
+		opts.Port = testingdock.RandomPort(s.T())
+	}
+	if opts.HealthCheckTimeout == 0 {
+		opts.HealthCheckTimeout = 90 * time.Second
+	}
+
+	host := s.Host()
+	return &Container{
+		Container: s.Container(testingdock.ContainerOpts{
+			Name:      opts.Name,
+			ForcePull: opts.ForcePull,
+			Reuse:     opts.Reuse,
+			Config: &container.Config{
+				Image: opts.Image,
+				Env: []string{
+					"ALLOW_PLAINTEXT_LISTENER=yes",
+					"KAFKA_ENABLE_KRAFT=yes",
+					"KAFKA_CFG_NODE_ID=0",
+					"KAFKA_CFG_PROCESS_ROLES=controller,broker",
+					"KAFKA_CFG_CONTROLLER_QUORUM_VOTERS=0@127.0.0.1:9093",
+					"KAFKA_CFG_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+					"KAFKA_CFG_LISTENERS=PLAINTEXT://:9092,CONTROLLER://:9093,EXTERNAL://:9094",
+					"KAFKA_CFG_LISTENER_SECURITY_PROTOCOL_MAP=CONTROLLER:PLAINTEXT,EXTERNAL:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+					"KAFKA_CFG_ADVERTISED_LISTENERS=PLAINTEXT://" + opts.Name + ":9092,EXTERNAL://" + net.JoinHostPort(host, opts.Port),
+					"KAFKA_CFG_AUTO_CREATE_TOPICS_ENABLE=true",
+					"KAFKA_CFG_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+				},
+				ExposedPorts: nat.PortSet{port: {}},
+			},
+			HostConfig: &container.HostConfig{
+				PortBindings: nat.PortMap{port: {{HostPort: opts.Port}}},
+			},
+			// the tmpfs is emptied by the default reset, which restarts the container
+			Tmpfs:              []string{"/bitnami/kafka"},
+			HealthCheck:        testingdock.HealthCheckExec("kafka-topics.sh", "--bootstrap-server", "localhost:9092", "--list"),
+			HealthCheckTimeout: opts.HealthCheckTimeout,
+			PostCreate:         opts.PostCreate,
+			PostStart:          opts.PostStart,
+			PostHealthy:        opts.PostHealthy,
+			PreReset:           opts.PreReset,
+			PostReset:          opts.PostReset,
+			PreClose:           opts.PreClose,
+		}),
+		opts: opts,
+		host: host,
+	}
+}
+
+// Port returns the port of the broker on the docker host.
+func (c *Container) Port() string {
+	return c.opts.Port
+}
+
+// Brokers returns the addresses of the brokers on the docker host.
+func (c *Container) Brokers() []string {
+	return []string{c.ConnectionString()}
+}
+
+// ConnectionString returns the bootstrap servers on the docker host.
+func (c *Container) ConnectionString() string {
+	return net.JoinHostPort(c.host, c.opts.Port)
+}
@@ -0,0 +1,139 @@
+package kafka_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/client"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+	"github.com/m4ksio/testingdock/modules/kafka"
+)
+
+func TestNew(t *testing.T) {
+	// the container is only configured, so the daemon is never contacted
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://docker.example:2376"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, "TestKafka_New", testingdock.SuiteOpts{Client: cli})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestKafka_New"})
+	k := kafka.New(s, kafka.Opts{})
+	n.After(k.Container)
+	fixed := kafka.New(s, kafka.Opts{Name: "broker", Port: "19094"})
+	n.After(fixed.Container)
+
+	// the port is picked before the container is created
+	if _, err = strconv.Atoi(k.Port()); err != nil {
+		t.Errorf("random port expected, got %q", k.Port())
+	}
+	// the broker is advertised on the docker host
+	if expected := "docker.example:" + k.Port(); k.ConnectionString() != expected || !reflect.DeepEqual(k.Brokers(), []string{expected}) {
+		t.Errorf("wrong brokers %s and %v, expected %s", k.ConnectionString(), k.Brokers(), expected)
+	}
+	if fixed.ConnectionString() != "docker.example:19094" {
+		t.Errorf("wrong broker %s", fixed.ConnectionString())
+	}
+
+	sp := s.Spec()
+	if len(sp.Containers) != 2 {
+		t.Fatalf("two containers expected, got %d", len(sp.Containers))
+	}
+	for i, expected := range []struct {
+		name, port, listeners string
+	}{
+		{"kafka", k.Port() + ":9094/tcp", "PLAINTEXT://kafka:9092,EXTERNAL://docker.example:" + k.Port()},
+		{"broker", "19094:9094/tcp", "PLAINTEXT://broker:9092,EXTERNAL://docker.example:19094"},
+	} {
+		cs := sp.Containers[i]
+		if cs.Name != expected.name || cs.Image != kafka.DefaultImage {
+			t.Errorf("wrong container %s with image %s", cs.Name, cs.Image)
+		}
+		if !reflect.DeepEqual(cs.Ports, []string{expected.port}) {
+			t.Errorf("wrong ports of %s: %v", cs.Name, cs.Ports)
+		}
+		var listeners string
+		for _, kv := range cs.Env {
+			if strings.HasPrefix(kv, "KAFKA_CFG_ADVERTISED_LISTENERS=") {
+				listeners = strings.TrimPrefix(kv, "KAFKA_CFG_ADVERTISED_LISTENERS=")
+			}
+		}
+		if listeners != expected.listeners {
+			t.Errorf("wrong advertised listeners of %s: %s, expected %s", cs.Name, listeners, expected.listeners)
+		}
+	}
+}
+
+func TestContainer_Lifecycle(t *testing.T) {
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestKafka_Lifecycle", testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestKafka_Lifecycle"})
+
+	var called []string
+	hook := func(name string) testingdock.HookFunc {
+		return func(ctx context.Context, c *testingdock.Container) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+	k := kafka.New(s, kafka.Opts{PostHealthy: hook("post healthy"), PostReset: hook("post reset")})
+	n.After(k.Container)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	id := engine.ByName("kafka").ID
+	s.Reset(context.TODO())
+
+	// the broker lists the topics on start and after the restart of the reset
+	check := []string{"kafka-topics.sh", "--bootstrap-server", "localhost:9092", "--list"}
+	if execs := engine.ByName("kafka").Execs; !reflect.DeepEqual(execs, [][]string{check, check}) {
+		t.Errorf("unexpected commands: %v", execs)
+	}
+	if engine.ByName("kafka").ID != id {
+		t.Error("the container should be restarted, not recreated")
+	}
+	if !reflect.DeepEqual(called, []string{"post healthy", "post reset"}) {
+		t.Errorf("the hooks of the user should be called, got %v", called)
+	}
+}
+
+// fatalReporter records the fatal failure instead of failing the test.
+type fatalReporter struct {
+	*testing.T
+	msg string
+}
+
+func (r *fatalReporter) Fatalf(format string, args ...interface{}) {
+	r.msg = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestNew_ReuseRandomPort(t *testing.T) {
+	// the suite reports its failures to the test it was created with
+	r := &fatalReporter{T: t}
+	s, _ := testingdock.GetOrCreateSuite(r, "TestKafka_ReuseRandomPort", testingdock.SuiteOpts{Client: fake.NewEngine()})
+	defer s.Close() // nolint: errcheck
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		kafka.New(s, kafka.Opts{Reuse: true})
+	}()
+	<-done
+	if !strings.Contains(r.msg, "Opts.Port has to be set") {
+		t.Errorf("a reused container without a fixed port should be refused, got %q", r.msg)
+	}
+
+	// a fixed port keeps the configuration of reused containers stable
+	r.msg = ""
+	kafka.New(s, kafka.Opts{Name: "broker", Reuse: true, Port: "19094"})
+	if r.msg != "" {
+		t.Errorf("unexpected failure: %s", r.msg)
+	}
+}
@@ -0,0 +1,134 @@
+// Package minio provides a ready-made MinIO container, an S3 compatible
+// object storage.
+package minio
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/modules/internal/hostaddr"
+)
+
+// DefaultImage is the image used if Opts.Image is not set.
+const DefaultImage = "minio/minio:RELEASE.2020-01-25T02-50-51Z"
+
+const port = nat.Port("9000/tcp")
+
+// Opts is an option struct for creating a MinIO container.
+type Opts struct {
+	// default is "minio"
+	Name string
+	// default is DefaultImage
+	Image     string
+	ForcePull bool
+	Reuse     bool
+	// default is "minioadmin"
+	AccessKey string
+	// default is "minioadmin"
+	SecretKey string
+	// host port, default is a random port
+	Port string
+	// default is 30s
+	HealthCheckTimeout time.Duration
+	// lifecycle hooks, see testingdock.ContainerOpts, called after the ones of
+	// the module, e.g. PostHealthy to create the buckets
+	PostCreate, PostStart, PostHealthy testingdock.HookFunc
+	PreReset, PostReset, PreClose      testingdock.HookFunc
+}
+
+// Container is a MinIO container. It is healthy once the server is ready and
+// reset by a restart, as the data is kept in memory.
+type Container struct {
+	*testingdock.Container
+	opts Opts
+	addr *hostaddr.Addr
+}
+
+// New creates a MinIO container configuration in the suite, which still has
+// to be added to the network.
+func New(s *testingdock.Suite, opts Opts) *Container {
+	if opts.Name == "" {
+		opts.Name = "minio"
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.AccessKey == "" {
+		opts.AccessKey = "minioadmin"
+	}
+	if opts.SecretKey == "" {
+		opts.SecretKey = "minioadmin"
+	}
+
+	c := &Container{opts: opts, addr: hostaddr.New(port)}
+	c.Container = s.Container(testingdock.ContainerOpts{
+		Name:      opts.Name,
+		ForcePull: opts.ForcePull,
+		Reuse:     opts.Reuse,
+		Config: &container.Config{
+			Image: opts.Image,
+			Cmd:   []string{"server", "/data"},
+			Env: []string{
+				"MINIO_ACCESS_KEY=" + opts.AccessKey,
+				"MINIO_SECRET_KEY=" + opts.SecretKey,
+			},
+			ExposedPorts: nat.PortSet{port: {}},
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{port: {{HostPort: opts.Port}}},
+		},
+		// the tmpfs is emptied by the default reset, which restarts the container
+		Tmpfs:              []string{"/data"},
+		HealthCheck:        healthCheck,
+		HealthCheckTimeout: opts.HealthCheckTimeout,
+		// the restart may publish the port on another random host port
+		PostCreate:  opts.PostCreate,
+		PostStart:   testingdock.ChainHooks(c.addr.Record, opts.PostStart),
+		PostHealthy: opts.PostHealthy,
+		PreReset:    opts.PreReset,
+		PostReset:   testingdock.ChainHooks(c.addr.Record, opts.PostReset),
+		PreClose:    opts.PreClose,
+	})
+
+	return c
+}
+
+// healthCheck checks the readiness endpoint on the docker host.
+func healthCheck(ctx context.Context, cont *testingdock.Container) error {
+	addr, err := cont.HostAddr(ctx, port)
+	if err != nil {
+		return err
+	}
+	return testingdock.HealthCheckHTTP("http://"+addr+"/minio/health/ready")(ctx, cont)
+}
+
+// Port returns the port of the server on the docker host, once the container started.
+func (c *Container) Port() string {
+	return c.addr.Port()
+}
+
+// Endpoint returns the address of the server on the docker host, once the
+// container started.
+func (c *Container) Endpoint() string {
+	return c.addr.String()
+}
+
+// ConnectionString returns the URL of the server on the docker host, once the
+// container started.
+func (c *Container) ConnectionString() string {
+	return "http://" + c.Endpoint()
+}
+
+// AccessKey returns the access key of the server.
+func (c *Container) AccessKey() string {
+	return c.opts.AccessKey
+}
+
+// SecretKey returns the secret key of the server.
+func (c *Container) SecretKey() string {
+	return c.opts.SecretKey
+}
@@ -0,0 +1,93 @@
+package minio_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/client"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+	"github.com/m4ksio/testingdock/modules/minio"
+)
+
+func TestNew(t *testing.T) {
+	// the container is only configured, so the daemon is never contacted
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://docker.example:2376"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, "TestMinIO_New", testingdock.SuiteOpts{Client: cli})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestMinIO_New"})
+	m := minio.New(s, minio.Opts{AccessKey: "access", Port: "19000"})
+	n.After(m.Container)
+
+	if m.AccessKey() != "access" || m.SecretKey() != "minioadmin" {
+		t.Errorf("wrong keys %s and %s", m.AccessKey(), m.SecretKey())
+	}
+
+	sp := s.Spec()
+	if len(sp.Containers) != 1 {
+		t.Fatalf("one container expected, got %d", len(sp.Containers))
+	}
+	cs := sp.Containers[0]
+	if cs.Name != "minio" || cs.Image != minio.DefaultImage {
+		t.Errorf("wrong container %s with image %s", cs.Name, cs.Image)
+	}
+	if !reflect.DeepEqual(cs.Env, []string{"MINIO_ACCESS_KEY=access", "MINIO_SECRET_KEY=minioadmin"}) {
+		t.Errorf("wrong environment: %v", cs.Env)
+	}
+	if !reflect.DeepEqual(cs.Ports, []string{"19000:9000/tcp"}) {
+		t.Errorf("wrong ports: %v", cs.Ports)
+	}
+	// the data is kept in memory, so the restart of the default reset wipes it
+	if !reflect.DeepEqual(cs.Tmpfs, []string{"/data"}) || cs.Reset != nil {
+		t.Errorf("the data should be kept in memory and reset by a restart: %v, %+v", cs.Tmpfs, cs.Reset)
+	}
+}
+
+func TestContainer_Lifecycle(t *testing.T) {
+	// the fake engine publishes the port as requested, so the server stands in
+	// for the readiness endpoint
+	var ready int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/minio/health/ready" {
+			atomic.AddInt32(&ready, 1)
+		}
+	}))
+	defer srv.Close()
+	_, p, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestMinIO_Lifecycle", testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestMinIO_Lifecycle"})
+
+	var endpoints []string
+	var m *minio.Container
+	record := func(ctx context.Context, c *testingdock.Container) error {
+		endpoints = append(endpoints, m.Endpoint())
+		return nil
+	}
+	m = minio.New(s, minio.Opts{Port: p, PostStart: record, PostReset: record})
+	n.After(m.Container)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	s.Reset(context.TODO())
+
+	// the address is recorded before the hooks of the user run
+	if expected := "localhost:" + p; !reflect.DeepEqual(endpoints, []string{expected, expected}) {
+		t.Errorf("unexpected endpoints %v, expected %s", endpoints, expected)
+	}
+	if ready := atomic.LoadInt32(&ready); ready < 2 {
+		t.Errorf("the readiness should be checked on start and reset, got %d requests", ready)
+	}
+}
@@ -0,0 +1,127 @@
+// Package mysql provides a ready-made MySQL container.
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/modules/internal/hostaddr"
+)
+
+// DefaultImage is the image used if Opts.Image is not set.
+const DefaultImage = "mysql:8.0"
+
+const port = nat.Port("3306/tcp")
+
+// Opts is an option struct for creating a MySQL container.
+type Opts struct {
+	// default is "mysql"
+	Name string
+	// default is DefaultImage
+	Image     string
+	ForcePull bool
+	Reuse     bool
+	// default is "mysql"
+	RootPassword string
+	// user with all privileges on the database, default is "test"
+	User string
+	// default is "test"
+	Password string
+	// default is "test"
+	Database string
+	// host port, default is a random port
+	Port string
+	// default is 120s
+	HealthCheckTimeout time.Duration
+	// lifecycle hooks, see testingdock.ContainerOpts, called after the ones of
+	// the module, e.g. PostHealthy to run migrations
+	PostCreate, PostStart, PostHealthy testingdock.HookFunc
+	PreReset, PostReset, PreClose      testingdock.HookFunc
+}
+
+// Container is a MySQL container. It is healthy once the server accepts
+// connections and reset by recreating the database.
+type Container struct {
+	*testingdock.Container
+	opts Opts
+	addr *hostaddr.Addr
+}
+
+// New creates a MySQL container configuration in the suite, which still has
+// to be added to the network.
+func New(s *testingdock.Suite, opts Opts) *Container {
+	if opts.Name == "" {
+		opts.Name = "mysql"
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.RootPassword == "" {
+		opts.RootPassword = "mysql"
+	}
+	if opts.User == "" {
+		opts.User = "test"
+	}
+	if opts.Password == "" {
+		opts.Password = "test"
+	}
+	if opts.Database == "" {
+		opts.Database = "test"
+	}
+	if opts.HealthCheckTimeout == 0 {
+		opts.HealthCheckTimeout = 120 * time.Second
+	}
+
+	// the temporary server running the init scripts does not listen on tcp,
+	// so the health check has to connect via tcp
+	auth := []string{"-h", "127.0.0.1", "-uroot", "-p" + opts.RootPassword}
+
+	c := &Container{opts: opts, addr: hostaddr.New(port)}
+	c.Container = s.Container(testingdock.ContainerOpts{
+		Name:      opts.Name,
+		ForcePull: opts.ForcePull,
+		Reuse:     opts.Reuse,
+		Config: &container.Config{
+			Image: opts.Image,
+			Env: []string{
+				"MYSQL_ROOT_PASSWORD=" + opts.RootPassword,
+				"MYSQL_USER=" + opts.User,
+				"MYSQL_PASSWORD=" + opts.Password,
+				"MYSQL_DATABASE=" + opts.Database,
+			},
+			ExposedPorts: nat.PortSet{port: {}},
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{port: {{HostPort: opts.Port}}},
+		},
+		Tmpfs:              []string{"/var/lib/mysql"},
+		HealthCheck:        testingdock.HealthCheckExec(append([]string{"mysqladmin", "ping"}, auth...)...),
+		HealthCheckTimeout: opts.HealthCheckTimeout,
+		// privileges granted on the database survive recreating it
+		Reset: testingdock.ResetExec(append(append([]string{"mysql"}, auth...),
+			"-e", fmt.Sprintf("DROP DATABASE `%[1]s`; CREATE DATABASE `%[1]s`;", opts.Database))...),
+		PostCreate:  opts.PostCreate,
+		PostStart:   testingdock.ChainHooks(c.addr.Record, opts.PostStart),
+		PostHealthy: opts.PostHealthy,
+		PreReset:    opts.PreReset,
+		PostReset:   opts.PostReset,
+		PreClose:    opts.PreClose,
+	})
+
+	return c
+}
+
+// Port returns the port of the server on the docker host, once the container started.
+func (c *Container) Port() string {
+	return c.addr.Port()
+}
+
+// ConnectionString returns the data source name of the database on the docker
+// host in the format of github.com/go-sql-driver/mysql, once the container started.
+func (c *Container) ConnectionString() string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s", c.opts.User, c.opts.Password, c.addr, c.opts.Database)
+}
@@ -0,0 +1,85 @@
+package mysql_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/client"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+	"github.com/m4ksio/testingdock/modules/mysql"
+)
+
+func TestNew(t *testing.T) {
+	// the container is only configured, so the daemon is never contacted
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://docker.example:2376"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, "TestMySQL_New", testingdock.SuiteOpts{Client: cli})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestMySQL_New"})
+	n.After(mysql.New(s, mysql.Opts{}).Container)
+	n.After(mysql.New(s, mysql.Opts{Name: "shop", User: "shop", Password: "secret", Database: "orders", Port: "13306"}).Container)
+
+	sp := s.Spec()
+	if len(sp.Containers) != 2 {
+		t.Fatalf("two containers expected, got %d", len(sp.Containers))
+	}
+	for i, expected := range []struct {
+		name, port string
+		env        []string
+	}{
+		{"mysql", "3306/tcp", []string{"MYSQL_ROOT_PASSWORD=mysql", "MYSQL_USER=test", "MYSQL_PASSWORD=test", "MYSQL_DATABASE=test"}},
+		{"shop", "13306:3306/tcp", []string{"MYSQL_ROOT_PASSWORD=mysql", "MYSQL_USER=shop", "MYSQL_PASSWORD=secret", "MYSQL_DATABASE=orders"}},
+	} {
+		cs := sp.Containers[i]
+		if cs.Name != expected.name || cs.Image != mysql.DefaultImage {
+			t.Errorf("wrong container %s with image %s", cs.Name, cs.Image)
+		}
+		if !reflect.DeepEqual(cs.Env, expected.env) {
+			t.Errorf("wrong environment of %s: %v", cs.Name, cs.Env)
+		}
+		if !reflect.DeepEqual(cs.Ports, []string{expected.port}) {
+			t.Errorf("wrong ports of %s: %v", cs.Name, cs.Ports)
+		}
+		if !reflect.DeepEqual(cs.Tmpfs, []string{"/var/lib/mysql"}) {
+			t.Errorf("the data of %s should be kept in memory: %v", cs.Name, cs.Tmpfs)
+		}
+	}
+}
+
+func TestContainer_Lifecycle(t *testing.T) {
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestMySQL_Lifecycle", testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestMySQL_Lifecycle"})
+
+	// the hooks of the user run after the ones of the module
+	var dsn string
+	var m *mysql.Container
+	m = mysql.New(s, mysql.Opts{
+		PostHealthy: func(ctx context.Context, c *testingdock.Container) error {
+			dsn = m.ConnectionString()
+			return nil
+		},
+	})
+	n.After(m.Container)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	if m.Port() == "" || dsn != "test:test@tcp(localhost:"+m.Port()+")/test" {
+		t.Errorf("the address should be recorded before the hook of the user, got %q", dsn)
+	}
+	execs := engine.ByName("mysql").Execs
+	if len(execs) != 1 || !reflect.DeepEqual(execs[0][:2], []string{"mysqladmin", "ping"}) {
+		t.Fatalf("the health check should ping the server, got %v", execs)
+	}
+
+	s.Reset(context.TODO())
+	execs = engine.ByName("mysql").Execs
+	if reset := strings.Join(execs[1], " "); !strings.Contains(reset, "DROP DATABASE `test`; CREATE DATABASE `test`;") {
+		t.Errorf("the reset should recreate the database, got %s", reset)
+	}
+}
@@ -0,0 +1,282 @@
+// Package postgres provides a ready-made PostgreSQL container.
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/modules/internal/hostaddr"
+)
+
+// DefaultImage is the image used if Opts.Image is not set.
+const DefaultImage = "postgres:12-alpine"
+
+const port = nat.Port("5432/tcp")
+
+// wipeSQL drops all schemas and recreates the public one.
+const wipeSQL = `DO $$
+DECLARE s text;
+BEGIN
+	FOR s IN SELECT nspname FROM pg_namespace WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema' LOOP
+		EXECUTE 'DROP SCHEMA ' || quote_ident(s) || ' CASCADE';
+	END LOOP;
+	CREATE SCHEMA public;
+END $$;`
+
+// Opts is an option struct for creating a PostgreSQL container.
+type Opts struct {
+	// default is "postgres"
+	Name string
+	// default is DefaultImage
+	Image     string
+	ForcePull bool
+	Reuse     bool
+	// default is "postgres"
+	User string
+	// default is "postgres"
+	Password string
+	// default is "postgres"
+	Database string
+	// host port, default is a random port
+	Port string
+	// default is 60s
+	HealthCheckTimeout time.Duration
+	// lifecycle hooks, see testingdock.ContainerOpts, called after the ones of
+	// the module, e.g. PostHealthy to run the migrations before a Snapshot
+	PostCreate, PostStart, PostHealthy testingdock.HookFunc
+	PreReset, PostReset, PreClose      testingdock.HookFunc
+}
+
+// Container is a PostgreSQL container. It is healthy once the database accepts
+// queries and reset by dropping all schemas, or by recreating the database from
+// its snapshot, see Snapshot.
+type Container struct {
+	*testingdock.Container
+	opts Opts
+	addr *hostaddr.Addr
+	// mu serializes the database operations and guards snapshot and databases
+	mu        sync.Mutex
+	snapshot  bool
+	databases int
+}
+
+// New creates a PostgreSQL container configuration in the suite, which still
+// has to be added to the network.
+func New(s *testingdock.Suite, opts Opts) *Container {
+	if opts.Name == "" {
+		opts.Name = "postgres"
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.User == "" {
+		opts.User = "postgres"
+	}
+	if opts.Password == "" {
+		opts.Password = "postgres"
+	}
+	if opts.Database == "" {
+		opts.Database = "postgres"
+	}
+	if opts.HealthCheckTimeout == 0 {
+		opts.HealthCheckTimeout = 60 * time.Second
+	}
+
+	c := &Container{opts: opts, addr: hostaddr.New(port)}
+	c.Container = s.Container(testingdock.ContainerOpts{
+		Name:      opts.Name,
+		ForcePull: opts.ForcePull,
+		Reuse:     opts.Reuse,
+		Config: &container.Config{
+			Image: opts.Image,
+			Env: []string{
+				"POSTGRES_USER=" + opts.User,
+				"POSTGRES_PASSWORD=" + opts.Password,
+				"POSTGRES_DB=" + opts.Database,
+			},
+			ExposedPorts: nat.PortSet{port: {}},
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{port: {{HostPort: opts.Port}}},
+		},
+		Tmpfs:              []string{"/var/lib/postgresql/data"},
+		HealthCheck:        testingdock.HealthCheckExec(c.psql(opts.Database, "SELECT 1")...),
+		HealthCheckTimeout: opts.HealthCheckTimeout,
+		Reset:              c.reset,
+		PostCreate:         opts.PostCreate,
+		PostStart:          testingdock.ChainHooks(c.addr.Record, opts.PostStart),
+		PostHealthy:        opts.PostHealthy,
+		PreReset:           opts.PreReset,
+		PostReset:          opts.PostReset,
+		PreClose:           opts.PreClose,
+	})
+
+	return c
+}
+
+// psql returns the command executing the given statements one after another
+// in the given database.
+func (c *Container) psql(database string, statements ...string) []string {
+	// the temporary server running the init scripts only listens on the unix
+	// socket, so the health check has to connect via tcp
+	cmd := []string{"psql", "-h", "127.0.0.1", "-U", c.opts.User, "-d", database, "-v", "ON_ERROR_STOP=1"}
+	for _, stmt := range statements {
+		cmd = append(cmd, "-c", stmt)
+	}
+	return cmd
+}
+
+// maintenance returns the database used to create and drop the other ones.
+func (c *Container) maintenance() string {
+	if c.opts.Database == "postgres" {
+		return "template1"
+	}
+	return "postgres"
+}
+
+// template returns the name of the template database holding the snapshot.
+func (c *Container) template() string {
+	return c.opts.Database + "_template"
+}
+
+// scratch returns the name of the database the snapshot is copied into on reset.
+func (c *Container) scratch() string {
+	return c.opts.Database + "_reset"
+}
+
+// quote quotes the identifier.
+func quote(ident string) string {
+	return `"` + strings.Replace(ident, `"`, `""`, -1) + `"`
+}
+
+// terminate returns the statements refusing new and closing the open connections
+// to the given database, which has to be done before it can be copied or dropped.
+func terminate(database string) []string {
+	return []string{
+		fmt.Sprintf("ALTER DATABASE %s ALLOW_CONNECTIONS false", quote(database)),
+		fmt.Sprintf("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = '%s'", strings.Replace(database, "'", "''", -1)),
+	}
+}
+
+// reset drops all schemas, or recreates the database from the snapshot if there is one.
+func (c *Container) reset(ctx context.Context, cont *testingdock.Container) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.snapshot {
+		return cont.Exec(ctx, c.psql(c.opts.Database, wipeSQL)...)
+	}
+
+	// the copy is created next to the database first, so a failure leaves the
+	// database in place
+	stmts := append(terminate(c.opts.Database),
+		fmt.Sprintf("DROP DATABASE IF EXISTS %s", quote(c.scratch())),
+		fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", quote(c.scratch()), quote(c.template())),
+		fmt.Sprintf("DROP DATABASE %s", quote(c.opts.Database)),
+		fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", quote(c.scratch()), quote(c.opts.Database)),
+	)
+	if err := cont.Exec(ctx, c.psql(c.maintenance(), stmts...)...); err != nil {
+		return c.recover(err, fmt.Sprintf("DROP DATABASE IF EXISTS %s", quote(c.scratch())))
+	}
+	return nil
+}
+
+// recover re-enables the connections to the database after the given failure
+// of a reset or snapshot, after running the given clean-up statements.
+func (c *Container) recover(err error, stmts ...string) error {
+	stmts = append(stmts, fmt.Sprintf("ALTER DATABASE %s ALLOW_CONNECTIONS true", quote(c.opts.Database)))
+	if rerr := c.Exec(context.Background(), c.psql(c.maintenance(), stmts...)...); rerr != nil {
+		return fmt.Errorf("%s, recovery failure: %s", err.Error(), rerr.Error())
+	}
+	return err
+}
+
+// Snapshot copies the current state of the database, e.g. after the migrations
+// ran, into a template database. Afterwards Reset recreates the database from the
+// template, which is a lot faster than dropping all schemas and keeps extensions,
+// and NewDatabase clones it. Open connections to the database are closed.
+func (c *Container) Snapshot(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.takeSnapshot(ctx)
+}
+
+// takeSnapshot takes the snapshot, the mutex has to be held.
+func (c *Container) takeSnapshot(ctx context.Context) error {
+	// a template database can not be dropped, in case of an earlier snapshot
+	stmts := append(terminate(c.opts.Database),
+		fmt.Sprintf("UPDATE pg_database SET datistemplate = false WHERE datname = '%s'", strings.Replace(c.template(), "'", "''", -1)),
+		fmt.Sprintf("DROP DATABASE IF EXISTS %s", quote(c.template())),
+		fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", quote(c.template()), quote(c.opts.Database)),
+		fmt.Sprintf("ALTER DATABASE %s IS_TEMPLATE true ALLOW_CONNECTIONS false", quote(c.template())),
+		fmt.Sprintf("ALTER DATABASE %s ALLOW_CONNECTIONS true", quote(c.opts.Database)),
+	)
+	if err := c.Exec(ctx, c.psql(c.maintenance(), stmts...)...); err != nil {
+		// the template of an earlier snapshot may be gone already
+		c.snapshot = false
+		return c.recover(err)
+	}
+
+	c.snapshot = true
+	return nil
+}
+
+// NewDatabase creates a database cloned from the snapshot for the given test and
+// returns its connection string. The database is dropped once the test finished.
+// This allows parallel tests to use isolated databases. If no snapshot has been
+// taken yet, it is taken first.
+func (c *Container) NewDatabase(ctx context.Context, t testing.TB) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.snapshot {
+		if err := c.takeSnapshot(ctx); err != nil {
+			t.Fatalf("database snapshot failure: %s", err.Error())
+		}
+	}
+
+	c.databases++
+	// reused containers may still hold the databases of earlier runs
+	database := fmt.Sprintf("%s_%.8s_%d", c.opts.Database, testingdock.SessionID(), c.databases)
+	stmt := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", quote(database), quote(c.template()))
+	if err := c.Exec(ctx, c.psql(c.maintenance(), stmt)...); err != nil {
+		t.Fatalf("database creation failure: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		stmts := append(terminate(database), fmt.Sprintf("DROP DATABASE %s", quote(database)))
+		if err := c.Exec(context.Background(), c.psql(c.maintenance(), stmts...)...); err != nil {
+			t.Errorf("database removal failure: %s", err.Error())
+		}
+	})
+
+	return c.connectionString(database)
+}
+
+// Port returns the port of the database on the docker host, once the container started.
+func (c *Container) Port() string {
+	return c.addr.Port()
+}
+
+// ConnectionString returns the URL of the database on the docker host, once the
+// container started.
+func (c *Container) ConnectionString() string {
+	return c.connectionString(c.opts.Database)
+}
+
+// connectionString returns the URL of the given database on the docker host.
+func (c *Container) connectionString(database string) string {
+	return fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", c.opts.User, c.opts.Password, c.addr, database)
+}
@@ -0,0 +1,114 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/docker/docker/client"
+	_ "github.com/lib/pq"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/modules/postgres"
+)
+
+// dockerClient returns a client of the docker daemon, the test is skipped if
+// there is none.
+func dockerClient(t *testing.T) client.APIClient {
+	cli, err := testingdock.NewClient()
+	if err == nil {
+		_, err = cli.Ping(context.TODO())
+	}
+	if err != nil {
+		t.Skipf("docker daemon not available: %s", err.Error())
+	}
+	return cli
+}
+
+func TestNew(t *testing.T) {
+	name := "TestPostgres_New"
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: dockerClient(t)})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	pg := postgres.New(s, postgres.Opts{Database: "test"})
+	n.After(pg.Container)
+
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	db, err := sql.Open("postgres", pg.ConnectionString())
+	if err != nil {
+		t.Fatalf("database connection error: %s", err.Error())
+	}
+	defer db.Close() // nolint: errcheck
+
+	if _, err = db.Exec("CREATE SCHEMA example; CREATE TABLE public.example (name TEXT);"); err != nil {
+		t.Fatalf("table creation error: %s", err.Error())
+	}
+
+	s.Reset(context.TODO())
+
+	var tables int
+	if err = db.QueryRow("SELECT count(*) FROM pg_tables WHERE schemaname IN ('public', 'example')").Scan(&tables); err != nil {
+		t.Fatalf("query error: %s", err.Error())
+	}
+	if tables != 0 {
+		t.Errorf("reset should wipe all tables, %d are left", tables)
+	}
+}
+
+func TestContainer_Snapshot(t *testing.T) {
+	name := "TestPostgres_Snapshot"
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: dockerClient(t)})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	pg := postgres.New(s, postgres.Opts{Database: "test"})
+	n.After(pg.Container)
+
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	db, err := sql.Open("postgres", pg.ConnectionString())
+	if err != nil {
+		t.Fatalf("database connection error: %s", err.Error())
+	}
+	defer db.Close() // nolint: errcheck
+	// connections are closed by the snapshot and the reset
+	db.SetMaxIdleConns(0)
+
+	// migrations
+	if _, err = db.Exec("CREATE EXTENSION pgcrypto; CREATE TABLE example (name TEXT);"); err != nil {
+		t.Fatalf("migration error: %s", err.Error())
+	}
+	if err = pg.Snapshot(context.TODO()); err != nil {
+		t.Fatalf("snapshot failure: %s", err.Error())
+	}
+
+	if _, err = db.Exec("INSERT INTO example (name) VALUES ('anything')"); err != nil {
+		t.Fatalf("insert error: %s", err.Error())
+	}
+	s.Reset(context.TODO())
+	expectRows(t, db, 0)
+
+	t.Run("isolated", func(t *testing.T) {
+		other, err := sql.Open("postgres", pg.NewDatabase(context.TODO(), t))
+		if err != nil {
+			t.Fatalf("database connection error: %s", err.Error())
+		}
+		defer other.Close() // nolint: errcheck
+
+		if _, err = other.Exec("INSERT INTO example (name) VALUES (gen_random_uuid()::text)"); err != nil {
+			t.Fatalf("insert error: %s", err.Error())
+		}
+		expectRows(t, other, 1)
+		expectRows(t, db, 0)
+	})
+}
+
+func expectRows(t *testing.T, db *sql.DB, expected int) {
+	var rows int
+	if err := db.QueryRow("SELECT count(*) FROM example").Scan(&rows); err != nil {
+		t.Fatalf("query error: %s", err.Error())
+	}
+	if rows != expected {
+		t.Errorf("expected %d rows, got %d", expected, rows)
+	}
+}
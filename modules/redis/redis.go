@@ -0,0 +1,95 @@
+// Package redis provides a ready-made Redis container.
+package redis
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/modules/internal/hostaddr"
+)
+
+// DefaultImage is the image used if Opts.Image is not set.
+const DefaultImage = "redis:5-alpine"
+
+const port = nat.Port("6379/tcp")
+
+// Opts is an option struct for creating a Redis container.
+type Opts struct {
+	// default is "redis"
+	Name string
+	// default is DefaultImage
+	Image     string
+	ForcePull bool
+	Reuse     bool
+	// host port, default is a random port
+	Port string
+	// default is 30s
+	HealthCheckTimeout time.Duration
+	// lifecycle hooks, see testingdock.ContainerOpts, called after the ones of
+	// the module, e.g. PostHealthy to load fixtures
+	PostCreate, PostStart, PostHealthy testingdock.HookFunc
+	PreReset, PostReset, PreClose      testingdock.HookFunc
+}
+
+// Container is a Redis container. It is healthy once the server answers
+// to PING and reset by flushing all databases.
+type Container struct {
+	*testingdock.Container
+	addr *hostaddr.Addr
+}
+
+// New creates a Redis container configuration in the suite, which still has
+// to be added to the network.
+func New(s *testingdock.Suite, opts Opts) *Container {
+	if opts.Name == "" {
+		opts.Name = "redis"
+	}
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+
+	c := &Container{addr: hostaddr.New(port)}
+	c.Container = s.Container(testingdock.ContainerOpts{
+		Name:      opts.Name,
+		ForcePull: opts.ForcePull,
+		Reuse:     opts.Reuse,
+		Config: &container.Config{
+			Image:        opts.Image,
+			Cmd:          []string{"redis-server", "--save", "", "--appendonly", "no"},
+			ExposedPorts: nat.PortSet{port: {}},
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{port: {{HostPort: opts.Port}}},
+		},
+		HealthCheck:        testingdock.HealthCheckExec("redis-cli", "ping"),
+		HealthCheckTimeout: opts.HealthCheckTimeout,
+		Reset:              testingdock.ResetExec("redis-cli", "flushall"),
+		PostCreate:         opts.PostCreate,
+		PostStart:          testingdock.ChainHooks(c.addr.Record, opts.PostStart),
+		PostHealthy:        opts.PostHealthy,
+		PreReset:           opts.PreReset,
+		PostReset:          opts.PostReset,
+		PreClose:           opts.PreClose,
+	})
+
+	return c
+}
+
+// Port returns the port of the server on the docker host, once the container started.
+func (c *Container) Port() string {
+	return c.addr.Port()
+}
+
+// Addr returns the address of the server on the docker host, once the container started.
+func (c *Container) Addr() string {
+	return c.addr.String()
+}
+
+// ConnectionString returns the URL of the server on the docker host, once the
+// container started.
+func (c *Container) ConnectionString() string {
+	return "redis://" + c.Addr() + "/0"
+}
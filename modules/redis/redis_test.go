@@ -0,0 +1,80 @@
+package redis_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/client"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+	"github.com/m4ksio/testingdock/modules/redis"
+)
+
+func TestNew(t *testing.T) {
+	// the container is only configured, so the daemon is never contacted
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://docker.example:2376"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, "TestRedis_New", testingdock.SuiteOpts{Client: cli})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestRedis_New"})
+	n.After(redis.New(s, redis.Opts{}).Container)
+	n.After(redis.New(s, redis.Opts{Name: "cache", Image: "redis:6", Port: "16379"}).Container)
+
+	sp := s.Spec()
+	if len(sp.Containers) != 2 {
+		t.Fatalf("two containers expected, got %d", len(sp.Containers))
+	}
+	for i, expected := range []struct {
+		name, image, port string
+	}{
+		{"redis", redis.DefaultImage, "6379/tcp"},
+		{"cache", "redis:6", "16379:6379/tcp"},
+	} {
+		cs := sp.Containers[i]
+		if cs.Name != expected.name || cs.Image != expected.image {
+			t.Errorf("wrong container %s with image %s, expected %s with %s", cs.Name, cs.Image, expected.name, expected.image)
+		}
+		if !reflect.DeepEqual(cs.Ports, []string{expected.port}) {
+			t.Errorf("wrong ports of %s: %v", cs.Name, cs.Ports)
+		}
+		// nothing is persisted, so a flush resets everything
+		if !reflect.DeepEqual(cs.Command, []string{"redis-server", "--save", "", "--appendonly", "no"}) {
+			t.Errorf("wrong command of %s: %v", cs.Name, cs.Command)
+		}
+	}
+}
+
+func TestContainer_Reset(t *testing.T) {
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestRedis_Reset", testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestRedis_Reset"})
+
+	var called []string
+	hook := func(name string) testingdock.HookFunc {
+		return func(ctx context.Context, c *testingdock.Container) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+	r := redis.New(s, redis.Opts{PostStart: hook("post start"), PreReset: hook("pre reset"), PostReset: hook("post reset")})
+	n.After(r.Container)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	if r.Addr() != "localhost:"+r.Port() || r.Port() == "" {
+		t.Errorf("the address should be recorded on start, got %s", r.Addr())
+	}
+	s.Reset(context.TODO())
+
+	// health check, flush and the health check after the reset
+	expected := [][]string{{"redis-cli", "ping"}, {"redis-cli", "flushall"}, {"redis-cli", "ping"}}
+	if execs := engine.ByName("redis").Execs; !reflect.DeepEqual(execs, expected) {
+		t.Errorf("unexpected commands: %v", execs)
+	}
+	if !reflect.DeepEqual(called, []string{"post start", "pre reset", "post reset"}) {
+		t.Errorf("the hooks of the user should be called, got %v", called)
+	}
+}
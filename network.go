@@ -2,8 +2,9 @@ package testingdock
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"sync"
-	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -14,93 +15,156 @@ import (
 // NetworkOpts is used when creating a new network.
 type NetworkOpts struct {
 	Name string
+	// Reuse keeps the network after Close, so the next test run can adopt
+	// it together with its reused containers, see ContainerOpts.Reuse.
+	Reuse bool
 }
 
 // Network is a struct representing a docker network configuration.
 // This should usually not be created directly but via the NewNetwork
 // function or in the Suite.
 type Network struct {
-	t        testing.TB
-	cli      *client.Client // docker API object to talk to the docker daemon
+	t        Reporter
+	cli      client.APIClient // docker API object to talk to the docker daemon
 	id, name string
 	gateway  string
-	cancel   func()
+	subnet   string
 	children []*Container
-	closed   bool
 	labels   map[string]string
+	// mu guards cancel and closed, which are accessed by start and close
+	// from different goroutines
+	mu     sync.Mutex
+	cancel func(ctx context.Context) error
+	closed bool
+	// teardownTimeout bounds the cleanup done when the start fails half-way
+	teardownTimeout time.Duration
+	// dockerName is the name of the docker network, which differs from
+	// the logical name if the suite isolates names
+	dockerName string
+	reuse      bool
+	timings    timings
 }
 
 // Creates a new docker network configuration with the given options.
-func newNetwork(t testing.TB, c *client.Client, opts NetworkOpts) *Network {
+func newNetwork(t Reporter, c client.APIClient, opts NetworkOpts, teardownTimeout time.Duration) *Network {
+	labels := createTestingLabel()
+	if opts.Reuse {
+		labels = createReuseLabel()
+	}
+
 	return &Network{
-		t:      t,
-		cli:    c,
-		name:   opts.Name,
-		labels: createTestingLabel(),
+		t:               t,
+		cli:             c,
+		name:            opts.Name,
+		dockerName:      opts.Name,
+		labels:          labels,
+		teardownTimeout: teardownTimeout,
+		reuse:           opts.Reuse,
 	}
 }
 
 // Creates the actual docker network and also starts the containers that
 // are part of the network.
 func (n *Network) start(ctx context.Context) {
-	n.initialCleanup(ctx)
+	now := time.Now()
+	if !n.reuse || !n.adopt(ctx) {
+		n.initialCleanup(ctx)
+		n.create(ctx)
+	}
 
-	res, err := n.cli.NetworkCreate(ctx, n.name, types.NetworkCreate{
+	ni, err := n.cli.NetworkInspect(ctx, n.id, types.NetworkInspectOptions{
+		Verbose: false,
+	})
+	if err != nil {
+		tctx, cancel := context.WithTimeout(context.Background(), n.teardownTimeout)
+		n.cancel(tctx) // nolint: errcheck
+		cancel()
+		n.t.Fatalf("network inspect failure: %s", err.Error())
+	}
+	n.gateway, n.subnet = networkAddresses(ni)
+	printf("(setup ) %-25s (%s) - network got gateway ip: %s", n.name, n.id, n.gateway)
+	n.timings.record("network", now)
+	n.timings.markReady()
+
+	// start child containers
+	if !SpawnSequential {
+		printf("(setup ) %-25s (%s) - network is spawning %d child containers in parallel", n.name, n.id, len(n.children))
+	}
+	spawn(n.t, n.children, func(cont *Container) {
+		cont.start(ctx)
+	})
+}
+
+// create creates the docker network.
+func (n *Network) create(ctx context.Context) {
+	res, err := n.cli.NetworkCreate(ctx, n.dockerName, types.NetworkCreate{
 		Labels: n.labels,
 	})
 	if err != nil {
 		n.t.Fatalf("network creation failure: %s", err.Error())
 	}
 	n.id = res.ID
-	n.cancel = func() {
-		if n.closed {
-			return
+	n.setCancel()
+	printf("(setup ) %-25s (%s) - network created", n.name, n.id)
+}
+
+// setCancel sets the cancel function, which removes the network on close,
+// unless it is reused.
+func (n *Network) setCancel() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.reuse {
+		n.cancel = func(ctx context.Context) error {
+			printf("(cancel) %-25s (%s) - network kept for reuse", n.name, n.id)
+			return nil
 		}
-		if err := n.cli.NetworkRemove(ctx, n.id); err != nil {
-			n.t.Fatalf("network removal failure: %s", err.Error())
+		return
+	}
+
+	// the teardown context is passed in by close, so that a cancelled or
+	// expired start context does not prevent the network from being removed
+	n.cancel = func(ctx context.Context) error {
+		if err := n.cli.NetworkRemove(ctx, n.id); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("network removal failure of %s: %s", n.name, err.Error())
 		}
 		printf("(cancel) %-25s (%s) - network removed", n.name, n.id)
+		return nil
 	}
-	printf("(setup ) %-25s (%s) - network created", n.name, n.id)
+}
 
-	ni, err := n.cli.NetworkInspect(ctx, n.id, types.NetworkInspectOptions{
-		Verbose: false,
-	})
+// adopt makes an existing reused or shared network the docker network of
+// this configuration. Returns false if there is no such network.
+func (n *Network) adopt(ctx context.Context) bool {
+	networks, err := findNetworkByName(ctx, n.cli, n.dockerName)
 	if err != nil {
-		n.cancel()
-		n.t.Fatalf("network inspect failure: %s", err.Error())
+		n.t.Fatalf("network listing failure: %s", err.Error())
 	}
-	n.gateway = ni.IPAM.Config[0].Gateway
-	printf("(setup ) %-25s (%s) - network got gateway ip: %s", n.name, n.id, n.gateway)
-
-	// start child containers
-	if SpawnSequential {
-		for _, cont := range n.children {
-			cont.start(ctx)
+	for _, nn := range networks {
+		if isOwnedByTestingdock(nn.Labels) && nn.Labels[labelReuse] == n.labels[labelReuse] && nn.Labels[labelShared] == n.labels[labelShared] {
+			n.id = nn.ID
+			n.setCancel()
+			printf("(setup ) %-25s (%s) - network reused", n.name, n.id)
+			return true
 		}
-	} else {
-		printf("(setup ) %-25s (%s) - network is spawning %d child containers in parallel", n.name, n.id, len(n.children))
+	}
 
-		var wg sync.WaitGroup
+	return false
+}
 
-		wg.Add(len(n.children))
-		for _, cont := range n.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.start(ctx)
-			}(cont)
-		}
-		wg.Wait()
-	}
+// Find networks by the given name. The name filter of docker matches substrings,
+// so the name is anchored.
+func findNetworkByName(ctx context.Context, cli client.APIClient, name string) ([]types.NetworkResource, error) {
+	networkListArgs := filters.NewArgs()
+	networkListArgs.Add("name", "^"+regexp.QuoteMeta(name)+"$")
+
+	return cli.NetworkList(ctx, types.NetworkListOptions{Filters: networkListArgs})
 }
 
 // removes the network if it already exists and all containers being part
 // of that network
 func (n *Network) initialCleanup(ctx context.Context) {
-	networkListArgs := filters.NewArgs()
-	networkListArgs.Add("name", n.name)
-
-	networks, err := n.cli.NetworkList(ctx, types.NetworkListOptions{Filters: networkListArgs})
+	networks, err := findNetworkByName(ctx, n.cli, n.dockerName)
 	if err != nil {
 		n.t.Fatalf("network listing failure: %s", err.Error())
 	}
@@ -133,39 +197,34 @@ func (n *Network) initialCleanup(ctx context.Context) {
 			}
 			printf("(setup ) %-25s (%s) - network removed", nn.Name, nn.ID)
 		} else {
-			n.t.Fatalf("network with name %s already exists, but wasn't started by tesingdock, aborting!", n.name)
+			n.t.Fatalf("network with name %s already exists, but wasn't started by tesingdock, aborting!", n.dockerName)
 		}
 	}
 }
 
-// Closes the docker network. This also closes the
-// children containers if any are set in the Network struct.
-// Implements io.Closer interface.
-func (n *Network) close() error {
-	if SpawnSequential {
-		for _, cont := range n.children {
-			cont.close() // nolint: errcheck
-		}
-	} else {
-		var wg sync.WaitGroup
-
-		wg.Add(len(n.children))
-		for _, cont := range n.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.close() // nolint: errcheck
-			}(cont)
-		}
-		wg.Wait()
+// Closes the docker network using the given teardown context. This also
+// closes the children containers if any are set in the Network struct.
+// Closing a network more than once is a no-op.
+func (n *Network) close(ctx context.Context) error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
 	}
+	n.closed = true
+	cancel := n.cancel
+	n.mu.Unlock()
 
-	// if the network failed to start n.cancel will not be set
-	if n.cancel != nil {
-		n.cancel()
+	// the network can only be removed once its containers are gone, a failing
+	// hook of a container does not prevent that though
+	errs := []error{closeAll(ctx, n.children)}
+
+	// if the network failed to start cancel will not be set
+	if cancel != nil {
+		errs = append(errs, cancel(ctx))
 	}
 
-	n.closed = true
-	return nil
+	return joinErrors(errs)
 }
 
 // After adds a child container to the current network configuration.
@@ -184,3 +243,25 @@ func (n *Network) reset(ctx context.Context) {
 	}
 	printf("(reset ) %-25s (%s) - network reseted in %s", n.name, n.id, time.Since(now))
 }
+
+// ID returns the ID of the docker network, once it started.
+func (n *Network) ID() string {
+	return n.id
+}
+
+// Gateway returns the IP address of the gateway of the docker network, once
+// it started. The docker host is reachable from the containers under it.
+func (n *Network) Gateway() string {
+	return n.gateway
+}
+
+// Subnet returns the subnet of the docker network in CIDR notation, once it started.
+func (n *Network) Subnet() string {
+	return n.subnet
+}
+
+// DockerName returns the name of the docker network. It equals the name given
+// in NetworkOpts, unless the suite isolates names.
+func (n *Network) DockerName() string {
+	return n.dockerName
+}
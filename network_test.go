@@ -2,9 +2,16 @@ package testingdock_test
 
 import (
 	"context"
+	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
 
 	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
 )
 
 func TestNetwork_Start(t *testing.T) {
@@ -21,3 +28,193 @@ func TestNetwork_Start(t *testing.T) {
 		t.Fatalf("Failed to close a network: %s", err.Error())
 	}
 }
+
+func TestSuite_ExposeHost(t *testing.T) {
+	name := "TestSuite_ExposeHost"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{Name: "app", Config: &container.Config{Image: "fake"}}))
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:       "vm",
+		Config:     &container.Config{Image: "fake"},
+		HostConfig: &container.HostConfig{ExtraHosts: []string{"host.docker.internal:192.168.65.2"}},
+	}))
+	s.ExposeHost("webhook", 80, &net.TCPAddr{IP: net.IPv4zero, Port: 54321})
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	if addr := n.HostAddr(&net.TCPAddr{IP: net.IPv4zero, Port: 8080}); addr != "host.docker.internal:8080" {
+		t.Errorf("unexpected host address: %s", addr)
+	}
+	app := engine.ByName("app")
+	if expected := []string{"host.docker.internal:" + n.Gateway()}; !reflect.DeepEqual(app.HostConfig.ExtraHosts, expected) {
+		t.Errorf("unexpected extra hosts: %v", app.HostConfig.ExtraHosts)
+	}
+	// existing mappings are kept
+	if hosts := engine.ByName("vm").HostConfig.ExtraHosts; !reflect.DeepEqual(hosts, []string{"host.docker.internal:192.168.65.2"}) {
+		t.Errorf("unexpected extra hosts: %v", hosts)
+	}
+	forward := engine.ByName("webhook")
+	if expected := []string{"TCP-LISTEN:80,fork,reuseaddr", "TCP:host.docker.internal:54321"}; !reflect.DeepEqual([]string(forward.Config.Cmd), expected) {
+		t.Errorf("unexpected forwarding command: %v", forward.Config.Cmd)
+	}
+}
+
+func TestSuite_ExposeHostStarted(t *testing.T) {
+	name := "TestSuite_ExposeHostStarted"
+	engine := fake.NewEngine()
+
+	// the sidecar would never be started
+	msg := expectFatal(t, func(tb testing.TB) {
+		s, _ := testingdock.GetOrCreateSuite(tb, name, testingdock.SuiteOpts{Client: engine})
+		n := s.Network(testingdock.NetworkOpts{Name: name})
+		n.After(s.Container(testingdock.ContainerOpts{Name: "app", Config: &container.Config{Image: "fake"}}))
+		s.Start(context.TODO())
+		s.ExposeHost("webhook", 80, &net.TCPAddr{IP: net.IPv4zero, Port: 54321})
+	})
+	if !strings.Contains(msg, "before the suite is started") {
+		t.Errorf("unexpected failure: %s", msg)
+	}
+}
+
+func TestSuite_HostInternalEngines(t *testing.T) {
+	desktop := fake.NewEngine()
+	desktop.Desktop = true
+	podman := fake.NewEngine()
+	podman.Podman = true
+
+	// Docker Desktop resolves the host itself, and the gateway of podman is not the host
+	for name, engine := range map[string]*fake.Engine{"desktop": desktop, "podman": podman} {
+		suite := "TestSuite_HostInternalEngines_" + name
+		s, _ := testingdock.GetOrCreateSuite(t, suite, testingdock.SuiteOpts{Client: engine})
+		n := s.Network(testingdock.NetworkOpts{Name: suite})
+		n.After(s.Container(testingdock.ContainerOpts{Name: "app", Config: &container.Config{Image: "fake"}}))
+		s.Start(context.TODO())
+
+		if hosts := engine.ByName("app").HostConfig.ExtraHosts; len(hosts) != 0 {
+			t.Errorf("%s: no extra hosts expected, got %v", name, hosts)
+		}
+		if e := s.Engine(); e.Desktop != (name == "desktop") {
+			t.Errorf("%s: wrong engine detected: %+v", name, e)
+		}
+		// podman resolves its own name for the host
+		expected := map[string]string{"desktop": "host.docker.internal:8080", "podman": "host.containers.internal:8080"}[name]
+		if addr := n.HostAddr(&net.TCPAddr{IP: net.IPv4zero, Port: 8080}); addr != expected {
+			t.Errorf("%s: unexpected host address: %s", name, addr)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNetwork_Faults(t *testing.T) {
+	name := "TestNetwork_Faults"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	a := s.Container(testingdock.ContainerOpts{Name: "a", Config: &container.Config{Image: "fake"}})
+	b := s.Container(testingdock.ContainerOpts{Name: "b", Config: &container.Config{Image: "fake"}})
+	n.After(a)
+	n.After(b)
+	s.Start(context.TODO())
+
+	ctx := context.TODO()
+	if err := a.AddLatency(ctx, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.PacketLoss(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := engine.Sidecar(a.ID)
+	if sidecar == nil {
+		t.Fatal("fault sidecar expected")
+	}
+	if expected := []string{"tc", "qdisc", "replace", "dev", "eth0", "root", "netem", "delay", "100000us", "loss", "5%"}; !reflect.DeepEqual(sidecar.Execs[1], expected) {
+		t.Errorf("unexpected netem command: %v", sidecar.Execs[1])
+	}
+
+	if err := n.Partition(ctx, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Disconnect(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if engine.ByName("b").Network != "" {
+		t.Error("container should be disconnected")
+	}
+
+	if err := n.Heal(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if engine.ByName("b").Network != n.DockerName() {
+		t.Error("container should be reconnected")
+	}
+	if engine.Sidecar(a.ID) != nil || engine.Sidecar(b.ID) != nil {
+		t.Error("fault sidecars should be removed")
+	}
+	if last := sidecar.Execs[len(sidecar.Execs)-1]; !strings.Contains(last[2], "iptables -D") {
+		t.Errorf("partition should be reverted, got: %v", last)
+	}
+
+	// faults are removed on close, too
+	if err := b.AddLatency(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if containers, _ := engine.Counts(); containers != 0 {
+		t.Errorf("expected all containers to be removed, got %d", containers)
+	}
+}
+
+func TestNetwork_PartitionRollback(t *testing.T) {
+	name := "TestNetwork_PartitionRollback"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	a := s.Container(testingdock.ContainerOpts{Name: "a", Config: &container.Config{Image: "fake"}})
+	b := s.Container(testingdock.ContainerOpts{Name: "b", Config: &container.Config{Image: "fake"}})
+	n.After(a)
+	n.After(b)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	ctx := context.TODO()
+	ipA, err := a.IP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipB, err := b.IP(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b fails to block a, after a blocked b
+	engine.FailExec = func(cmd []string) bool {
+		return strings.Contains(strings.Join(cmd, " "), "iptables -A INPUT -s "+ipA+" ")
+	}
+	if err = n.Partition(ctx, a, b); err == nil || !strings.Contains(err.Error(), "exec failure") {
+		t.Fatalf("the block failure should be returned, got %v", err)
+	}
+	sidecar := engine.Sidecar(a.ID)
+	if sidecar == nil {
+		t.Fatal("fault sidecar expected")
+	}
+	if last := sidecar.Execs[len(sidecar.Execs)-1]; !strings.Contains(last[2], "iptables -D INPUT -s "+ipB+" ") {
+		t.Errorf("the half partition should be rolled back, got: %v", last)
+	}
+
+	// the rolled back rule is not deleted again
+	engine.FailExec = nil
+	execs := len(sidecar.Execs)
+	if err = n.Heal(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range sidecar.Execs[execs:] {
+		if strings.Contains(strings.Join(cmd, " "), "iptables -D") {
+			t.Errorf("unexpected rule deletion: %v", cmd)
+		}
+	}
+}
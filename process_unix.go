@@ -0,0 +1,12 @@
+// +build !windows
+
+package testingdock
+
+import "syscall"
+
+// processAlive checks whether a process with the given PID exists on this host.
+// A process owned by another user can't be signalled, but exists nonetheless.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,14 @@
+package testingdock
+
+import "os"
+
+// processAlive checks whether a process with the given PID exists on this host.
+// Finding a process fails on windows, unless it exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release() // nolint: errcheck
+	return true
+}
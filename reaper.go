@@ -0,0 +1,286 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// labelReaper marks the reaper sidecar container of a session. The reaper
+// does not carry the session label itself, as it would remove itself otherwise.
+const labelReaper = "testingdock.reaper"
+
+// Reaper controls whether a reaper sidecar container is started together with
+// the first suite. The reaper removes all containers, networks and volumes of
+// the current session as soon as the test binary exits, even if it crashed or
+// got killed, so that no teardown was run. It needs an engine reached via a
+// unix socket, which is mounted into the reaper, starting fails otherwise.
+var Reaper bool
+
+// ReaperImage is the image used for the reaper sidecar container. It has to
+// provide a shell, nc and the docker cli.
+var ReaperImage = "docker:19.03"
+
+var (
+	// reaperMu guards reaperConn
+	reaperMu sync.Mutex
+	// reaperConn is kept open for the lifetime of the test binary, the reaper
+	// starts removing resources once it gets closed. It is nil until the
+	// reaper started.
+	reaperConn net.Conn
+)
+
+// Prune removes all containers, networks and volumes with the label "owner=testingdock",
+// which belong to dead sessions. A session on this host is dead once the process of
+// the test binary exited, regardless of olderThan, as the process tells for sure
+// whether the session still runs: the age would remove the resources of long running
+// tests and keep the ones of crashed tests. A session on another host, whose process
+// can't be checked, is considered dead if it was started more than olderThan ago. Resources without
+// session labels, e.g. created by older versions of testingdock, are aged by their
+// creation time. Reused resources are never pruned, shared suites only once no
+// session which isn't dead references them anymore.
+func Prune(ctx context.Context, olderThan time.Duration) error {
+	cli, err := NewClient()
+	if err != nil {
+		return fmt.Errorf("docker client instantiation failure: %s", err.Error())
+	}
+	defer cli.Close() // nolint: errcheck
+
+	return PruneClient(ctx, cli, olderThan)
+}
+
+// PruneClient is like Prune, but uses the given docker client.
+func PruneClient(ctx context.Context, cli client.APIClient, olderThan time.Duration) error {
+	deadline := time.Now().Add(-olderThan)
+	ownerArgs := filters.NewArgs(filters.Arg("label", "owner=testingdock"))
+
+	inUse, err := sharedInUse(ctx, cli, deadline)
+	if err != nil {
+		return err
+	}
+	isDead := func(labels map[string]string, created time.Time) bool {
+		if suite, ok := labels[labelShared]; ok {
+			return !inUse[suite] && created.Before(deadline)
+		}
+		return isDeadSession(labels, created, deadline)
+	}
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: ownerArgs})
+	if err != nil {
+		return fmt.Errorf("container listing failure: %s", err.Error())
+	}
+	for _, cc := range containers {
+		if !isDead(cc.Labels, time.Unix(cc.Created, 0)) {
+			continue
+		}
+		if err = cli.ContainerRemove(ctx, cc.ID, types.ContainerRemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("container removal failure: %s", err.Error())
+		}
+		printf("(prune ) %-25s (%s) - container removed", cc.Names[0], cc.ID)
+	}
+
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{Filters: ownerArgs})
+	if err != nil {
+		return fmt.Errorf("network listing failure: %s", err.Error())
+	}
+	for _, nn := range networks {
+		if !isDead(nn.Labels, nn.Created) {
+			continue
+		}
+		if err = cli.NetworkRemove(ctx, nn.ID); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("network removal failure: %s", err.Error())
+		}
+		printf("(prune ) %-25s (%s) - network removed", nn.Name, nn.ID)
+	}
+
+	volumes, err := cli.VolumeList(ctx, ownerArgs)
+	if err != nil {
+		return fmt.Errorf("volume listing failure: %s", err.Error())
+	}
+	for _, v := range volumes.Volumes {
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt) // nolint: errcheck
+		if !isDead(v.Labels, created) {
+			continue
+		}
+		if err = cli.VolumeRemove(ctx, v.Name, true); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("volume removal failure: %s", err.Error())
+		}
+		printf("(prune ) %-25s (%-64s) - volume removed", v.Name, "")
+	}
+
+	return nil
+}
+
+// isDeadSession checks whether the resource with the given labels belongs to a
+// session other than the current one, which is dead. Sessions on this host are
+// dead once their process exited, no matter how long they have been running.
+// The processes of sessions on other hosts can't be checked, so these are
+// considered dead if they started before the deadline.
+func isDeadSession(labels map[string]string, created, deadline time.Time) bool {
+	if labels[labelReuse] == "true" {
+		return false
+	}
+
+	id, ok := labels[labelSession]
+	if !ok {
+		id = labels[labelReaper]
+	}
+	if id == session.id {
+		return false
+	}
+
+	if host := labels[labelHost]; host != "" && host == session.host {
+		if pid, err := strconv.Atoi(labels[labelPID]); err == nil {
+			return !processAlive(pid)
+		}
+	}
+
+	started := created
+	if unix, err := strconv.ParseInt(labels[labelStarted], 10, 64); err == nil {
+		started = time.Unix(unix, 0)
+	}
+
+	return started.Before(deadline)
+}
+
+// startReaper starts the reaper sidecar container of the current session,
+// if it wasn't started yet. A failed start is tried again by the next suite.
+func startReaper(ctx context.Context, t Reporter, cli client.APIClient) {
+	reaperMu.Lock()
+	defer reaperMu.Unlock()
+
+	if reaperConn != nil {
+		return
+	}
+	conn, err := runReaper(ctx, cli)
+	if err != nil {
+		t.Fatalf("reaper start failure: %s", err.Error())
+	}
+	reaperConn = conn
+}
+
+// reaperScript waits until the connection of the test binary is closed and
+// then removes all resources of the session.
+const reaperScript = `nc -l -p 8080 > /dev/null
+docker ps -aq --filter label=%[1]s=%[2]s | xargs -r docker rm -f -v
+docker network ls -q --filter label=%[1]s=%[2]s | xargs -r docker network rm
+docker volume ls -q --filter label=%[1]s=%[2]s | xargs -r docker volume rm -f`
+
+// runReaper starts the reaper and returns the connection to it. The reaper is
+// removed again, if it can't be connected to.
+func runReaper(ctx context.Context, cli client.APIClient) (net.Conn, error) {
+	// the reaper talks to the engine via its socket, a remote engine can't be
+	// reached via the default socket of its host, which may belong to another one
+	socket, err := daemonSocket(cli)
+	if err != nil {
+		return nil, err
+	}
+	if err = pullMissing(ctx, cli, ReaperImage); err != nil {
+		return nil, err
+	}
+
+	// the reaper carries the session in labelReaper instead of labelSession
+	labels := session.labels()
+	delete(labels, labelSession)
+	labels["owner"] = "testingdock"
+	labels[labelReaper] = session.id
+
+	port := nat.Port("8080/tcp")
+	cont, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:        ReaperImage,
+		Cmd:          []string{"sh", "-c", fmt.Sprintf(reaperScript, labelSession, session.id)},
+		ExposedPorts: nat.PortSet{port: struct{}{}},
+		Labels:       labels,
+	}, &container.HostConfig{
+		AutoRemove:   true,
+		Binds:        []string{socket + ":/var/run/docker.sock"},
+		PortBindings: nat.PortMap{port: []nat.PortBinding{{}}},
+	}, nil, "testingdock_reaper_"+session.id)
+	if err != nil {
+		return nil, fmt.Errorf("container creation failure: %s", err.Error())
+	}
+
+	conn, err := connectReaper(ctx, cli, cont.ID, port)
+	if err != nil {
+		// nobody would ever connect to the reaper, so it would wait forever
+		rctx, cancel := context.WithTimeout(context.Background(), DefaultTeardownTimeout)
+		defer cancel()
+		if rerr := cli.ContainerRemove(rctx, cont.ID, types.ContainerRemoveOptions{Force: true}); rerr != nil && !client.IsErrNotFound(rerr) {
+			return nil, fmt.Errorf("%s, reaper removal failure: %s", err.Error(), rerr.Error())
+		}
+		return nil, err
+	}
+	printf("(reaper) %-25s (%s) - reaper started for session %s", ReaperImage, cont.ID, session.id)
+	return conn, nil
+}
+
+// connectReaper starts the created reaper and connects to it on the given port.
+func connectReaper(ctx context.Context, cli client.APIClient, id string, port nat.Port) (net.Conn, error) {
+	if err := cli.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+		return nil, fmt.Errorf("container start failure: %s", err.Error())
+	}
+
+	cjson, err := cli.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("container inspect failure: %s", err.Error())
+	}
+	bindings := cjson.NetworkSettings.Ports[port]
+	if len(bindings) == 0 {
+		return nil, fmt.Errorf("reaper port %s is not published", port)
+	}
+	addr := net.JoinHostPort(daemonHostname(cli), bindings[0].HostPort)
+
+	// the docker proxy accepts connections before nc is listening and closes them
+	// right away, so a connection is only considered established if it stays open
+	for i := 0; i < 30; i++ {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			if established(conn) {
+				return conn, nil
+			}
+			conn.Close() // nolint: errcheck
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not connect to the reaper at %s: %s", addr, ctx.Err())
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	return nil, fmt.Errorf("could not connect to the reaper at %s", addr)
+}
+
+// established checks whether the connection stays open for a second.
+func established(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		return false
+	}
+	// the read times out as long as the connection is open
+	_, err := conn.Read(make([]byte, 1))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// daemonHostname returns the host under which ports published by the docker
+// daemon are reachable.
+func daemonHostname(cli client.APIClient) string {
+	u, err := url.Parse(cli.DaemonHost())
+	if err != nil || u.Scheme != "tcp" {
+		return "localhost"
+	}
+	return u.Hostname()
+}
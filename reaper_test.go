@@ -0,0 +1,139 @@
+package testingdock_test
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestPruneClient(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no process has this PID, as it is above the maximum of all platforms
+	deadPID := 1 << 30
+	session := func(id, host string, pid int, started time.Time) map[string]string {
+		return map[string]string{
+			"owner":               "testingdock",
+			"testingdock.session": id,
+			"testingdock.pid":     strconv.Itoa(pid),
+			"testingdock.host":    host,
+			"testingdock.started": strconv.FormatInt(started.Unix(), 10),
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	resources := map[string]map[string]string{
+		// the process exited, even though the session is recent
+		"dead": session("dead", host, deadPID, time.Now()),
+		// the process is still running, even though the session is old
+		"live": session("live", host, os.Getpid(), old),
+		// the processes of other hosts can't be checked, so they are aged
+		"remote-old":    session("remote-old", "ci-runner", deadPID, old),
+		"remote-recent": session("remote-recent", "ci-runner", deadPID, time.Now()),
+		"reused":        {"owner": "testingdock", "testingdock.reuse": "true"},
+	}
+
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	for name, labels := range resources {
+		if _, err = engine.ContainerCreate(ctx, &container.Config{Image: "fake", Labels: labels}, &container.HostConfig{}, nil, name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = engine.NetworkCreate(ctx, name, types.NetworkCreate{Labels: labels}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = engine.VolumeCreate(ctx, volume.VolumeCreateBody{Name: name, Labels: labels}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = testingdock.PruneClient(ctx, engine, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"live", "remote-recent", "reused"}
+	var containers, networks, volumes []string
+	cl, _ := engine.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filters.NewArgs()}) // nolint: errcheck
+	for _, c := range cl {
+		containers = append(containers, c.Names[0][1:])
+	}
+	nl, _ := engine.NetworkList(ctx, types.NetworkListOptions{Filters: filters.NewArgs()}) // nolint: errcheck
+	for _, n := range nl {
+		networks = append(networks, n.Name)
+	}
+	vl, _ := engine.VolumeList(ctx, filters.NewArgs()) // nolint: errcheck
+	for _, v := range vl.Volumes {
+		volumes = append(volumes, v.Name)
+	}
+	for kind, names := range map[string][]string{"containers": containers, "networks": networks, "volumes": volumes} {
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("unexpected %s left: %v, expected %v", kind, names, expected)
+		}
+	}
+}
+
+// remoteEngine is an engine reached via tcp.
+type remoteEngine struct {
+	*fake.Engine
+}
+
+func (remoteEngine) DaemonHost() string {
+	return "tcp://docker.example:2375"
+}
+
+func TestSuite_ReaperFailure(t *testing.T) {
+	testingdock.Reaper = true
+	defer func() { testingdock.Reaper = false }()
+
+	// nothing listens on the ports published by the fake engine
+	engine := fake.NewEngine()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msg := expectFatal(t, func(tb testing.TB) {
+		s, _ := testingdock.GetOrCreateSuite(tb, "TestSuite_ReaperFailure", testingdock.SuiteOpts{Client: engine})
+		s.Start(ctx)
+	})
+	if !strings.Contains(msg, "could not connect to the reaper") {
+		t.Errorf("unexpected failure: %s", msg)
+	}
+	if names := engine.ContainerNames(); len(names) != 0 {
+		t.Errorf("the reaper should be removed, got containers %v", names)
+	}
+
+	// the next suite tries again
+	msg = expectFatal(t, func(tb testing.TB) {
+		s, _ := testingdock.GetOrCreateSuite(tb, "TestSuite_ReaperFailure_2", testingdock.SuiteOpts{Client: remoteEngine{fake.NewEngine()}})
+		s.Start(context.TODO())
+	})
+	if !strings.Contains(msg, "tcp://docker.example:2375") {
+		t.Errorf("the reaper start should be tried again, got %s", msg)
+	}
+}
+
+func TestSuite_ReaperRemoteEngine(t *testing.T) {
+	testingdock.Reaper = true
+	defer func() { testingdock.Reaper = false }()
+
+	// the socket of the host running the tests would belong to another engine
+	msg := expectFatal(t, func(tb testing.TB) {
+		s, _ := testingdock.GetOrCreateSuite(tb, "TestSuite_ReaperRemoteEngine", testingdock.SuiteOpts{Client: remoteEngine{fake.NewEngine()}})
+		s.Start(context.TODO())
+	})
+	if !strings.Contains(msg, "reaper start failure") || !strings.Contains(msg, "tcp://docker.example:2375") {
+		t.Errorf("unexpected failure: %s", msg)
+	}
+}
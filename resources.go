@@ -0,0 +1,108 @@
+package testingdock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// MaxParallel limits how many containers of a suite may be starting and health
+// checking at once, if SuiteOpts.MaxParallel is not set. Zero is unlimited.
+var MaxParallel int
+
+// Resources limits the resources of a container. Zero values are not limited,
+// unless the suite defines defaults, see SuiteOpts.Resources.
+type Resources struct {
+	// number of CPUs, e.g. 0.5
+	CPUs float64 `yaml:"cpus,omitempty" json:"cpus,omitempty"`
+	// memory limit in bytes
+	Memory int64 `yaml:"memory,omitempty" json:"memory,omitempty"`
+	// maximum number of processes
+	Pids int64 `yaml:"pids,omitempty" json:"pids,omitempty"`
+}
+
+// Resource profiles for SuiteOpts.Resources and ContainerOpts.Resources.
+var (
+	ResourcesSmall  = Resources{CPUs: 0.5, Memory: 256 << 20, Pids: 256}
+	ResourcesMedium = Resources{CPUs: 1, Memory: 1 << 30, Pids: 1024}
+	ResourcesLarge  = Resources{CPUs: 2, Memory: 4 << 30, Pids: 4096}
+)
+
+// withDefaults returns the resources with the limits not set taken from the given
+// defaults, unless the host config sets them already.
+func (r *Resources) withDefaults(d *Resources, hcfg *container.HostConfig) *Resources {
+	if d == nil {
+		return r
+	}
+	if hcfg == nil {
+		hcfg = &container.HostConfig{}
+	}
+
+	res := Resources{}
+	if r != nil {
+		res = *r
+	}
+	if res.CPUs == 0 && hcfg.NanoCPUs == 0 && hcfg.CPUQuota == 0 {
+		res.CPUs = d.CPUs
+	}
+	if res.Memory == 0 && hcfg.Memory == 0 {
+		res.Memory = d.Memory
+	}
+	if res.Pids == 0 && (hcfg.PidsLimit == nil || *hcfg.PidsLimit == 0) {
+		res.Pids = d.Pids
+	}
+	return &res
+}
+
+// apply sets the limits in the host config.
+func (r *Resources) apply(hcfg *container.HostConfig) {
+	if r.CPUs > 0 {
+		// docker refuses containers limited by both
+		hcfg.NanoCPUs = int64(r.CPUs * 1e9)
+		hcfg.CPUQuota, hcfg.CPUPeriod = 0, 0
+	}
+	if r.Memory > 0 {
+		hcfg.Memory = r.Memory
+	}
+	if r.Pids > 0 {
+		pids := r.Pids
+		hcfg.PidsLimit = &pids
+	}
+}
+
+// clearLimits removes the CPU, memory and pids limits from the host config, for
+// engines which cannot apply them. Returns whether any limit was set.
+func clearLimits(hcfg *container.HostConfig) bool {
+	r := &hcfg.Resources
+	set := r.NanoCPUs != 0 || r.CPUQuota != 0 || r.CPUPeriod != 0 || r.CPUShares != 0 ||
+		r.Memory != 0 || r.MemoryReservation != 0 || r.MemorySwap != 0 || r.PidsLimit != nil
+	r.NanoCPUs, r.CPUQuota, r.CPUPeriod, r.CPUShares = 0, 0, 0, 0
+	r.Memory, r.MemoryReservation, r.MemorySwap, r.PidsLimit = 0, 0, 0, nil
+	return set
+}
+
+// acquire blocks until less than the maximum number of containers of the suite
+// are starting. The returned function has to be called once the container started,
+// and also deferred, so that the slot is freed when the start fails. Calling it more
+// than once is a no-op.
+func (c *Container) acquire(ctx context.Context) func() {
+	if c.sem == nil {
+		return func() {}
+	}
+
+	now := time.Now()
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		c.t.Fatalf("container %s could not start in time: %s", c.Name, ctx.Err())
+	}
+	c.timings.record("queue", now)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-c.sem
+		})
+	}
+}
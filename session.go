@@ -0,0 +1,65 @@
+package testingdock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"time"
+)
+
+// labels set on every resource created by testingdock, next to "owner=testingdock",
+// which identify the test binary run (session) that created the resource.
+const (
+	labelSession = "testingdock.session"
+	labelPID     = "testingdock.pid"
+	labelHost    = "testingdock.host"
+	labelStarted = "testingdock.started"
+)
+
+// session identifies the current run of the test binary.
+var session = newSession()
+
+type sessionInfo struct {
+	id  string
+	pid int
+	// hostname of the machine running the test binary, the PID is only
+	// meaningful there
+	host    string
+	started time.Time
+}
+
+func newSession() sessionInfo {
+	s := sessionInfo{
+		pid:     os.Getpid(),
+		started: time.Now(),
+	}
+	s.host, _ = os.Hostname() // nolint: errcheck
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to something that is unique enough on a single host
+		s.id = strconv.FormatInt(s.started.UnixNano(), 16)
+	} else {
+		s.id = hex.EncodeToString(b)
+	}
+
+	return s
+}
+
+// labels returns the labels identifying the session, which are set on every
+// resource created by it.
+func (s sessionInfo) labels() map[string]string {
+	return map[string]string{
+		labelSession: s.id,
+		labelPID:     strconv.Itoa(s.pid),
+		labelHost:    s.host,
+		labelStarted: strconv.FormatInt(s.started.Unix(), 10),
+	}
+}
+
+// SessionID returns the ID of the current run of the test binary. All resources
+// created by testingdock are labelled with it.
+func SessionID() string {
+	return session.id
+}
@@ -0,0 +1,237 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// labels of shared suites. Containers and networks of a shared suite carry
+// labelShared, while every test binary using the suite holds a reference in
+// form of a volume labelled with labelRef and its session, including the PID
+// of the process, so that references of crashed processes can be ignored.
+const (
+	labelShared = "testingdock.shared"
+	labelRef    = "testingdock.ref"
+)
+
+// Create a map of labels for the containers and networks of a shared suite.
+// These don't belong to any session, they are removed by the last user of
+// the suite or by Prune, once no session references the suite anymore.
+func createSharedLabel(suite string) map[string]string {
+	labels := make(map[string]string)
+	labels["owner"] = "testingdock"
+	labels[labelShared] = suite
+	return labels
+}
+
+// lockShared acquires the host-wide lock of the shared suite, which
+// serializes starting, attaching to and closing the suite between processes.
+func (s *Suite) lockShared() (func(), error) {
+	path := filepath.Join(os.TempDir(), "testingdock_"+invalidNameChars.ReplaceAllString(s.name, "_")+".lock")
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("shared suite lock failure: %s", err.Error())
+	}
+	return unlock, nil
+}
+
+// refName returns the name of the reference volume of the current session.
+func (s *Suite) refName() string {
+	return "td_ref_" + invalidNameChars.ReplaceAllString(s.name, "_") + "_" + session.id
+}
+
+// addRef adds a reference of the current session to the shared suite.
+func (s *Suite) addRef(ctx context.Context) {
+	labels := createTestingLabel()
+	labels[labelRef] = s.name
+
+	if _, err := s.cli.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name:   s.refName(),
+		Labels: labels,
+	}); err != nil {
+		s.t.Fatalf("shared suite reference failure: %s", err.Error())
+	}
+}
+
+// removeRef removes the reference of the current session to the shared suite
+// and returns the number of references of live sessions left.
+func (s *Suite) removeRef(ctx context.Context) (int, error) {
+	if err := s.cli.VolumeRemove(ctx, s.refName(), true); err != nil && !client.IsErrNotFound(err) {
+		return 0, fmt.Errorf("shared suite reference removal failure: %s", err.Error())
+	}
+
+	return s.liveRefs(ctx)
+}
+
+// liveRefs returns the number of references to the shared suite held by live
+// sessions. References of dead sessions, e.g. crashed processes, are removed.
+// Sessions on other hosts are considered alive, as they can't be checked.
+func (s *Suite) liveRefs(ctx context.Context) (int, error) {
+	refs, err := s.cli.VolumeList(ctx, filters.NewArgs(filters.Arg("label", labelRef+"="+s.name)))
+	if err != nil {
+		return 0, fmt.Errorf("volume listing failure: %s", err.Error())
+	}
+
+	live := 0
+	for _, v := range refs.Volumes {
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt) // nolint: errcheck
+		if !isDeadSession(v.Labels, created, time.Time{}) {
+			live++
+			continue
+		}
+		if err = s.cli.VolumeRemove(ctx, v.Name, true); err != nil && !client.IsErrNotFound(err) {
+			return 0, fmt.Errorf("shared suite reference removal failure: %s", err.Error())
+		}
+		printf("(setup ) %-25s (%-64s) - reference of dead session removed: %s", s.name, "", v.Name)
+	}
+	return live, nil
+}
+
+// startShared starts the shared suite, or attaches to it if another
+// process already started it.
+func (s *Suite) startShared(ctx context.Context) {
+	unlock, err := s.lockShared()
+	if err != nil {
+		s.t.Fatalf("%s", err.Error())
+	}
+	defer unlock()
+
+	s.addRef(ctx)
+	refs, err := s.liveRefs(ctx)
+	if err != nil {
+		s.t.Fatalf("%s", err.Error())
+	}
+	// containers with another configuration would be recreated under the other users
+	if refs > 1 {
+		if err = s.checkShared(ctx); err != nil {
+			s.t.Fatalf("%s", err.Error())
+		}
+	}
+	s.start(ctx)
+}
+
+// checkShared checks that the running containers of the shared suite have the
+// same configuration as the ones of this process.
+func (s *Suite) checkShared(ctx context.Context) error {
+	s.mu.Lock()
+	containers := make([]*Container, 0, len(s.containers))
+	for _, c := range s.containers {
+		containers = append(containers, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range containers {
+		running, err := findContainerByName(ctx, s.cli, c.dockerName)
+		if err != nil {
+			return fmt.Errorf("container listing failure: %s", err.Error())
+		}
+		hash := c.configHash()
+		for _, cont := range running {
+			if cont.Labels[labelShared] == s.name && cont.Labels[labelHash] != hash {
+				return fmt.Errorf("container %s of shared suite %s is used by other sessions with a different configuration, "+
+					"all users of a shared suite have to configure it identically", c.Name, s.name)
+			}
+		}
+	}
+	return nil
+}
+
+// closeShared removes the reference of the current session to the shared
+// suite and tears the suite down, if it was the last one.
+func (s *Suite) closeShared(ctx context.Context) error {
+	unlock, err := s.lockShared()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	refs, err := s.removeRef(ctx)
+	if err != nil {
+		return err
+	}
+	if refs > 0 {
+		printf("(cancel) %-25s (%-64s) - shared suite still used by %d other sessions", s.name, "", refs)
+		return nil
+	}
+
+	if err = s.close(ctx); err != nil {
+		return err
+	}
+
+	return removeShared(ctx, s.cli, s.name)
+}
+
+// removeShared removes all containers, networks and volumes of the given shared suite.
+func removeShared(ctx context.Context, cli client.APIClient, suite string) error {
+	return removeByLabel(ctx, cli, labelShared+"="+suite)
+}
+
+// removeByLabel removes all containers, networks and volumes with the given
+// label, given as key or key=value.
+func removeByLabel(ctx context.Context, cli client.APIClient, label string) error {
+	args := filters.NewArgs(filters.Arg("label", label))
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		return fmt.Errorf("container listing failure: %s", err.Error())
+	}
+	for _, cc := range containers {
+		if err = cli.ContainerRemove(ctx, cc.ID, types.ContainerRemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("container removal failure: %s", err.Error())
+		}
+		printf("(cancel) %-25s (%s) - container removed", cc.Names[0], cc.ID)
+	}
+
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{Filters: args})
+	if err != nil {
+		return fmt.Errorf("network listing failure: %s", err.Error())
+	}
+	for _, nn := range networks {
+		if err = cli.NetworkRemove(ctx, nn.ID); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("network removal failure: %s", err.Error())
+		}
+		printf("(cancel) %-25s (%s) - network removed", nn.Name, nn.ID)
+	}
+
+	volumes, err := cli.VolumeList(ctx, args)
+	if err != nil {
+		return fmt.Errorf("volume listing failure: %s", err.Error())
+	}
+	for _, v := range volumes.Volumes {
+		if err = cli.VolumeRemove(ctx, v.Name, true); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("volume removal failure: %s", err.Error())
+		}
+		printf("(cancel) %-25s (%-64s) - volume removed", v.Name, "")
+	}
+
+	return nil
+}
+
+// sharedInUse returns the shared suites, which are referenced by at least
+// one session, which isn't dead.
+func sharedInUse(ctx context.Context, cli client.APIClient, deadline time.Time) (map[string]bool, error) {
+	refs, err := cli.VolumeList(ctx, filters.NewArgs(filters.Arg("label", labelRef)))
+	if err != nil {
+		return nil, fmt.Errorf("volume listing failure: %s", err.Error())
+	}
+
+	inUse := make(map[string]bool)
+	for _, v := range refs.Volumes {
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt) // nolint: errcheck
+		if !isDeadSession(v.Labels, created, deadline) {
+			inUse[v.Labels[labelRef]] = true
+		}
+	}
+	return inUse, nil
+}
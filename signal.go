@@ -0,0 +1,54 @@
+package testingdock
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// HandleSignals controls whether testingdock tears down all registered
+// suites when the test binary receives SIGINT or SIGTERM, e.g. on Ctrl-C,
+// and exits with code 1 afterwards. It is off by default, as the handler
+// replaces the interrupt behavior of go test. It has to be set before the
+// first suite is created.
+var HandleSignals bool
+
+var signalOnce sync.Once
+
+// handleSignals installs the signal handler, which unregisters all suites
+// and exits the process. It is installed at most once.
+func handleSignals() {
+	signalOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			sig := <-ch
+			printf("(signal) received %s, tearing down all suites", sig)
+
+			// a second signal skips the teardown
+			go func() {
+				<-ch
+				printf("(signal) received second signal, exiting without teardown")
+				os.Exit(1)
+			}()
+
+			// the teardown runs in its own goroutine, so that a panic inside
+			// of it is recovered without stopping this one before exiting
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer func() {
+					if r := recover(); r != nil {
+						printf("(signal) teardown failure: %v", r)
+					}
+				}()
+				UnregisterAll()
+			}()
+			<-done
+
+			os.Exit(1)
+		}()
+	})
+}
@@ -0,0 +1,396 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
+)
+
+// snapshot is a snapshot of the state of a suite, see Suite.Snapshot.
+type snapshot struct {
+	// images of the containers by container name
+	images map[string]string
+	// docker names of the volume copies by volume name
+	volumes map[string]string
+	// docker names of the copies of the other volumes mounted into the
+	// containers by their docker name
+	mounts map[string]string
+}
+
+// containerSnapshot is the snapshot a container has been restored from, which
+// is used instead of the configured image and ports when it is created.
+type containerSnapshot struct {
+	image string
+	ports nat.PortMap
+}
+
+// Snapshot saves the state of all started containers of the suite under the
+// given name, so that Restore can roll them back to it, e.g. after seeding data
+// once for a group of tests. The containers are committed to images and the
+// volumes of the suite and all other volumes mounted by name, e.g. external ones,
+// are copied, while all containers are paused. Committing does not save the content
+// of volumes, so tmpfs mounts and anonymous volumes, e.g. of a VOLUME of the image
+// without a volume mounted to it, fail the snapshot. Bind mounts are left alone.
+// Snapshots are removed when the suite closes.
+func (s *Suite) Snapshot(ctx context.Context, name string) error {
+	containers := s.startedContainers()
+
+	var (
+		paused []*Container
+		snap   *snapshot
+		err    error
+	)
+	for _, c := range containers {
+		if err = c.cli.ContainerPause(ctx, c.ID); err != nil {
+			err = fmt.Errorf("container pause failure of %s: %s", c.Name, err.Error())
+			break
+		}
+		paused = append(paused, c)
+	}
+	if err == nil {
+		snap, err = s.snapshot(ctx, name, containers)
+	}
+	// the paused containers are unpaused, even if pausing the others failed
+	for _, c := range paused {
+		if uerr := c.cli.ContainerUnpause(ctx, c.ID); uerr != nil && err == nil {
+			err = uerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	old := s.snapshots[name]
+	s.snapshots[name] = snap
+	s.mu.Unlock()
+	if old != nil {
+		s.removeSnapshot(ctx, old)
+	}
+
+	printf("(snap  ) %-25s - suite snapshot taken: %s", s.name, name)
+	return nil
+}
+
+// snapshot commits the paused containers and copies the volumes. The images and
+// volume copies get fresh names, so that an earlier snapshot with the same name
+// stays intact until this one succeeded, and are removed again on failure.
+func (s *Suite) snapshot(ctx context.Context, name string, containers []*Container) (*snapshot, error) {
+	// the mounts are checked first, so that nothing is committed in vain
+	mounts, err := s.snapshotMounts(ctx, containers)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.snapshotSeq++
+	suffix := name + "_" + strconv.Itoa(s.snapshotSeq)
+	s.mu.Unlock()
+
+	snap := &snapshot{images: make(map[string]string), volumes: make(map[string]string), mounts: make(map[string]string)}
+	if err = s.takeSnapshot(ctx, snap, suffix, containers, mounts); err != nil {
+		tctx, cancel := context.WithTimeout(context.Background(), s.teardownTimeout)
+		defer cancel()
+		s.removeSnapshot(tctx, snap)
+		return nil, err
+	}
+	return snap, nil
+}
+
+// takeSnapshot commits the containers and copies the volumes into the snapshot,
+// which records everything created so far, also on failure.
+func (s *Suite) takeSnapshot(ctx context.Context, snap *snapshot, suffix string, containers []*Container, mounts []string) error {
+	for _, c := range containers {
+		image := snapshotImage(c.dockerName, suffix)
+		if _, err := c.cli.ContainerCommit(ctx, c.ID, types.ContainerCommitOptions{
+			Reference: image,
+			Comment:   "testingdock snapshot " + suffix,
+		}); err != nil {
+			return fmt.Errorf("container commit failure of %s: %s", c.Name, err.Error())
+		}
+		snap.images[c.Name] = image
+		printf("(snap  ) %-25s (%s) - container committed: %s", c.Name, c.ID, image)
+	}
+
+	for _, v := range s.getVolumes() {
+		copyName := v.dockerName + "_snapshot_" + suffix
+		if err := s.snapshotVolume(ctx, v.dockerName, copyName, func() { snap.volumes[v.name] = copyName }); err != nil {
+			return fmt.Errorf("volume copy failure of %s: %s", v.name, err.Error())
+		}
+		printf("(snap  ) %-25s (%-64s) - volume copied: %s", v.name, v.dockerName, copyName)
+	}
+
+	for _, m := range mounts {
+		copyName := m + "_snapshot_" + suffix
+		if err := s.snapshotVolume(ctx, m, copyName, func() { snap.mounts[m] = copyName }); err != nil {
+			return fmt.Errorf("volume copy failure of %s: %s", m, err.Error())
+		}
+		printf("(snap  ) %-25s - mounted volume copied: %s", m, copyName)
+	}
+	return nil
+}
+
+// snapshotVolume creates the volume copy of a snapshot and copies the content
+// of the given volume into it. created is called once the copy exists.
+func (s *Suite) snapshotVolume(ctx context.Context, source, copyName string, created func()) error {
+	labels := createTestingLabel()
+	labels[LabelSuite] = s.name
+	if _, err := s.cli.VolumeCreate(ctx, volume.VolumeCreateBody{Name: copyName, Labels: labels}); err != nil {
+		return fmt.Errorf("volume creation failure: %s", err.Error())
+	}
+	created()
+	return copyVolume(ctx, s, source, copyName)
+}
+
+// snapshotMounts returns the names of the volumes mounted into the containers,
+// which are not volumes of the suite, e.g. external ones. Mounts, whose content
+// can't be restored, fail the snapshot instead of losing it.
+func (s *Suite) snapshotMounts(ctx context.Context, containers []*Container) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, v := range s.getVolumes() {
+		seen[v.dockerName] = true
+	}
+
+	var mounts []string
+	for _, c := range containers {
+		cjson, err := c.Inspect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// docker does not list the tmpfs mounts of the host config as mounts
+		for path := range cjson.HostConfig.Tmpfs {
+			return nil, fmt.Errorf("tmpfs mount %s of %s cannot be saved", path, c.Name)
+		}
+		named := namedVolumes(cjson.HostConfig)
+		for _, m := range cjson.Mounts {
+			switch {
+			case m.Type == mount.TypeTmpfs:
+				return nil, fmt.Errorf("tmpfs mount %s of %s cannot be saved", m.Destination, c.Name)
+			case m.Type != mount.TypeVolume || seen[m.Name]:
+				// directories of the host are not changed by the restore
+			case !named[m.Name]:
+				return nil, fmt.Errorf("anonymous volume %s of %s cannot be saved, a volume has to be mounted to it", m.Destination, c.Name)
+			default:
+				seen[m.Name] = true
+				mounts = append(mounts, m.Name)
+			}
+		}
+	}
+	return mounts, nil
+}
+
+// namedVolumes returns the names of the volumes mounted into a container by name,
+// the other volumes of the container are anonymous.
+func namedVolumes(hcfg *container.HostConfig) map[string]bool {
+	named := make(map[string]bool)
+	for _, m := range hcfg.Mounts {
+		if m.Type == mount.TypeVolume && m.Source != "" {
+			named[m.Source] = true
+		}
+	}
+	for _, b := range hcfg.Binds {
+		// the source of a bind is either a path of the host or the name of a volume
+		if parts := strings.SplitN(b, ":", 2); len(parts) == 2 && !strings.HasPrefix(parts[0], "/") {
+			named[parts[0]] = true
+		}
+	}
+	return named
+}
+
+// Restore rolls all containers of the suite back to the snapshot with the given
+// name. The containers are removed, the volumes are restored and the containers
+// are recreated from the snapshot images with identical names, aliases and host
+// ports, in the start order of the suite, running through the same hooks and
+// health checks as on start. Restoring closed containers fails. Containers
+// recreated later, e.g. by Container.Restart after Container.Stop, start from
+// their configured image again, as the snapshot images are removed with the
+// snapshot.
+func (s *Suite) Restore(ctx context.Context, name string) error {
+	s.mu.Lock()
+	snap, ok := s.snapshots[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no snapshot %s of suite %s", name, s.name)
+	}
+
+	// the containers are recreated in the start order of the suite
+	var containers []*Container
+	for _, c := range s.startedContainers() {
+		if snap.images[c.Name] != "" {
+			containers = append(containers, c)
+		}
+	}
+
+	// the containers must not be restarted or closed while they are recreated,
+	// they are locked in start order like close does
+	for _, c := range containers {
+		c.lifecycle.Lock()
+		defer c.lifecycle.Unlock()
+	}
+	for _, c := range containers {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return fmt.Errorf("container %s is closed", c.Name)
+		}
+	}
+
+	snapshots := make(map[*Container]*containerSnapshot, len(containers))
+	for i := len(containers) - 1; i >= 0; i-- {
+		c := containers[i]
+		cjson, err := c.Inspect(ctx)
+		if err != nil {
+			return err
+		}
+		c.stopStats()
+		if err = c.clearFaults(ctx); err != nil {
+			return err
+		}
+		if err = c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return fmt.Errorf("container removal failure of %s: %s", c.Name, err.Error())
+		}
+		if err = c.waitRemoved(ctx); err != nil {
+			return err
+		}
+		cs := &containerSnapshot{image: snap.images[c.Name], ports: cjson.HostConfig.PortBindings}
+		// keep the host ports docker picked
+		if cjson.NetworkSettings != nil && len(cjson.NetworkSettings.Ports) > 0 {
+			cs.ports = cjson.NetworkSettings.Ports
+		}
+		snapshots[c] = cs
+		printf("(snap  ) %-25s (%s) - container removed for restore", c.Name, c.ID)
+	}
+
+	for _, v := range s.getVolumes() {
+		if copyName, ok := snap.volumes[v.name]; ok {
+			if err := copyVolume(ctx, s, copyName, v.dockerName); err != nil {
+				return fmt.Errorf("volume restore failure of %s: %s", v.name, err.Error())
+			}
+			printf("(snap  ) %-25s (%-64s) - volume restored from: %s", v.name, v.dockerName, copyName)
+		}
+	}
+	for m, copyName := range snap.mounts {
+		if err := copyVolume(ctx, s, copyName, m); err != nil {
+			return fmt.Errorf("volume restore failure of %s: %s", m, err.Error())
+		}
+		printf("(snap  ) %-25s - mounted volume restored from: %s", m, copyName)
+	}
+
+	for _, c := range containers {
+		// the snapshot is only used for this creation, see above
+		c.snapshot = snapshots[c]
+		err := c.createContainer(ctx)
+		c.snapshot = nil
+		if err != nil {
+			return fmt.Errorf("container restore failure of %s: %s", c.Name, err.Error())
+		}
+		// the restored container runs through the same hooks as on start
+		if err = c.afterStart(ctx); err != nil {
+			return fmt.Errorf("container restore failure of %s: %s", c.Name, err.Error())
+		}
+		printf("(snap  ) %-25s (%s) - container restored", c.Name, c.ID)
+	}
+
+	printf("(snap  ) %-25s - suite restored from snapshot: %s", s.name, name)
+	return nil
+}
+
+// startedContainers returns the started containers of the suite in start order.
+func (s *Suite) startedContainers() []*Container {
+	var containers []*Container
+	for _, cs := range s.Spec().Containers {
+		if c, ok := s.Lookup(cs.Name); ok && c.ID != "" {
+			containers = append(containers, c)
+		}
+	}
+	return containers
+}
+
+// snapshotImage returns the image reference of the snapshot of a container.
+func snapshotImage(dockerName, snapshot string) string {
+	sanitize := func(s string) string {
+		return strings.ToLower(invalidEnvChars.ReplaceAllString(s, "_"))
+	}
+	return "testingdock-snapshot/" + sanitize(dockerName) + ":" + sanitize(snapshot)
+}
+
+// copyVolume replaces the content of the target volume with the one of the source
+// volume using a helper container.
+func copyVolume(ctx context.Context, s *Suite, source, target string) error {
+	if err := pullMissing(ctx, s.cli, VolumeImage); err != nil {
+		return err
+	}
+
+	labels := createTestingLabel()
+	labels[LabelSuite] = s.name
+	cont, err := s.cli.ContainerCreate(ctx, &container.Config{
+		Image:  VolumeImage,
+		Cmd:    []string{"sh", "-c", "find /to -mindepth 1 -delete && cp -a /from/. /to/"},
+		Labels: labels,
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeVolume, Source: source, Target: "/from", ReadOnly: true},
+			{Type: mount.TypeVolume, Source: target, Target: "/to"},
+		},
+	}, nil, "")
+	if err != nil {
+		return fmt.Errorf("container creation failure: %s", err.Error())
+	}
+	defer s.cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true}) // nolint: errcheck
+
+	statusC, errC := s.cli.ContainerWait(ctx, cont.ID, container.WaitConditionNextExit)
+	if err = s.cli.ContainerStart(ctx, cont.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	select {
+	case status := <-statusC:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("copy exited with code %d", status.StatusCode)
+		}
+		return nil
+	case err = <-errC:
+		return err
+	}
+}
+
+// removeSnapshots removes the images and volume copies of all snapshots.
+func (s *Suite) removeSnapshots(ctx context.Context) {
+	s.mu.Lock()
+	snapshots := s.snapshots
+	s.snapshots = make(map[string]*snapshot)
+	s.mu.Unlock()
+
+	for _, snap := range snapshots {
+		s.removeSnapshot(ctx, snap)
+	}
+}
+
+// removeSnapshot removes the images and volume copies of the snapshot.
+func (s *Suite) removeSnapshot(ctx context.Context, snap *snapshot) {
+	for _, image := range snap.images {
+		if _, err := s.cli.ImageRemove(ctx, image, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
+			printf("(snap  ) %-25s - snapshot image removal failure: %s", image, err.Error())
+		}
+	}
+	for _, copyName := range snap.volumes {
+		s.removeSnapshotVolume(ctx, copyName)
+	}
+	for _, copyName := range snap.mounts {
+		s.removeSnapshotVolume(ctx, copyName)
+	}
+}
+
+// removeSnapshotVolume removes a volume copy of a snapshot.
+func (s *Suite) removeSnapshotVolume(ctx context.Context, copyName string) {
+	if err := s.cli.VolumeRemove(ctx, copyName, true); err != nil {
+		printf("(snap  ) %-25s - snapshot volume removal failure: %s", copyName, err.Error())
+	}
+}
@@ -0,0 +1,285 @@
+package testingdock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestSuite_SnapshotRestore(t *testing.T) {
+	name := "TestSuite_SnapshotRestore"
+
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	if err = ioutil.WriteFile(filepath.Join(dir, "init.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	v := s.Volume(testingdock.VolumeOpts{Name: name, Source: dir})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	db := s.Container(testingdock.ContainerOpts{
+		Name:       "db",
+		Config:     &container.Config{Image: "postgres"},
+		HostConfig: &container.HostConfig{PortBindings: nat.PortMap{"5432/tcp": {{}}}},
+		Aliases:    []string{"database"},
+		Volumes:    []testingdock.VolumeMount{{Volume: v, Target: "/var/lib/postgresql/data"}},
+	})
+	app := s.Container(testingdock.ContainerOpts{Name: "app", Config: &container.Config{Image: "fake"}})
+	n.After(db)
+	db.After(app)
+	s.Start(context.TODO())
+
+	ctx := context.TODO()
+	if err = s.Restore(ctx, "seeded"); err == nil {
+		t.Error("restoring an unknown snapshot should fail")
+	}
+	if err = s.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	if images := engine.ImageCount(); images != 2 {
+		t.Errorf("all containers should be committed, got %d images", images)
+	}
+	if files := engine.VolumeFiles[v.DockerName()+"_snapshot_seeded_1"]; !reflect.DeepEqual(files, []string{"init.sql"}) {
+		t.Errorf("volume should be copied, got %v", files)
+	}
+	if cjson, err := db.Inspect(ctx); err != nil || cjson.State.Paused {
+		t.Fatalf("container should be unpaused after the snapshot: %v", err)
+	}
+
+	ports, err := db.Ports(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{db.ID, app.ID}
+	engine.VolumeFiles[v.DockerName()] = append(engine.VolumeFiles[v.DockerName()], "dirty.sql")
+	created := len(engine.CreationOrder())
+
+	if err = s.Restore(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	if db.ID == ids[0] || app.ID == ids[1] {
+		t.Error("containers should have been recreated")
+	}
+	// the helper container copying the volume has no name
+	var order []string
+	for _, name := range engine.CreationOrder()[created:] {
+		if name != "" {
+			order = append(order, name)
+		}
+	}
+	if !reflect.DeepEqual(order, []string{"db", "app"}) {
+		t.Errorf("containers should be recreated in start order, got %v", order)
+	}
+	fc := engine.ByName("db")
+	if !strings.HasPrefix(fc.Config.Image, "testingdock-snapshot/db:seeded") {
+		t.Errorf("container should be recreated from the snapshot image, got %s", fc.Config.Image)
+	}
+	if !reflect.DeepEqual(fc.Aliases, []string{"database"}) {
+		t.Errorf("aliases should be kept, got %v", fc.Aliases)
+	}
+	if restored, err := db.Ports(ctx); err != nil || !reflect.DeepEqual(restored, ports) {
+		t.Errorf("host ports should be kept, got %v instead of %v", restored, ports)
+	}
+	if files := engine.VolumeFiles[v.DockerName()]; !reflect.DeepEqual(files, []string{"init.sql"}) {
+		t.Errorf("volume should be restored, got %v", files)
+	}
+
+	// the snapshot image is only used by the restore
+	if err = app.Stop(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = app.Restart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if image := engine.ByName("app").Config.Image; image != "fake" {
+		t.Errorf("container should be recreated from its configured image, got %s", image)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if images, volumes := engine.ImageCount(), engine.VolumeCount(); images != 0 || volumes != 0 {
+		t.Errorf("snapshots should be removed on close, got %d images and %d volumes", images, volumes)
+	}
+}
+
+func TestSuite_RestoreHooks(t *testing.T) {
+	name := "TestSuite_RestoreHooks"
+	var called []string
+	hook := func(name string) testingdock.HookFunc {
+		return func(ctx context.Context, c *testingdock.Container) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:        "db",
+		Config:      &container.Config{Image: "postgres"},
+		PostCreate:  hook("post create"),
+		PostStart:   hook("post start"),
+		PostHealthy: hook("post healthy"),
+	}))
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	ctx := context.TODO()
+	if err := s.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	start := []string{"post create", "post start", "post healthy"}
+	if expected := append(start, start...); !reflect.DeepEqual(called, expected) {
+		t.Errorf("the restored container should go through the hooks again, got %v", called)
+	}
+}
+
+func TestSuite_SnapshotCopyFailure(t *testing.T) {
+	name := "TestSuite_SnapshotCopyFailure"
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	v := s.Volume(testingdock.VolumeOpts{Name: name})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	db := s.Container(testingdock.ContainerOpts{
+		Name:    "db",
+		Config:  &container.Config{Image: "postgres"},
+		Volumes: []testingdock.VolumeMount{{Volume: v, Target: "/var/lib/postgresql/data"}},
+	})
+	n.After(db)
+	s.Start(ctx)
+	defer s.Close() // nolint: errcheck
+
+	engine.VolumeFiles[v.DockerName()] = []string{"seed.sql"}
+	if err := s.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	images, volumes := engine.ImageCount(), engine.VolumeCount()
+
+	// a failed snapshot neither leaks its artifacts nor touches the earlier one
+	engine.VolumeFiles[v.DockerName()] = []string{"dirty.sql"}
+	engine.FailCopy = true
+	if err := s.Snapshot(ctx, "seeded"); err == nil || !strings.Contains(err.Error(), "volume copy failure") {
+		t.Errorf("the copy failure should be returned, got %v", err)
+	}
+	engine.FailCopy = false
+	if i, v := engine.ImageCount(), engine.VolumeCount(); i != images || v != volumes {
+		t.Errorf("partial snapshot should be removed, got %d images and %d volumes instead of %d and %d", i, v, images, volumes)
+	}
+
+	if err := s.Restore(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	if files := engine.VolumeFiles[v.DockerName()]; !reflect.DeepEqual(files, []string{"seed.sql"}) {
+		t.Errorf("the earlier snapshot should be restored, got %v", files)
+	}
+}
+
+func TestSuite_SnapshotPauseFailure(t *testing.T) {
+	name := "TestSuite_SnapshotPauseFailure"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	db := s.Container(testingdock.ContainerOpts{Name: "db", Config: &container.Config{Image: "postgres"}})
+	app := s.Container(testingdock.ContainerOpts{Name: "app", Config: &container.Config{Image: fake.UnpausableImage}})
+	n.After(db)
+	db.After(app)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	ctx := context.TODO()
+	if err := s.Snapshot(ctx, "seeded"); err == nil || !strings.Contains(err.Error(), "container pause failure of app") {
+		t.Errorf("the pause failure should be returned, got %v", err)
+	}
+	if cjson, err := db.Inspect(ctx); err != nil || cjson.State.Paused {
+		t.Errorf("paused containers should be unpaused again: %v", err)
+	}
+	if images := engine.ImageCount(); images != 0 {
+		t.Errorf("no container should be committed, got %d images", images)
+	}
+}
+
+func TestSuite_SnapshotMounts(t *testing.T) {
+	name := "TestSuite_SnapshotMounts"
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	if _, err := engine.VolumeCreate(ctx, volume.VolumeCreateBody{Name: "shared-data"}); err != nil {
+		t.Fatal(err)
+	}
+	engine.VolumeFiles["shared-data"] = []string{"seed.sql"}
+
+	// the VOLUME of the image is backed by a volume, which is not one of the suite
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:       "db",
+		Config:     &container.Config{Image: "postgres", Volumes: map[string]struct{}{"/var/lib/postgresql/data": {}}},
+		HostConfig: &container.HostConfig{Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: "shared-data", Target: "/var/lib/postgresql/data"}}},
+	}))
+	s.Start(ctx)
+
+	if err := s.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	engine.VolumeFiles["shared-data"] = append(engine.VolumeFiles["shared-data"], "dirty.sql")
+	if err := s.Restore(ctx, "seeded"); err != nil {
+		t.Fatal(err)
+	}
+	if files := engine.VolumeFiles["shared-data"]; !reflect.DeepEqual(files, []string{"seed.sql"}) {
+		t.Errorf("the mounted volume should be restored, got %v", files)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the copy is removed with the snapshot, the volume itself is not owned by the suite
+	if volumes := engine.VolumeCount(); volumes != 1 {
+		t.Errorf("only the mounted volume should be left, got %d volumes", volumes)
+	}
+}
+
+func TestSuite_SnapshotUnsavedMounts(t *testing.T) {
+	for name, opts := range map[string]testingdock.ContainerOpts{
+		"anonymous volume /data": {Config: &container.Config{Image: "fake", Volumes: map[string]struct{}{"/data": {}}}},
+		"tmpfs mount /data":      {Config: &container.Config{Image: "fake"}, Tmpfs: []string{"/data"}},
+	} {
+		suite := "TestSuite_SnapshotUnsavedMounts_" + strings.Fields(name)[0]
+		engine := fake.NewEngine()
+		s, _ := testingdock.GetOrCreateSuite(t, suite, testingdock.SuiteOpts{Client: engine})
+		n := s.Network(testingdock.NetworkOpts{Name: suite})
+		opts.Name = "db"
+		n.After(s.Container(opts))
+		s.Start(context.TODO())
+
+		// the content would be lost on restore
+		if err := s.Snapshot(context.TODO(), "seeded"); err == nil || !strings.Contains(err.Error(), name+" of db cannot be saved") {
+			t.Errorf("%s: the snapshot should fail, got %v", name, err)
+		}
+		if images := engine.ImageCount(); images != 0 {
+			t.Errorf("%s: no container should be committed, got %d images", name, images)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
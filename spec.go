@@ -0,0 +1,569 @@
+package testingdock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/go-connections/nat"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// health check strategies of a HealthCheckSpec
+const (
+	HealthCheckTypeRunning = "running"
+	HealthCheckTypeDocker  = "docker"
+	HealthCheckTypeExited  = "exited"
+	HealthCheckTypeHTTP    = "http"
+	HealthCheckTypeTCP     = "tcp"
+	HealthCheckTypeExec    = "exec"
+)
+
+// reset strategies of a ResetSpec
+const (
+	ResetTypeRestart = "restart"
+	ResetTypeExec    = "exec"
+	ResetTypeNone    = "none"
+)
+
+// Spec is the testingdock specific description of a suite, which can be written
+// as YAML or JSON. Unlike a compose file it expresses the testingdock health check
+// and reset strategies, reuse, fixtures and the order the containers are started in.
+//
+// A Spec only covers the options listed here. Health checks and resets given as
+// Go functions can not be written to a spec and are left out when dumping a suite.
+type Spec struct {
+	// name of the suite
+	Name    string       `yaml:"name,omitempty" json:"name,omitempty"`
+	Network *NetworkSpec `yaml:"network,omitempty" json:"network,omitempty"`
+	Volumes []VolumeSpec `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	// containers are listed after the containers they are started after or need
+	Containers []ContainerSpec `yaml:"containers,omitempty" json:"containers,omitempty"`
+}
+
+// NetworkSpec describes the network of a suite, see NetworkOpts.
+type NetworkSpec struct {
+	Name  string `yaml:"name" json:"name"`
+	Reuse bool   `yaml:"reuse,omitempty" json:"reuse,omitempty"`
+}
+
+// VolumeSpec describes a volume of a suite, see VolumeOpts.
+type VolumeSpec struct {
+	Name   string `yaml:"name" json:"name"`
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	Reuse  bool   `yaml:"reuse,omitempty" json:"reuse,omitempty"`
+}
+
+// VolumeMountSpec describes a volume mounted into a container, see VolumeMount.
+type VolumeMountSpec struct {
+	// name of the volume in the spec
+	Volume   string `yaml:"volume" json:"volume"`
+	Target   string `yaml:"target" json:"target"`
+	ReadOnly bool   `yaml:"readOnly,omitempty" json:"readOnly,omitempty"`
+	NoCopy   bool   `yaml:"noCopy,omitempty" json:"noCopy,omitempty"`
+}
+
+// ContainerSpec describes a container of a suite, see ContainerOpts.
+type ContainerSpec struct {
+	Name       string     `yaml:"name" json:"name"`
+	Image      string     `yaml:"image" json:"image"`
+	Build      *BuildSpec `yaml:"build,omitempty" json:"build,omitempty"`
+	ForcePull  bool       `yaml:"forcePull,omitempty" json:"forcePull,omitempty"`
+	Command    []string   `yaml:"command,omitempty" json:"command,omitempty"`
+	Entrypoint []string   `yaml:"entrypoint,omitempty" json:"entrypoint,omitempty"`
+	Env        []string   `yaml:"env,omitempty" json:"env,omitempty"`
+	// published ports in the format of `docker run -p`, e.g. "8080:80/tcp"
+	Ports    []string          `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Aliases  []string          `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	Reuse    bool              `yaml:"reuse,omitempty" json:"reuse,omitempty"`
+	Fixtures []Fixture         `yaml:"fixtures,omitempty" json:"fixtures,omitempty"`
+	Volumes  []VolumeMountSpec `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	Tmpfs    []string          `yaml:"tmpfs,omitempty" json:"tmpfs,omitempty"`
+	// bind mounts and volumes which are not part of the suite, e.g. external ones
+	Mounts []MountSpec `yaml:"mounts,omitempty" json:"mounts,omitempty"`
+	// paths anonymous volumes are mounted at
+	AnonymousVolumes []string `yaml:"anonymousVolumes,omitempty" json:"anonymousVolumes,omitempty"`
+	// health check run by docker, which replaces the one of the image
+	DockerHealthCheck *DockerHealthCheckSpec `yaml:"dockerHealthCheck,omitempty" json:"dockerHealthCheck,omitempty"`
+	// name of the container this one is started after, it is started after the
+	// network if empty, see Container.After
+	After string `yaml:"after,omitempty" json:"after,omitempty"`
+	// names of the containers which have to be ready before this one is created,
+	// see Container.Needs
+	Needs       []string         `yaml:"needs,omitempty" json:"needs,omitempty"`
+	HealthCheck *HealthCheckSpec `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+	Reset       *ResetSpec       `yaml:"reset,omitempty" json:"reset,omitempty"`
+	Resources   *Resources       `yaml:"resources,omitempty" json:"resources,omitempty"`
+}
+
+// MountSpec describes a mount of a container, see mount.Mount.
+type MountSpec struct {
+	// one of the mount.Type constants, default is mount.TypeBind
+	Type     string `yaml:"type,omitempty" json:"type,omitempty"`
+	Source   string `yaml:"source,omitempty" json:"source,omitempty"`
+	Target   string `yaml:"target" json:"target"`
+	ReadOnly bool   `yaml:"readOnly,omitempty" json:"readOnly,omitempty"`
+}
+
+// DockerHealthCheckSpec describes the health check docker runs in a container,
+// see container.HealthConfig. It is only waited for by the docker health check strategy.
+type DockerHealthCheckSpec struct {
+	// e.g. ["CMD", "pg_isready"], ["NONE"] disables the health check of the image
+	Test        []string `yaml:"test,omitempty" json:"test,omitempty"`
+	Interval    Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout     Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	StartPeriod Duration `yaml:"startPeriod,omitempty" json:"startPeriod,omitempty"`
+	Retries     int      `yaml:"retries,omitempty" json:"retries,omitempty"`
+}
+
+// BuildSpec describes how to build the image of a container, see BuildOpts.
+type BuildSpec struct {
+	Context    string             `yaml:"context" json:"context"`
+	Dockerfile string             `yaml:"dockerfile,omitempty" json:"dockerfile,omitempty"`
+	Args       map[string]*string `yaml:"args,omitempty" json:"args,omitempty"`
+}
+
+// HealthCheckSpec describes the health check strategy of a container.
+type HealthCheckSpec struct {
+	// one of the HealthCheckType constants, default is HealthCheckTypeRunning
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// url of the http health check
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+	// address of the tcp health check
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+	// command of the exec health check
+	Command []string `yaml:"command,omitempty" json:"command,omitempty"`
+	// default is 30s
+	Timeout Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// ResetSpec describes the reset strategy of a container.
+type ResetSpec struct {
+	// one of the ResetType constants, default is ResetTypeRestart
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// command of the exec reset
+	Command []string `yaml:"command,omitempty" json:"command,omitempty"`
+}
+
+// Duration is a time.Duration written as string like "1m30s" in specs.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParseSpec parses a spec written as YAML or JSON.
+func ParseSpec(b []byte) (*Spec, error) {
+	var sp Spec
+	// JSON is a subset of YAML
+	if err := yaml.UnmarshalStrict(b, &sp); err != nil {
+		return nil, fmt.Errorf("spec parsing failure: %s", err.Error())
+	}
+
+	return &sp, nil
+}
+
+// ReadSpec reads the spec file at the given path. Variables like ${VAR},
+// ${VAR:-default} and ${VAR:?message} in the values are substituted from the
+// environment like in compose files, and relative paths of fixtures, bind mounts
+// and build contexts are resolved against the directory of the file.
+func ReadSpec(path string) (*Spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = interpolateYAML(b, reflect.TypeOf(Spec{})); err != nil {
+		return nil, fmt.Errorf("spec parsing failure: %s", err.Error())
+	}
+	sp, err := ParseSpec(b)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sp.Volumes {
+		if sp.Volumes[i].Source != "" {
+			sp.Volumes[i].Source = absPath(dir, sp.Volumes[i].Source)
+		}
+	}
+	for i := range sp.Containers {
+		cs := &sp.Containers[i]
+		if cs.Build != nil {
+			cs.Build.Context = absPath(dir, cs.Build.Context)
+		}
+		for j := range cs.Fixtures {
+			cs.Fixtures[j].Source = absPath(dir, cs.Fixtures[j].Source)
+		}
+		for j := range cs.Mounts {
+			if m := &cs.Mounts[j]; m.Type == "" || m.Type == string(mount.TypeBind) {
+				m.Source = absPath(dir, m.Source)
+			}
+		}
+	}
+
+	return sp, nil
+}
+
+// YAML returns the spec written as YAML, it can be written as JSON with
+// encoding/json.
+func (sp *Spec) YAML() ([]byte, error) {
+	return yaml.Marshal(sp)
+}
+
+// LoadSpec populates the suite with the spec file at the given path, see ReadSpec
+// and ApplySpec.
+func (s *Suite) LoadSpec(path string) error {
+	sp, err := ReadSpec(path)
+	if err != nil {
+		return err
+	}
+
+	return s.ApplySpec(sp)
+}
+
+// ApplySpec populates the suite with the network, the volumes and the containers of the spec.
+// The network of the spec is only created if the suite has no network yet, if
+// neither of them has one, a network named after the spec is created.
+func (s *Suite) ApplySpec(sp *Spec) error {
+	// validate everything before touching the suite
+	volumes := make(map[string]*Volume)
+	for _, vs := range sp.Volumes {
+		if vs.Name == "" {
+			return fmt.Errorf("volume has no name")
+		}
+		if _, ok := volumes[vs.Name]; ok {
+			return fmt.Errorf("volume %s is defined twice", vs.Name)
+		}
+		volumes[vs.Name] = nil
+	}
+	opts := make([]ContainerOpts, len(sp.Containers))
+	defined := make(map[string]bool)
+	for i, cs := range sp.Containers {
+		if cs.Name == "" {
+			return fmt.Errorf("container %d has no name", i)
+		}
+		if defined[cs.Name] {
+			return fmt.Errorf("container %s is defined twice", cs.Name)
+		}
+		for _, dep := range append([]string{cs.After}, cs.Needs...) {
+			if dep != "" && !defined[dep] {
+				return fmt.Errorf("container %s depends on %s, which is not defined before it", cs.Name, dep)
+			}
+		}
+		for _, vm := range cs.Volumes {
+			if _, ok := volumes[vm.Volume]; !ok {
+				return fmt.Errorf("container %s mounts undefined volume %s", cs.Name, vm.Volume)
+			}
+		}
+		defined[cs.Name] = true
+
+		var err error
+		if opts[i], err = cs.containerOpts(); err != nil {
+			return fmt.Errorf("container %s: %s", cs.Name, err.Error())
+		}
+	}
+
+	for _, vs := range sp.Volumes {
+		volumes[vs.Name] = s.Volume(VolumeOpts{Name: vs.Name, Source: vs.Source, Reuse: vs.Reuse})
+	}
+
+	n := s.getNetwork()
+	if n == nil {
+		nopts := NetworkOpts{Name: sp.Name}
+		if sp.Network != nil {
+			nopts = NetworkOpts{Name: sp.Network.Name, Reuse: sp.Network.Reuse}
+		}
+		if nopts.Name == "" {
+			return fmt.Errorf("spec has neither a name nor a network")
+		}
+		n = s.Network(nopts)
+	}
+
+	containers := make(map[string]*Container, len(sp.Containers))
+	for i, cs := range sp.Containers {
+		for _, vm := range cs.Volumes {
+			opts[i].Volumes = append(opts[i].Volumes, VolumeMount{
+				Volume:   volumes[vm.Volume],
+				Target:   vm.Target,
+				ReadOnly: vm.ReadOnly,
+				NoCopy:   vm.NoCopy,
+			})
+		}
+		c := s.Container(opts[i])
+		if cs.HealthCheck != nil && cs.HealthCheck.Type == HealthCheckTypeExited {
+			// the exit code has to be inspected after the container exited
+			c.hcfg.AutoRemove = false
+		}
+		c.healthSpec = cs.HealthCheck
+		c.resetSpec = cs.Reset
+		containers[cs.Name] = c
+
+		if cs.After == "" {
+			n.After(c)
+		} else {
+			containers[cs.After].After(c)
+		}
+		for _, dep := range cs.Needs {
+			c.Needs(containers[dep])
+		}
+	}
+
+	return nil
+}
+
+// containerOpts converts the container spec to container options.
+func (cs ContainerSpec) containerOpts() (ContainerOpts, error) {
+	opts := ContainerOpts{
+		Name:      cs.Name,
+		ForcePull: cs.ForcePull,
+		Config: &container.Config{
+			Image:      cs.Image,
+			Cmd:        strslice.StrSlice(cs.Command),
+			Entrypoint: strslice.StrSlice(cs.Entrypoint),
+			Env:        cs.Env,
+		},
+		HostConfig: &container.HostConfig{},
+		Aliases:    cs.Aliases,
+		Reuse:      cs.Reuse,
+		Fixtures:   cs.Fixtures,
+		Tmpfs:      cs.Tmpfs,
+		Resources:  cs.Resources,
+	}
+	if cs.Image == "" {
+		return opts, fmt.Errorf("image is not set")
+	}
+	if cs.Build != nil {
+		opts.Build = &BuildOpts{
+			Context:    cs.Build.Context,
+			Dockerfile: cs.Build.Dockerfile,
+			Args:       cs.Build.Args,
+		}
+	}
+
+	for _, m := range cs.Mounts {
+		typ := mount.Type(m.Type)
+		if typ == "" {
+			typ = mount.TypeBind
+		}
+		opts.HostConfig.Mounts = append(opts.HostConfig.Mounts, mount.Mount{
+			Type:     typ,
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	if len(cs.AnonymousVolumes) > 0 {
+		opts.Config.Volumes = make(map[string]struct{}, len(cs.AnonymousVolumes))
+		for _, path := range cs.AnonymousVolumes {
+			opts.Config.Volumes[path] = struct{}{}
+		}
+	}
+	if hc := cs.DockerHealthCheck; hc != nil {
+		opts.Config.Healthcheck = &container.HealthConfig{
+			Test:        hc.Test,
+			Interval:    time.Duration(hc.Interval),
+			Timeout:     time.Duration(hc.Timeout),
+			StartPeriod: time.Duration(hc.StartPeriod),
+			Retries:     hc.Retries,
+		}
+	}
+
+	if len(cs.Ports) > 0 {
+		exposed, bindings, err := nat.ParsePortSpecs(cs.Ports)
+		if err != nil {
+			return opts, err
+		}
+		opts.Config.ExposedPorts = exposed
+		opts.HostConfig.PortBindings = bindings
+	}
+
+	if hc := cs.HealthCheck; hc != nil {
+		opts.HealthCheckTimeout = time.Duration(hc.Timeout)
+		switch hc.Type {
+		case "", HealthCheckTypeRunning:
+		case HealthCheckTypeDocker:
+			opts.HealthCheck = HealthCheckDocker()
+		case HealthCheckTypeExited:
+			opts.HealthCheck = healthCheckExited()
+		case HealthCheckTypeHTTP:
+			opts.HealthCheck = HealthCheckHTTP(hc.URL)
+		case HealthCheckTypeTCP:
+			opts.HealthCheck = HealthCheckTCP(hc.Address)
+		case HealthCheckTypeExec:
+			opts.HealthCheck = HealthCheckExec(hc.Command...)
+		default:
+			return opts, fmt.Errorf("unknown health check type: %s", hc.Type)
+		}
+	}
+
+	if r := cs.Reset; r != nil {
+		switch r.Type {
+		case "", ResetTypeRestart:
+		case ResetTypeExec:
+			opts.Reset = ResetExec(r.Command...)
+		case ResetTypeNone:
+			opts.Reset = ResetCustom(func() error { return nil })
+		default:
+			return opts, fmt.Errorf("unknown reset type: %s", r.Type)
+		}
+	}
+
+	return opts, nil
+}
+
+// Spec returns the spec of the suite, which can be applied to another suite to
+// get the same configuration.
+func (s *Suite) Spec() *Spec {
+	sp := &Spec{Name: s.name}
+	for _, v := range s.getVolumes() {
+		sp.Volumes = append(sp.Volumes, VolumeSpec{Name: v.name, Source: v.source, Reuse: v.reuse})
+	}
+
+	n := s.getNetwork()
+	if n == nil {
+		return sp
+	}
+	sp.Network = &NetworkSpec{Name: n.name, Reuse: n.reuse}
+
+	// parents are listed before their children
+	var visit func(c *Container, parent string)
+	visit = func(c *Container, parent string) {
+		sp.Containers = append(sp.Containers, c.spec(parent))
+		for _, cc := range c.children {
+			visit(cc, c.Name)
+		}
+	}
+	for _, c := range n.children {
+		visit(c, "")
+	}
+
+	// needs may point to containers in later branches, so containers are moved
+	// behind all the containers they depend on
+	var (
+		ordered []ContainerSpec
+		done    = make(map[string]bool)
+	)
+	for len(ordered) < len(sp.Containers) {
+		progress := false
+		for _, cs := range sp.Containers {
+			ready := !done[cs.Name] && (cs.After == "" || done[cs.After])
+			for _, dep := range cs.Needs {
+				ready = ready && done[dep]
+			}
+			if ready {
+				ordered = append(ordered, cs)
+				done[cs.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			// cyclic needs can not be started either, keep the tree order
+			return sp
+		}
+	}
+	sp.Containers = ordered
+
+	return sp
+}
+
+// spec returns the spec of the container, started after the given parent.
+func (c *Container) spec(parent string) ContainerSpec {
+	cs := ContainerSpec{
+		Name:        c.Name,
+		Image:       c.ccfg.Image,
+		ForcePull:   c.forcePull,
+		Command:     []string(c.ccfg.Cmd),
+		Entrypoint:  []string(c.ccfg.Entrypoint),
+		Env:         c.ccfg.Env,
+		Ports:       portSpecs(c.hcfg.PortBindings),
+		Aliases:     c.aliases,
+		Reuse:       c.reuse,
+		Fixtures:    c.fixtures,
+		Tmpfs:       c.tmpfs,
+		After:       parent,
+		HealthCheck: c.healthSpec,
+		Reset:       c.resetSpec,
+		Resources:   c.resources,
+	}
+	if c.build != nil {
+		cs.Build = &BuildSpec{
+			Context:    c.build.Context,
+			Dockerfile: c.build.Dockerfile,
+			Args:       c.build.Args,
+		}
+	}
+	for _, cc := range c.needs {
+		cs.Needs = append(cs.Needs, cc.Name)
+	}
+	for _, vm := range c.volumes {
+		cs.Volumes = append(cs.Volumes, VolumeMountSpec{
+			Volume:   vm.Volume.name,
+			Target:   vm.Target,
+			ReadOnly: vm.ReadOnly,
+			NoCopy:   vm.NoCopy,
+		})
+	}
+	for _, m := range c.mounts {
+		cs.Mounts = append(cs.Mounts, MountSpec{
+			Type:     string(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	for path := range c.ccfg.Volumes {
+		cs.AnonymousVolumes = append(cs.AnonymousVolumes, path)
+	}
+	sort.Strings(cs.AnonymousVolumes)
+	if hc := c.ccfg.Healthcheck; hc != nil {
+		cs.DockerHealthCheck = &DockerHealthCheckSpec{
+			Test:        hc.Test,
+			Interval:    Duration(hc.Interval),
+			Timeout:     Duration(hc.Timeout),
+			StartPeriod: Duration(hc.StartPeriod),
+			Retries:     hc.Retries,
+		}
+	}
+
+	return cs
+}
+
+// portSpecs returns the port bindings in the format of `docker run -p`.
+func portSpecs(bindings nat.PortMap) []string {
+	var specs []string
+	for port, bs := range bindings {
+		for _, b := range bs {
+			spec := string(port)
+			switch {
+			case b.HostIP != "":
+				spec = b.HostIP + ":" + b.HostPort + ":" + spec
+			case b.HostPort != "":
+				spec = b.HostPort + ":" + spec
+			}
+			specs = append(specs, spec)
+		}
+	}
+	sort.Strings(specs)
+
+	return specs
+}
@@ -0,0 +1,278 @@
+package testingdock_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/volume"
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+const specYAML = `
+name: shop
+network:
+  name: shop
+volumes:
+  - name: data
+containers:
+  - name: db
+    image: postgres:9.6
+    env: [POSTGRES_PASSWORD=secret]
+    ports: ["5432"]
+    volumes:
+      - volume: data
+        target: /var/lib/postgresql/data
+    fixtures:
+      - source: fixtures
+        target: /docker-entrypoint-initdb.d
+    healthCheck:
+      type: exec
+      command: [pg_isready]
+      timeout: 1m
+    reset:
+      type: exec
+      command: [psql, -c, TRUNCATE orders]
+  - name: cache
+    image: redis
+    reuse: true
+    tmpfs: [/data]
+    resources:
+      cpus: 0.5
+      memory: 268435456
+    reset:
+      type: none
+  - name: app
+    image: app
+    after: db
+    needs: [cache]
+    aliases: [api]
+    healthCheck:
+      type: http
+      url: http://localhost:8080/health
+`
+
+func TestSuite_ApplySpec(t *testing.T) {
+	sp, err := testingdock.ParseSpec([]byte(specYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Containers[0].HealthCheck.Timeout != testingdock.Duration(time.Minute) {
+		t.Errorf("wrong timeout: %s", time.Duration(sp.Containers[0].HealthCheck.Timeout))
+	}
+
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_ApplySpec", testingdock.SuiteOpts{Client: fake.NewEngine()})
+	if err = s.ApplySpec(sp); err != nil {
+		t.Fatalf("spec applying failure: %s", err.Error())
+	}
+
+	// ports are normalized when dumping
+	sp.Containers[0].Ports = []string{"5432/tcp"}
+	sp.Name = "TestSuite_ApplySpec"
+	if dumped := s.Spec(); !reflect.DeepEqual(dumped, sp) {
+		t.Errorf("dumped spec differs from applied one:\n%+v\n%+v", dumped, sp)
+	}
+
+	// round-trip through YAML and JSON
+	b, err := sp.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromYAML, err := testingdock.ParseSpec(b); err != nil || !reflect.DeepEqual(fromYAML, sp) {
+		t.Errorf("spec changed by YAML round-trip (%v):\n%s", err, b)
+	}
+	if b, err = json.Marshal(sp); err != nil {
+		t.Fatal(err)
+	}
+	if fromJSON, err := testingdock.ParseSpec(b); err != nil || !reflect.DeepEqual(fromJSON, sp) {
+		t.Errorf("spec changed by JSON round-trip (%v):\n%s", err, b)
+	}
+}
+
+func TestSuite_ApplySpec_Invalid(t *testing.T) {
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_ApplySpec_Invalid", testingdock.SuiteOpts{Client: fake.NewEngine()})
+
+	for name, spec := range map[string]string{
+		"undefined": "{name: x, containers: [{name: a, image: a, after: b}]}",
+		"twice":     "{name: x, containers: [{name: a, image: a}, {name: a, image: a}]}",
+		"health":    "{name: x, containers: [{name: a, image: a, healthCheck: {type: magic}}]}",
+		"unknown":   "{name: x, containers: [{name: a, image: a, restart: always}]}",
+		"volume":    "{name: x, containers: [{name: a, image: a, volumes: [{volume: b, target: /b}]}]}",
+	} {
+		sp, err := testingdock.ParseSpec([]byte(spec))
+		if err == nil {
+			err = s.ApplySpec(sp)
+		}
+		if err == nil {
+			t.Errorf("%s: spec should be invalid", name)
+		}
+	}
+	if _, ok := s.Lookup("a"); ok {
+		t.Error("invalid specs should not add containers")
+	}
+}
+
+func TestSuite_LoadSpec_Fixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	if err = os.Mkdir(filepath.Join(dir, "fixtures"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "fixtures", "init.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "testingdock.json")
+	spec := `{"name": "fixtures", "containers": [{"name": "db", "image": "postgres", "fixtures": [{"source": "fixtures", "target": "/docker-entrypoint-initdb.d"}]}]}`
+	if err = ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_LoadSpec_Fixtures", testingdock.SuiteOpts{Client: engine})
+	if err = s.LoadSpec(path); err != nil {
+		t.Fatalf("spec loading failure: %s", err.Error())
+	}
+
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	if files := engine.ByName("db").Files; !reflect.DeepEqual(files, []string{"/docker-entrypoint-initdb.d/init.sql"}) {
+		t.Errorf("wrong files copied: %v", files)
+	}
+}
+
+func TestReadSpec_Interpolation(t *testing.T) {
+	os.Setenv("TESTINGDOCK_SPEC_TAG", "0123")   // nolint: errcheck
+	os.Setenv("TESTINGDOCK_SPEC_REUSE", "true") // nolint: errcheck
+	os.Setenv("TESTINGDOCK_SPEC_PIDS", "64")    // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_SPEC_TAG")   // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_SPEC_REUSE") // nolint: errcheck
+	defer os.Unsetenv("TESTINGDOCK_SPEC_PIDS")  // nolint: errcheck
+
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	path := filepath.Join(dir, "testingdock.yml")
+	spec := `
+name: interpolation
+containers:
+  - name: db
+    image: postgres
+    env: ["TAG=${TESTINGDOCK_SPEC_TAG}", "${TESTINGDOCK_SPEC_REUSE}"]
+    reuse: ${TESTINGDOCK_SPEC_REUSE}
+    resources:
+      pids: ${TESTINGDOCK_SPEC_PIDS}
+`
+	if err = ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// substituted values stay strings, unless the field is a number or boolean
+	sp, err := testingdock.ReadSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := sp.Containers[0]
+	if !reflect.DeepEqual(c.Env, []string{"TAG=0123", "true"}) || !c.Reuse || c.Resources == nil || c.Resources.Pids != 64 {
+		t.Errorf("wrong interpolation: %+v", c)
+	}
+}
+
+func TestSuite_SpecCompose(t *testing.T) {
+	path := writeCompose(t, `
+name: shop
+services:
+  db:
+    image: postgres
+    volumes:
+      - /var/lib/postgresql/data
+      - ./init:/docker-entrypoint-initdb.d:ro
+      - cache:/var/cache
+    healthcheck:
+      test: pg_isready
+      interval: 1s
+      retries: 3
+  migrate:
+    image: oneshot
+    depends_on:
+      db:
+        condition: service_healthy
+  app:
+    image: app
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+volumes:
+  cache:
+    external: true
+    name: shared-cache
+`)
+
+	ctx := context.TODO()
+	engines := []*fake.Engine{fake.NewEngine(), fake.NewEngine()}
+	for _, engine := range engines {
+		if _, err := engine.VolumeCreate(ctx, volume.VolumeCreateBody{Name: "shared-cache"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_SpecCompose", testingdock.SuiteOpts{Client: engines[0]})
+	if err := s.LoadCompose(path); err != nil {
+		t.Fatalf("compose loading failure: %s", err.Error())
+	}
+	s.Start(ctx)
+	defer s.Close() // nolint: errcheck
+
+	// the dumped spec starts the same containers
+	sp := s.Spec()
+	b, err := sp.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := testingdock.ParseSpec(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := testingdock.GetOrCreateSuite(t, "TestSuite_SpecCompose_Loaded", testingdock.SuiteOpts{Client: engines[1]})
+	if err = other.ApplySpec(loaded); err != nil {
+		t.Fatalf("spec applying failure: %s\n%s", err.Error(), b)
+	}
+	sp.Name = "TestSuite_SpecCompose_Loaded"
+	if dumped := other.Spec(); !reflect.DeepEqual(dumped, sp) {
+		t.Errorf("dumped spec differs from compose one:\n%+v\n%+v", dumped, sp)
+	}
+	other.Start(ctx)
+	defer other.Close() // nolint: errcheck
+
+	for _, name := range []string{"db", "migrate", "app"} {
+		expected, c := engines[0].ByName(name), engines[1].ByName(name)
+		if c == nil {
+			t.Errorf("%s should be created", name)
+			continue
+		}
+		if !reflect.DeepEqual(c.Config.Healthcheck, expected.Config.Healthcheck) {
+			t.Errorf("%s: wrong health check: %+v, expected %+v", name, c.Config.Healthcheck, expected.Config.Healthcheck)
+		}
+		if !reflect.DeepEqual(c.Config.Volumes, expected.Config.Volumes) {
+			t.Errorf("%s: wrong anonymous volumes: %v, expected %v", name, c.Config.Volumes, expected.Config.Volumes)
+		}
+		if !reflect.DeepEqual(c.HostConfig.Mounts, expected.HostConfig.Mounts) {
+			t.Errorf("%s: wrong mounts: %v, expected %v", name, c.HostConfig.Mounts, expected.HostConfig.Mounts)
+		}
+		if c.HostConfig.AutoRemove != expected.HostConfig.AutoRemove {
+			t.Errorf("%s: wrong auto remove: %t", name, c.HostConfig.AutoRemove)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package testingdock
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// StatsDir is the directory, to which the resource usage of the containers
+// is written when a suite closes, if SuiteOpts.StatsDir is not set. Setting it
+// enables collecting stats for all suites.
+var StatsDir string
+
+// StatsSample is a sample of the resource usage of a container taken from the
+// docker stats API, which provides one per second.
+type StatsSample struct {
+	Time time.Time `json:"time"`
+	// CPU usage since the previous sample, 100 is one core fully used
+	CPUPercent float64 `json:"cpuPercent"`
+	// memory usage in bytes without the page cache
+	Memory uint64 `json:"memory"`
+	// totals since the container started
+	NetworkRx  uint64 `json:"networkRx"`
+	NetworkTx  uint64 `json:"networkTx"`
+	BlockRead  uint64 `json:"blockRead"`
+	BlockWrite uint64 `json:"blockWrite"`
+	Pids       uint64 `json:"pids"`
+}
+
+// StatsSummary summarizes the resource usage of a container during a test.
+type StatsSummary struct {
+	Samples    int     `json:"samples"`
+	CPUMean    float64 `json:"cpuMean"`
+	CPUMax     float64 `json:"cpuMax"`
+	MemoryMean uint64  `json:"memoryMean"`
+	MemoryMax  uint64  `json:"memoryMax"`
+	// totals of the last sample
+	NetworkRx  uint64 `json:"networkRx"`
+	NetworkTx  uint64 `json:"networkTx"`
+	BlockRead  uint64 `json:"blockRead"`
+	BlockWrite uint64 `json:"blockWrite"`
+}
+
+// ContainerStats is the resource usage of a container in the stats artifact.
+type ContainerStats struct {
+	Name    string        `json:"name"`
+	Summary StatsSummary  `json:"summary"`
+	Samples []StatsSample `json:"samples"`
+}
+
+// statsCollector samples the resource usage of a container in the background.
+type statsCollector struct {
+	mu      sync.Mutex
+	samples []StatsSample
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// startStats starts sampling the resource usage of the container, if enabled.
+// It keeps running until the container is removed or stopStats is called.
+func (c *Container) startStats() {
+	if c.stats == nil {
+		return
+	}
+	c.stopStats()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := c.cli.ContainerStats(ctx, c.ID, true)
+	if err != nil {
+		cancel()
+		printf("(stats ) %-25s (%s) - stats collection failure: %s", c.Name, c.ID, err.Error())
+		return
+	}
+
+	done := make(chan struct{})
+	c.stats.mu.Lock()
+	c.stats.cancel, c.stats.done = cancel, done
+	c.stats.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		defer res.Body.Close() // nolint: errcheck
+
+		dec := json.NewDecoder(res.Body)
+		for {
+			var s types.StatsJSON
+			if err := dec.Decode(&s); err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					printf("(stats ) %-25s (%s) - stats decoding failure: %s", c.Name, c.ID, err.Error())
+				}
+				return
+			}
+			// the first sample has no previous CPU usage
+			if s.PreCPUStats.SystemUsage == 0 {
+				continue
+			}
+			c.stats.mu.Lock()
+			c.stats.samples = append(c.stats.samples, newStatsSample(&s))
+			c.stats.mu.Unlock()
+		}
+	}()
+	printf("(stats ) %-25s (%s) - stats collection started", c.Name, c.ID)
+}
+
+// stopStats stops sampling and waits until the collector finished.
+func (c *Container) stopStats() {
+	if c.stats == nil {
+		return
+	}
+
+	c.stats.mu.Lock()
+	cancel, done := c.stats.cancel, c.stats.done
+	c.stats.cancel, c.stats.done = nil, nil
+	c.stats.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// newStatsSample computes a sample like `docker stats` does.
+func newStatsSample(s *types.StatsJSON) StatsSample {
+	sample := StatsSample{
+		Time:   s.Read,
+		Memory: s.MemoryStats.Usage,
+		Pids:   s.PidsStats.Current,
+	}
+
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		sample.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// the page cache is reclaimable, cgroup v1 reports it as cache, v2 as inactive_file
+	for _, key := range []string{"cache", "inactive_file"} {
+		if v, ok := s.MemoryStats.Stats[key]; ok && v < sample.Memory {
+			sample.Memory -= v
+			break
+		}
+	}
+
+	for _, n := range s.Networks {
+		sample.NetworkRx += n.RxBytes
+		sample.NetworkTx += n.TxBytes
+	}
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			sample.BlockRead += e.Value
+		case "write":
+			sample.BlockWrite += e.Value
+		}
+	}
+	return sample
+}
+
+// Stats summarizes the resource usage of the container sampled so far. Stats are
+// only collected if enabled, see SuiteOpts.CollectStats.
+func (c *Container) Stats() StatsSummary {
+	return summarize(c.statsSamples())
+}
+
+// statsSamples returns a copy of the samples collected so far.
+func (c *Container) statsSamples() []StatsSample {
+	if c.stats == nil {
+		return nil
+	}
+
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	return append([]StatsSample(nil), c.stats.samples...)
+}
+
+// summarize summarizes the given samples.
+func summarize(samples []StatsSample) StatsSummary {
+	sum := StatsSummary{Samples: len(samples)}
+	if len(samples) == 0 {
+		return sum
+	}
+
+	var cpu float64
+	var memory uint64
+	for _, s := range samples {
+		cpu += s.CPUPercent
+		memory += s.Memory
+		if s.CPUPercent > sum.CPUMax {
+			sum.CPUMax = s.CPUPercent
+		}
+		if s.Memory > sum.MemoryMax {
+			sum.MemoryMax = s.Memory
+		}
+	}
+	sum.CPUMean = cpu / float64(len(samples))
+	sum.MemoryMean = memory / uint64(len(samples))
+
+	last := samples[len(samples)-1]
+	sum.NetworkRx, sum.NetworkTx = last.NetworkRx, last.NetworkTx
+	sum.BlockRead, sum.BlockWrite = last.BlockRead, last.BlockWrite
+	return sum
+}
+
+// Stats returns the resource usage of the containers of the suite, sorted by name.
+// Stats are only collected if enabled, see SuiteOpts.CollectStats.
+func (s *Suite) Stats() []ContainerStats {
+	s.mu.Lock()
+	containers := make([]*Container, 0, len(s.containers))
+	for _, c := range s.containers {
+		containers = append(containers, c)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+	stats := make([]ContainerStats, 0, len(containers))
+	for _, c := range containers {
+		samples := c.statsSamples()
+		stats = append(stats, ContainerStats{Name: c.Name, Summary: summarize(samples), Samples: samples})
+	}
+	return stats
+}
+
+// WriteStatsJSON writes the resource usage of the containers as JSON.
+func (s *Suite) WriteStatsJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"suite": s.name, "containers": s.Stats()})
+}
+
+// WriteStatsCSV writes the samples of the resource usage of the containers as CSV.
+func (s *Suite) WriteStatsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"container", "time", "cpu_percent", "memory_bytes", "network_rx_bytes", "network_tx_bytes", "block_read_bytes", "block_write_bytes", "pids"}) // nolint: errcheck
+	for _, cs := range s.Stats() {
+		for _, sample := range cs.Samples {
+			cw.Write([]string{ // nolint: errcheck
+				cs.Name,
+				sample.Time.Format(time.RFC3339Nano),
+				strconv.FormatFloat(sample.CPUPercent, 'f', 2, 64),
+				strconv.FormatUint(sample.Memory, 10),
+				strconv.FormatUint(sample.NetworkRx, 10),
+				strconv.FormatUint(sample.NetworkTx, 10),
+				strconv.FormatUint(sample.BlockRead, 10),
+				strconv.FormatUint(sample.BlockWrite, 10),
+				strconv.FormatUint(sample.Pids, 10),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeStats stops collecting stats and writes them to <suite>.stats.json and
+// <suite>.stats.csv in the stats directory, if set.
+func (s *Suite) writeStats() error {
+	s.mu.Lock()
+	for _, c := range s.containers {
+		c.stopStats()
+	}
+	s.mu.Unlock()
+
+	if s.statsDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.statsDir, 0755); err != nil {
+		return err
+	}
+
+	base := filepath.Join(s.statsDir, invalidNameChars.ReplaceAllString(s.name, "_")+".stats")
+	for ext, write := range map[string]func(io.Writer) error{
+		".json": s.WriteStatsJSON,
+		".csv":  s.WriteStatsCSV,
+	} {
+		f, err := os.Create(base + ext)
+		if err != nil {
+			return err
+		}
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	printf("(stats ) %-25s - stats written to: %s.{json,csv}", s.name, base)
+	return nil
+}
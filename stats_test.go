@@ -0,0 +1,137 @@
+package testingdock_test
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestSuite_Stats(t *testing.T) {
+	name := "TestSuite_Stats"
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine(), StatsDir: dir})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}})
+	n.After(c)
+	s.Start(context.TODO())
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := testingdock.StatsSummary{
+		Samples:    2,
+		CPUMean:    40,
+		CPUMax:     60,
+		MemoryMean: 90 << 20,
+		MemoryMax:  100 << 20,
+		NetworkRx:  2000,
+		NetworkTx:  1000,
+	}
+	if sum := c.Stats(); sum != expected {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, name+".stats.json")); err != nil {
+		t.Errorf("JSON stats expected: %s", err.Error())
+	}
+	f, err := os.Open(filepath.Join(dir, name+".stats.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint: errcheck
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][0] != name || records[2][2] != "60.00" {
+		t.Errorf("unexpected CSV stats: %v", records)
+	}
+}
+
+func TestSuite_StatsFailedClose(t *testing.T) {
+	name := "TestSuite_StatsFailedClose"
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine(), StatsDir: dir})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:   name,
+		Config: &container.Config{Image: "fake"},
+		PreClose: func(ctx context.Context, c *testingdock.Container) error {
+			return errors.New("stuck")
+		},
+	}))
+	s.Start(context.TODO())
+
+	// the teardown failure is returned, the stats are written anyway
+	if err = s.Close(); err == nil || !strings.Contains(err.Error(), "stuck") {
+		t.Errorf("the teardown failure should be returned, got %v", err)
+	}
+	for _, ext := range []string{".stats.json", ".stats.csv"} {
+		if _, err = os.Stat(filepath.Join(dir, name+ext)); err != nil {
+			t.Errorf("stats expected: %s", err.Error())
+		}
+	}
+}
+
+func TestContainer_StatsStop(t *testing.T) {
+	name := "TestContainer_StatsStop"
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	ctx := context.TODO()
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine, StatsDir: dir})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}})
+	n.After(c)
+	s.Start(ctx)
+	defer s.Close() // nolint: errcheck
+
+	// the stats of the stopped or killed container are not collected any longer,
+	// until it is restarted
+	for _, interrupt := range []func() error{
+		func() error { return c.Stop(ctx, time.Second) },
+		func() error { return c.Kill(ctx, "SIGKILL") },
+	} {
+		fc := engine.ByName(name)
+		if fc.StatsStreams != 1 {
+			t.Fatalf("one stats stream expected, got %d", fc.StatsStreams)
+		}
+		if err = interrupt(); err != nil {
+			t.Fatal(err)
+		}
+		if fc.StatsStreams != 0 {
+			t.Errorf("the stats stream should be closed, got %d", fc.StatsStreams)
+		}
+		if err = c.Restart(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if streams := engine.ByName(name).StatsStreams; streams != 1 {
+		t.Errorf("the stats of the restarted container should be collected, got %d streams", streams)
+	}
+}
@@ -8,24 +8,44 @@
 // Run `flag.Parse()` in your test suite main function. Possible flags are:
 //  -testingdock.sequential (spawn containers sequentially instead of parallel)
 //  -testingdock.verbose (verbose logging)
+//  -testingdock.signals (tear down all suites on SIGINT/SIGTERM and exit)
+//  -testingdock.reaper (start a reaper sidecar removing everything once the test binary exits)
+//  -testingdock.parallel (maximum number of containers per suite starting at once, unlimited if 0)
+//  -testingdock.timings (print the start-up timing report of every suite)
+//  -testingdock.stats (collect the resource usage of the containers and write it to the given directory)
 package testingdock
 
 import (
 	"context"
 	"flag"
-	"testing"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/go-connections/nat"
 )
 
 func init() {
 	registry = make(map[string]*Suite)
 	flag.BoolVar(&SpawnSequential, "testingdock.sequential", false, "Spawn containers sequentially instead of parallel (useful for debugging)")
 	flag.BoolVar(&Verbose, "testingdock.verbose", false, "Verbose logging")
+	flag.BoolVar(&HandleSignals, "testingdock.signals", false, "Tear down all registered suites on SIGINT/SIGTERM and exit")
+	flag.BoolVar(&Reaper, "testingdock.reaper", false, "Start a reaper sidecar container, which removes all resources once the test binary exits")
+	flag.IntVar(&MaxParallel, "testingdock.parallel", 0, "Maximum number of containers per suite starting at once, unlimited if 0")
+	flag.BoolVar(&PrintTimings, "testingdock.timings", false, "Print the start-up timing report of every suite")
+	flag.StringVar(&StatsDir, "testingdock.stats", "", "Collect the resource usage of all containers and write it to the given directory")
 }
 
-var registry map[string]*Suite
+var (
+	// registryMu guards registry, suites may be created from parallel tests
+	registryMu sync.Mutex
+	registry   map[string]*Suite
+)
 
 // SpawnSequential controls whether to spawn child containers in parallel
 // or sequentially. This doesn't spawn
@@ -41,78 +61,431 @@ var SpawnSequential bool
 // Verbose logging
 var Verbose bool
 
+// DefaultTeardownTimeout is the time given to Suite.Close to remove all
+// containers and networks of a suite, if SuiteOpts.TeardownTimeout is not set.
+const DefaultTeardownTimeout = 30 * time.Second
+
 // SuiteOpts is an option struct for getting or creating a suite in GetOrCreateSuite.
 type SuiteOpts struct {
 	// optional docker client, if one already exists
-	Client *client.Client
-	// whether to fail on instantiation errors
+	Client client.APIClient
+	// whether to skip instead of fail on instantiation errors, if the
+	// reporter can skip like testing.T
 	Skip bool
+	// time given to Close to remove all containers and networks,
+	// default is DefaultTeardownTimeout
+	TeardownTimeout time.Duration
+	// whether to keep the suite registered once the tests which got it
+	// finished, e.g. if later tests get the same suite. By default it is
+	// closed and unregistered via t.Cleanup once the last running test which
+	// got it finishes, kept suites have to be closed via Close or UnregisterAll.
+	KeepOpen bool
+	// whether to prefix the docker names of all containers and networks
+	// with an ID unique to the test binary run and the suite, so that
+	// packages running in parallel do not remove each others containers.
+	// Containers stay reachable under their logical name within the network.
+	// Reused containers and networks are only prefixed with the suite name,
+	// so they can be adopted by the next run.
+	IsolateNames bool
+	// whether to share the suite with other test binaries on the same host,
+	// e.g. the other packages of `go test ./...`. The first one starts the
+	// suite, later ones attach to the running containers and the last one
+	// tears it down. Containers and networks are adopted like reused ones,
+	// so all users have to configure the suite identically, starting fails
+	// otherwise. References of crashed test binaries are ignored.
+	Shared bool
+	// whether to reuse all containers, networks and volumes of the suite, as if
+	// Reuse was set in all of their options. They are kept after Close and are
+	// neither removed by the reaper nor by Prune, but by Remove.
+	Reuse bool
+	// default CPU, memory and pids limits of the containers, e.g. ResourcesSmall,
+	// used for the limits neither set in ContainerOpts.Resources nor in the HostConfig
+	Resources *Resources
+	// how many containers may be starting and health checking at once,
+	// default is MaxParallel
+	MaxParallel int
+	// whether to sample the resource usage of the containers, see Container.Stats
+	CollectStats bool
+	// directory the resource usage is written to as JSON and CSV when the
+	// suite closes, default is StatsDir. Setting it enables CollectStats.
+	StatsDir string
+}
+
+// Reporter reports the failures of a suite. *testing.T and *testing.B
+// implement it, as do reporters of programs using suites outside of tests,
+// e.g. the testingdock command. Fatalf must not return, e.g. it calls
+// runtime.Goexit like testing.T does, or exits the program.
+type Reporter interface {
+	Logf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+	// Cleanup registers a function called when the test finished, programs
+	// closing their suites themselves can ignore it
+	Cleanup(func())
 }
 
 // Suite represents a testing suite with a docker setup.
 type Suite struct {
-	name       string
-	t          testing.TB
-	cli        *client.Client
-	network    *Network
-	logWatcher *logger.LogWatcher
+	name            string
+	t               Reporter
+	cli             client.APIClient
+	network         *Network
+	logWatcher      *logger.LogWatcher
+	teardownTimeout time.Duration
+	// prefixes of the docker names, empty unless names are isolated
+	prefix, reusePrefix string
+	// whether the suite is shared and whether all of its resources are reused
+	shared, reuse bool
+	// mu guards network, containers, volumes and logWatcher
+	mu         sync.Mutex
+	containers map[string]*Container
+	volumes    []*Volume
+	// default resources of the containers
+	resources *Resources
+	// limits the number of containers starting at once, nil if unlimited
+	sem chan struct{}
+	// start time of the suite, guarded by mu, and its phases
+	started time.Time
+	timings timings
+	// whether to collect stats and where to write them, if set
+	collectStats bool
+	statsDir     string
+	// snapshots taken by name and the number of snapshots taken, which tells
+	// their images and volume copies apart, guarded by mu
+	snapshots   map[string]*snapshot
+	snapshotSeq int
+	// whether the suite is kept open and the number of running tests which
+	// got it otherwise, guarded by registryMu
+	keepOpen bool
+	refs     int
 }
 
 // GetOrCreateSuite returns a suite with the given name. If such suite is not registered yet it creates it.
 // Returns true if the suite was already there, otherwise false.
-func GetOrCreateSuite(t testing.TB, name string, opts SuiteOpts) (*Suite, bool) {
+func GetOrCreateSuite(t Reporter, name string, opts SuiteOpts) (*Suite, bool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
 	if s, ok := registry[name]; ok {
+		s.hold(t)
 		return s, true
 	}
 
 	c := opts.Client
 	if c == nil {
 		var err error
-		c, err = client.NewClientWithOpts(client.FromEnv)
+		c, err = NewClient()
 		if err != nil {
-			if opts.Skip {
-				t.Skipf("docker client instantiation failure: %s", err.Error())
+			if skipper, ok := t.(interface {
+				Skipf(format string, args ...interface{})
+			}); ok && opts.Skip {
+				skipper.Skipf("docker client instantiation failure: %s", err.Error())
 			} else {
 				t.Fatalf("docker client instantiation failure: %s", err.Error())
 			}
 		}
 	}
 
+	if opts.TeardownTimeout == 0 { // zero value
+		opts.TeardownTimeout = DefaultTeardownTimeout
+	}
+	if opts.MaxParallel == 0 {
+		opts.MaxParallel = MaxParallel
+	}
+	if opts.StatsDir == "" {
+		opts.StatsDir = StatsDir
+	}
+
 	s := &Suite{
-		cli:  c,
-		t:    t,
-		name: name,
+		cli:             c,
+		t:               t,
+		name:            name,
+		teardownTimeout: opts.TeardownTimeout,
+		containers:      make(map[string]*Container),
+		shared:          opts.Shared,
+		reuse:           opts.Reuse,
+		resources:       opts.Resources,
+		collectStats:    opts.CollectStats || opts.StatsDir != "",
+		statsDir:        opts.StatsDir,
+		snapshots:       make(map[string]*snapshot),
+	}
+	if opts.MaxParallel > 0 {
+		s.sem = make(chan struct{}, opts.MaxParallel)
+	}
+	if opts.IsolateNames {
+		s.prefix = isolatedPrefix(name, session.id[:8])
+		s.reusePrefix = isolatedPrefix(name, "")
+	}
+	if opts.Shared {
+		// all processes have to agree on the names
+		s.prefix = s.reusePrefix
 	}
 	registry[s.name] = s
+
+	s.keepOpen = opts.KeepOpen
+	s.hold(t)
+	if HandleSignals {
+		handleSignals()
+	}
+
 	return s, false
 }
 
 // UnregisterAll unregisters all suites by closing the networks.
 func UnregisterAll() {
 	printf("(unregi) start")
+
+	registryMu.Lock()
+	suites := make(map[string]*Suite, len(registry))
 	for name, reg := range registry {
+		suites[name] = reg
+	}
+	registryMu.Unlock()
+
+	for name, reg := range suites {
+		unregister(name, reg)
+	}
+	printf("(unregi) finished")
+}
 
-		if err := reg.Close(); err != nil {
-			printf("(unregi) %-25s (%-64s) - suite unregister failure: %s", name, "", err.Error())
-		} else {
-			printf("(unregi) %-25s (%-64s) - suite unregistered", name, "")
+// hold keeps the suite open until the given test finished, unless the suite is
+// kept open anyway. The suite is closed and unregistered once the last running
+// test holding it finished, so that tests sharing it, e.g. parallel ones, don't
+// close it under each other. registryMu has to be held.
+func (s *Suite) hold(t Reporter) {
+	if s.keepOpen {
+		return
+	}
+	s.refs++
+	t.Cleanup(func() {
+		registryMu.Lock()
+		s.refs--
+		last := s.refs == 0 && registry[s.name] == s
+		if last {
+			delete(registry, s.name)
 		}
+		registryMu.Unlock()
+		if last {
+			closeSuite(s.name, s)
+		}
+	})
+}
+
+// unregister closes the given suite and removes it from the registry.
+// The registry is not locked while closing, as that may take a while.
+// It is removed first, so that later callers of GetOrCreateSuite get a new suite
+// instead of the closing one.
+func unregister(name string, s *Suite) {
+	registryMu.Lock()
+	if registry[name] == s {
 		delete(registry, name)
 	}
-	printf("(unregi) finished")
+	registryMu.Unlock()
+
+	closeSuite(name, s)
+}
+
+// closeSuite closes the given unregistered suite and logs the failures.
+func closeSuite(name string, s *Suite) {
+	if err := s.Close(); err != nil {
+		printf("(unregi) %-25s (%-64s) - suite unregister failure: %s", name, "", err.Error())
+	} else {
+		printf("(unregi) %-25s (%-64s) - suite unregistered", name, "")
+	}
 }
 
 // Container creates a new docker container configuration with the given options.
 func (s *Suite) Container(opts ContainerOpts) *Container {
-	return newContainer(s.t, s.cli, opts)
+	if s.shared || s.reuse {
+		opts.Reuse = true
+	}
+	opts.Resources = opts.Resources.withDefaults(s.resources, opts.HostConfig)
+	c := newContainer(s.t, s.cli, opts)
+	c.sem = s.sem
+	c.shared = s.shared
+	if s.collectStats {
+		c.stats = &statsCollector{}
+	}
+	c.dockerName = s.dockerName(c.Name, opts.Reuse)
+	if s.shared {
+		c.ccfg.Labels = createSharedLabel(s.name)
+	}
+	c.ccfg.Labels[LabelSuite] = s.name
+
+	s.mu.Lock()
+	s.containers[c.Name] = c
+	s.mu.Unlock()
+
+	return c
+}
+
+// Lookup returns the container with the given logical name, as passed
+// in ContainerOpts, and whether it exists in the suite.
+func (s *Suite) Lookup(name string) (*Container, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.containers[name]
+	return c, ok
+}
+
+// Host returns the host under which the ports published by the containers of
+// the suite are reachable from the tests, e.g. "localhost", see Container.HostAddr.
+func (s *Suite) Host() string {
+	return daemonHostname(s.cli)
+}
+
+// T returns the reporter the suite was created with, e.g. to pick a port with
+// RandomPort before the containers are created.
+func (s *Suite) T() Reporter {
+	return s.t
+}
+
+// Env returns the connection details of the started containers of the suite as
+// sorted KEY=value environment variables, for processes launched from tests:
+//
+//	TESTINGDOCK_NETWORK, TESTINGDOCK_GATEWAY, TESTINGDOCK_SUBNET
+//	<NAME>_IP           IP address in the network of the suite
+//	<NAME>_HOST         host name of the docker host
+//	<NAME>_PORT         first published port on the docker host
+//	<NAME>_PORT_<port>  published port of the container port, "_UDP" is appended for udp
+//
+// NAME is the upper-cased name of the container with invalid characters replaced by "_".
+func (s *Suite) Env(ctx context.Context) []string {
+	var env []string
+	if n := s.getNetwork(); n != nil && n.id != "" {
+		env = append(env,
+			"TESTINGDOCK_NETWORK="+n.dockerName,
+			"TESTINGDOCK_GATEWAY="+n.gateway,
+			"TESTINGDOCK_SUBNET="+n.subnet,
+		)
+	}
+
+	s.mu.Lock()
+	containers := make([]*Container, 0, len(s.containers))
+	for _, c := range s.containers {
+		containers = append(containers, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range containers {
+		if c.ID == "" {
+			continue
+		}
+		prefix := strings.ToUpper(invalidEnvChars.ReplaceAllString(c.Name, "_"))
+
+		ip, err := c.IP(ctx)
+		if err != nil {
+			s.t.Fatalf("container inspection failure: %s", err.Error())
+		}
+		ports, err := c.Ports(ctx)
+		if err != nil {
+			s.t.Fatalf("container inspection failure: %s", err.Error())
+		}
+		env = append(env, prefix+"_IP="+ip, prefix+"_HOST="+s.Host())
+
+		published := make([]nat.Port, 0, len(ports))
+		for port := range ports {
+			published = append(published, port)
+		}
+		nat.Sort(published, func(a, b nat.Port) bool {
+			return a.Int() < b.Int() || a.Int() == b.Int() && a.Proto() < b.Proto()
+		})
+		for i, port := range published {
+			if i == 0 {
+				env = append(env, prefix+"_PORT="+ports[port])
+			}
+			key := prefix + "_PORT_" + port.Port()
+			if port.Proto() == "udp" {
+				key += "_UDP"
+			}
+			env = append(env, key+"="+ports[port])
+		}
+	}
+
+	sort.Strings(env)
+	return env
 }
 
+// invalidEnvChars matches characters not allowed in environment variable names.
+var invalidEnvChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
 // Network creates a new docker network configuration with the given options.
 func (s *Suite) Network(opts NetworkOpts) *Network {
-	s.network = newNetwork(s.t, s.cli, opts)
+	if s.shared || s.reuse {
+		opts.Reuse = true
+	}
+	n := newNetwork(s.t, s.cli, opts, s.teardownTimeout)
+	n.dockerName = s.dockerName(n.name, opts.Reuse)
+	if s.shared {
+		n.labels = createSharedLabel(s.name)
+	}
+	n.labels[LabelSuite] = s.name
+
+	s.mu.Lock()
+	s.network = n
+	s.mu.Unlock()
+
+	return n
+}
+
+// Volume creates a new docker volume configuration with the given options.
+// The volume is created when the suite starts and removed when it closes.
+func (s *Suite) Volume(opts VolumeOpts) *Volume {
+	if s.shared || s.reuse {
+		opts.Reuse = true
+	}
+	v := newVolume(s.t, s.cli, opts)
+	v.dockerName = s.dockerName(v.name, opts.Reuse)
+	if s.shared {
+		v.labels = createSharedLabel(s.name)
+	}
+	v.labels[LabelSuite] = s.name
+
+	s.mu.Lock()
+	s.volumes = append(s.volumes, v)
+	s.mu.Unlock()
+
+	return v
+}
+
+// getVolumes returns the volumes of the suite.
+func (s *Suite) getVolumes() []*Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Volume(nil), s.volumes...)
+}
+
+// getNetwork returns the network of the suite, if any.
+func (s *Suite) getNetwork() *Network {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.network
 }
 
+// dockerName returns the docker name of a container or network.
+func (s *Suite) dockerName(name string, reuse bool) string {
+	if reuse {
+		return s.reusePrefix + name
+	}
+	return s.prefix + name
+}
+
+// isolatedPrefix returns the docker name prefix of the given suite, which is
+// unique to the given session, if any.
+func isolatedPrefix(suite, session string) string {
+	prefix := "td_"
+	if session != "" {
+		prefix += session + "_"
+	}
+	return prefix + invalidNameChars.ReplaceAllString(suite, "_") + "_"
+}
+
+// invalidNameChars matches characters not allowed in docker container and network names.
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
 // Reset "resets" the underlying docker containers in the network. This
 // calls the ResetFunc and HealthCheckFunc for each of them. These can be passed in
 // ContainerOpts when creating a container.
@@ -121,43 +494,155 @@ func (s *Suite) Network(opts NetworkOpts) *Network {
 // implicitly to HealthCheckFunc where it may cancel the blocking health
 // check loop.
 func (s *Suite) Reset(ctx context.Context) {
-	if s.network != nil {
-		s.network.reset(ctx)
+	if n := s.getNetwork(); n != nil {
+		n.reset(ctx)
 	}
 }
 
 // Start starts the suite. This starts all networks in the suite and the underlying containers,
 // as well as the daemon logger, if Verbosity is enabled.
 func (s *Suite) Start(ctx context.Context) {
+	s.mu.Lock()
 	if s.logWatcher == nil && Verbose {
 		printf("(daemon) starting logging")
 		s.logWatcher = logger.NewLogWatcher()
-		go func() {
+		go func(lw *logger.LogWatcher) {
 			for {
 				select {
 				case <-ctx.Done():
 					printf("(daemon) stopping logging")
 					//s.logWatcher.Close()
 					return
-				case msg := <-s.logWatcher.Msg:
+				case msg := <-lw.Msg:
 					printf("(daemon) %s", msg.Line)
-				case err := <-s.logWatcher.Err:
+				case err := <-lw.Err:
 					printf("(d err ) %s", err)
 				}
 			}
-		}()
+		}(s.logWatcher)
+	}
+	s.mu.Unlock()
+
+	if Reaper {
+		startReaper(ctx, s.t, s.cli)
 	}
 
-	if s.network != nil {
-		s.network.start(ctx)
+	if s.shared {
+		s.startShared(ctx)
+	} else {
+		s.start(ctx)
 	}
 }
 
+// start creates the volumes and starts the network.
+func (s *Suite) start(ctx context.Context) {
+	now := time.Now()
+	s.mu.Lock()
+	s.started = now
+	s.mu.Unlock()
+
+	if volumes := s.getVolumes(); len(volumes) > 0 {
+		for _, v := range volumes {
+			v.start(ctx)
+		}
+		s.timings.record("volumes", now)
+	}
+	n := s.getNetwork()
+	if n == nil {
+		return
+	}
+	n.start(ctx)
+
+	s.timings.finish()
+	n.timings.finish()
+	walk(n.children, func(c *Container) {
+		c.timings.finish()
+	})
+	if PrintTimings {
+		s.printTimings()
+	}
+}
+
+// Attach makes the network and the containers of the suite refer to the docker
+// network and containers started earlier, e.g. by another process, without
+// creating anything. Afterwards the suite can be reset or inspected, but closing
+// it does not remove anything.
+func (s *Suite) Attach(ctx context.Context) error {
+	n := s.getNetwork()
+	if n == nil {
+		return nil
+	}
+
+	networks, err := findNetworkByName(ctx, s.cli, n.dockerName)
+	if err != nil {
+		return fmt.Errorf("network listing failure: %s", err.Error())
+	}
+	if len(networks) == 0 {
+		return fmt.Errorf("network %s does not exist", n.dockerName)
+	}
+	n.id = networks[0].ID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.containers {
+		containers, err := findContainerByName(ctx, s.cli, c.dockerName)
+		if err != nil {
+			return fmt.Errorf("container listing failure: %s", err.Error())
+		}
+		if len(containers) == 0 {
+			return fmt.Errorf("container %s does not exist", c.dockerName)
+		}
+		c.ID = containers[0].ID
+	}
+
+	return nil
+}
+
+// Remove removes all docker containers, networks and volumes labelled with the name of
+// the suite, including reused ones and ones left behind by other processes.
+func (s *Suite) Remove(ctx context.Context) error {
+	return removeByLabel(ctx, s.cli, LabelSuite+"="+s.name)
+}
+
 // Close stops the suites. This stops all networks in the suite and the underlying containers.
+// The teardown runs on its own context bounded by SuiteOpts.TeardownTimeout, independent
+// of the context passed to Start.
+// Implements io.Closer interface.
 func (s *Suite) Close() error {
-	if s.network != nil {
-		return s.network.close()
+	ctx, cancel := context.WithTimeout(context.Background(), s.teardownTimeout)
+	defer cancel()
+
+	return s.CloseContext(ctx)
+}
+
+// CloseContext is like Close, but removes the containers and networks using the
+// given context, which gives the caller explicit control over the teardown deadline.
+func (s *Suite) CloseContext(ctx context.Context) error {
+	var err error
+	if s.shared {
+		err = s.closeShared(ctx)
+	} else {
+		err = s.close(ctx)
 	}
+	// the stats are written even if the teardown failed, e.g. to find out
+	// why a container did not stop
+	return joinErrors([]error{err, s.writeStats()})
+}
 
-	return nil
+// close stops the network and removes the snapshots and volumes afterwards,
+// which can only be removed once no container uses them anymore.
+func (s *Suite) close(ctx context.Context) error {
+	var errs []error
+	if n := s.getNetwork(); n != nil {
+		errs = append(errs, n.close(ctx))
+	}
+	s.removeSnapshots(ctx)
+	for _, v := range s.getVolumes() {
+		if err := v.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return joinErrors(errs)
 }
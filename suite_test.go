@@ -2,11 +2,23 @@ package testingdock_test
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
 
 	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
 )
 
 func TestMain(m *testing.M) {
@@ -35,3 +47,436 @@ func TestGetOrCreateSuite(t *testing.T) {
 
 	testingdock.UnregisterAll()
 }
+
+func TestSuite_CloseAfterStartContextCancelled(t *testing.T) {
+	name := "TestSuite_CloseAfterStartContextCancelled"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	cancel()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close the suite after the start context was cancelled: %s", err.Error())
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("everything should be removed, got %d containers and %d networks", containers, networks)
+	}
+}
+
+func TestGetOrCreateSuite_Cleanup(t *testing.T) {
+	name := "TestGetOrCreateSuite_Cleanup"
+	t.Run("create", func(t *testing.T) {
+		if _, ok := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()}); ok {
+			t.Fatal("this suite should not exists yet")
+		}
+	})
+	if _, ok := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine(), KeepOpen: true}); ok {
+		t.Error("suite should have been unregistered by the cleanup of the test which created it")
+	}
+
+	// the suite stays open while a test which got it is still running, even
+	// if another one which got it finished
+	t.Run("share", func(t *testing.T) {
+		s, _ := testingdock.GetOrCreateSuite(t, name+"_Share", testingdock.SuiteOpts{Client: fake.NewEngine()})
+		t.Run("get", func(t *testing.T) {
+			if _, ok := testingdock.GetOrCreateSuite(t, name+"_Share", testingdock.SuiteOpts{}); !ok {
+				t.Error("suite should be registered")
+			}
+		})
+		if got, ok := testingdock.GetOrCreateSuite(t, name+"_Share", testingdock.SuiteOpts{}); !ok || got != s {
+			t.Error("suite should still be registered after the test which got it finished")
+		}
+	})
+	if _, ok := testingdock.GetOrCreateSuite(t, name+"_Share", testingdock.SuiteOpts{Client: fake.NewEngine()}); ok {
+		t.Error("suite should have been unregistered once all tests which got it finished")
+	}
+
+	// kept suites outlive the test which created them
+	t.Run("keep", func(t *testing.T) {
+		testingdock.GetOrCreateSuite(t, name+"_Keep", testingdock.SuiteOpts{Client: fake.NewEngine(), KeepOpen: true})
+	})
+	s, ok := testingdock.GetOrCreateSuite(t, name+"_Keep", testingdock.SuiteOpts{Client: fake.NewEngine()})
+	if !ok {
+		t.Error("suite should still be registered")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSuite_IsolateNames(t *testing.T) {
+	name := "TestSuite_IsolateNames"
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{IsolateNames: true})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{
+		Name:   "postgres",
+		Config: &container.Config{Image: "postgres:9.6"},
+	})
+
+	if c.DockerName() == c.Name || !strings.HasSuffix(c.DockerName(), "_postgres") {
+		t.Errorf("container name should be prefixed, got: %s", c.DockerName())
+	}
+	if n.DockerName() == name || !strings.HasSuffix(n.DockerName(), "_"+name) {
+		t.Errorf("network name should be prefixed, got: %s", n.DockerName())
+	}
+	if !strings.Contains(c.DockerName(), testingdock.SessionID()[:8]) {
+		t.Errorf("container name should contain the session ID, got: %s", c.DockerName())
+	}
+	if cc, ok := s.Lookup("postgres"); !ok || cc != c {
+		t.Error("container should be found by its logical name")
+	}
+}
+
+func TestGetOrCreateSuite_Parallel(t *testing.T) {
+	engine := fake.NewEngine()
+
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			i := i
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				t.Parallel()
+
+				// all tests share this one
+				testingdock.GetOrCreateSuite(t, "TestGetOrCreateSuite_Parallel", testingdock.SuiteOpts{Client: engine})
+
+				name := fmt.Sprintf("TestGetOrCreateSuite_Parallel_%d", i)
+				s, ok := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+				if ok {
+					t.Fatal("this suite should not exists yet")
+				}
+				n := s.Network(testingdock.NetworkOpts{Name: name})
+				c1 := s.Container(testingdock.ContainerOpts{Name: name + "_1", Config: &container.Config{Image: "fake"}})
+				c2 := s.Container(testingdock.ContainerOpts{Name: name + "_2", Config: &container.Config{Image: "fake"}})
+				n.After(c1)
+				n.After(c2)
+
+				s.Start(context.TODO())
+				s.Reset(context.TODO())
+
+				// closing concurrently must only tear down once
+				var wg sync.WaitGroup
+				wg.Add(2)
+				for j := 0; j < 2; j++ {
+					go func() {
+						defer wg.Done()
+						if err := s.Close(); err != nil {
+							t.Errorf("close failure: %s", err.Error())
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	})
+
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("expected all resources to be removed, got %d containers and %d networks", containers, networks)
+	}
+}
+
+func TestSuite_Shared(t *testing.T) {
+	name := "TestSuite_Shared"
+	engine := fake.NewEngine()
+
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine, Shared: true})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}}))
+	s.Start(context.TODO())
+
+	// another test binary attaches to the suite
+	if _, err := engine.VolumeCreate(context.TODO(), volume.VolumeCreateBody{
+		Name:   "other",
+		Labels: map[string]string{"owner": "testingdock", "testingdock.ref": name},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failure: %s", err.Error())
+	}
+	if containers, networks := engine.Counts(); containers != 1 || networks != 1 {
+		t.Fatalf("suite should be kept while still referenced, got %d containers and %d networks", containers, networks)
+	}
+
+	// the other test binary is done
+	if err := engine.VolumeRemove(context.TODO(), "other", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failure: %s", err.Error())
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("suite should be removed by its last user, got %d containers and %d networks", containers, networks)
+	}
+	if volumes := engine.VolumeCount(); volumes != 0 {
+		t.Errorf("references should be removed, got %d volumes", volumes)
+	}
+}
+
+func TestSuite_SharedDeadRef(t *testing.T) {
+	name := "TestSuite_SharedDeadRef"
+	engine := fake.NewEngine()
+
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine, Shared: true})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}}))
+	s.Start(context.TODO())
+
+	// a test binary on this host crashed without removing its reference
+	host, _ := os.Hostname() // nolint: errcheck
+	if _, err := engine.VolumeCreate(context.TODO(), volume.VolumeCreateBody{
+		Name: "crashed",
+		Labels: map[string]string{
+			"owner":               "testingdock",
+			"testingdock.ref":     name,
+			"testingdock.session": "crashed",
+			"testingdock.host":    host,
+			"testingdock.pid":     strconv.Itoa(1 << 30),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failure: %s", err.Error())
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("suite should be removed despite the dead reference, got %d containers and %d networks", containers, networks)
+	}
+	if volumes := engine.VolumeCount(); volumes != 0 {
+		t.Errorf("dead reference should be removed, got %d volumes", volumes)
+	}
+}
+
+func TestSuite_SharedMismatch(t *testing.T) {
+	name := "TestSuite_SharedMismatch"
+	engine := fake.NewEngine()
+	start := func(tb testing.TB, env string) *testingdock.Suite {
+		s, _ := testingdock.GetOrCreateSuite(tb, name, testingdock.SuiteOpts{Client: engine, Shared: true})
+		n := s.Network(testingdock.NetworkOpts{Name: name})
+		n.After(s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake", Env: []string{env}}}))
+		s.Start(context.TODO())
+		return s
+	}
+
+	// another test binary keeps using the suite started by the first one
+	if _, err := engine.VolumeCreate(context.TODO(), volume.VolumeCreateBody{
+		Name:   "other",
+		Labels: map[string]string{"owner": "testingdock", "testingdock.ref": name},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("first", func(t *testing.T) {
+		start(t, "MODE=a")
+	})
+	id := engine.ByName(name).ID
+
+	msg := expectFatal(t, func(tb testing.TB) {
+		start(tb, "MODE=b")
+	})
+	if !strings.Contains(msg, "different configuration") {
+		t.Errorf("unexpected failure: %s", msg)
+	}
+	if c := engine.ByName(name); c == nil || c.ID != id {
+		t.Error("container used by the other test binary should be kept")
+	}
+	testingdock.UnregisterAll()
+}
+
+func TestSuite_AttachRemove(t *testing.T) {
+	name := "TestSuite_AttachRemove"
+	engine := fake.NewEngine()
+
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}})
+	n.After(c)
+	s.Start(context.TODO())
+
+	// another process describes the same suite
+	registered, _ := testingdock.GetOrCreateSuite(t, name+"_other", testingdock.SuiteOpts{Client: engine})
+	if err := registered.ApplySpec(s.Spec()); err != nil {
+		t.Fatal(err)
+	}
+	if err := registered.Attach(context.TODO()); err != nil {
+		t.Fatalf("attach failure: %s", err.Error())
+	}
+	if cc, _ := registered.Lookup(name); cc.ID != c.ID {
+		t.Errorf("attached to wrong container: %s", cc.ID)
+	}
+
+	// closing an attached suite keeps everything, removing the suite does not
+	if err := registered.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if containers, networks := engine.Counts(); containers != 1 || networks != 1 {
+		t.Fatalf("attached suite should not remove anything on close, got %d containers and %d networks", containers, networks)
+	}
+	if err := s.Remove(context.TODO()); err != nil {
+		t.Fatalf("remove failure: %s", err.Error())
+	}
+	if containers, networks := engine.Counts(); containers != 0 || networks != 0 {
+		t.Errorf("expected all resources to be removed, got %d containers and %d networks", containers, networks)
+	}
+}
+
+func TestSuite_Env(t *testing.T) {
+	name := "TestSuite_Env"
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{
+		Name:   "db-1",
+		Config: &container.Config{Image: "fake"},
+		HostConfig: &container.HostConfig{
+			PortBindings: nat.PortMap{
+				"5432/tcp": {{HostPort: "15432"}},
+				"53/udp":   {{}},
+			},
+		},
+	})
+	n.After(c)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	ip, err := c.IP(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := c.HostAddr(context.TODO(), "5432/tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "localhost:15432" {
+		t.Errorf("unexpected host address: %s", addr)
+	}
+	ports, err := c.Ports(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 2 || ports["53/udp"] == "" {
+		t.Errorf("unexpected ports: %v", ports)
+	}
+
+	expected := []string{
+		"DB_1_HOST=localhost",
+		"DB_1_IP=" + ip,
+		"DB_1_PORT=" + ports["53/udp"],
+		"DB_1_PORT_53_UDP=" + ports["53/udp"],
+		"DB_1_PORT_5432=15432",
+		"TESTINGDOCK_GATEWAY=" + n.Gateway(),
+		"TESTINGDOCK_NETWORK=" + n.DockerName(),
+		"TESTINGDOCK_SUBNET=172.30.0.0/16",
+	}
+	if env := s.Env(context.TODO()); !reflect.DeepEqual(env, expected) {
+		t.Errorf("unexpected environment:\n%v\nexpected:\n%v", env, expected)
+	}
+}
+
+func TestSuite_Resources(t *testing.T) {
+	name := "TestSuite_Resources"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{
+		Client:      engine,
+		Resources:   &testingdock.ResourcesSmall,
+		MaxParallel: 1,
+	})
+
+	// count the containers between creation and passing the health check
+	var (
+		mu               sync.Mutex
+		starting, maxNum int
+	)
+	created := func(ctx context.Context, c *testingdock.Container) error {
+		mu.Lock()
+		defer mu.Unlock()
+		starting++
+		if starting > maxNum {
+			maxNum = starting
+		}
+		return nil
+	}
+	healthy := func(ctx context.Context, c *testingdock.Container) error {
+		mu.Lock()
+		defer mu.Unlock()
+		starting--
+		return nil
+	}
+
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:        "a",
+		Config:      &container.Config{Image: "fake"},
+		Resources:   &testingdock.Resources{Memory: 1 << 30},
+		PostCreate:  created,
+		PostHealthy: healthy,
+	}))
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:        "b",
+		Config:      &container.Config{Image: "fake"},
+		HostConfig:  &container.HostConfig{Resources: container.Resources{NanoCPUs: 2e9}},
+		PostCreate:  created,
+		PostHealthy: healthy,
+	}))
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:        "c",
+		Config:      &container.Config{Image: "fake"},
+		HostConfig:  &container.HostConfig{Resources: container.Resources{CPUQuota: 50000, CPUPeriod: 100000}},
+		Resources:   &testingdock.Resources{CPUs: 1},
+		PostCreate:  created,
+		PostHealthy: healthy,
+	}))
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	if maxNum != 1 {
+		t.Errorf("expected one container starting at once, got %d", maxNum)
+	}
+	a, b := engine.ByName("a").HostConfig, engine.ByName("b").HostConfig
+	if a.Memory != 1<<30 || a.NanoCPUs != 5e8 || *a.PidsLimit != 256 {
+		t.Errorf("unexpected limits of a: memory %d, cpus %d, pids %d", a.Memory, a.NanoCPUs, *a.PidsLimit)
+	}
+	if b.Memory != 256<<20 || b.NanoCPUs != 2e9 || *b.PidsLimit != 256 {
+		t.Errorf("unexpected limits of b: memory %d, cpus %d, pids %d", b.Memory, b.NanoCPUs, *b.PidsLimit)
+	}
+	// docker refuses containers limited by both
+	if c := engine.ByName("c").HostConfig; c.NanoCPUs != 1e9 || c.CPUQuota != 0 || c.CPUPeriod != 0 {
+		t.Errorf("unexpected cpu limits of c: cpus %d, quota %d, period %d", c.NanoCPUs, c.CPUQuota, c.CPUPeriod)
+	}
+}
+
+func TestSuite_MaxParallelFailure(t *testing.T) {
+	name := "TestSuite_MaxParallelFailure"
+	engine := fake.NewEngine()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the slow image lets the broken container take the only slot first
+	var s *testingdock.Suite
+	msg := expectFatal(t, func(tb testing.TB) {
+		s, _ = testingdock.GetOrCreateSuite(tb, name, testingdock.SuiteOpts{Client: engine, MaxParallel: 1})
+		n := s.Network(testingdock.NetworkOpts{Name: name})
+		n.After(s.Container(testingdock.ContainerOpts{
+			Name:   "broken",
+			Config: &container.Config{Image: "fake"},
+			HealthCheck: func(ctx context.Context, c *testingdock.Container) error {
+				return errors.New("unhealthy")
+			},
+			HealthCheckTimeout: 10 * time.Millisecond,
+		}))
+		n.After(s.Container(testingdock.ContainerOpts{Name: "sibling", Config: &container.Config{Image: fake.SlowImage}}))
+		s.Start(ctx)
+	})
+	defer s.Close() // nolint: errcheck
+
+	if !strings.Contains(msg, "health check failure") {
+		t.Errorf("unexpected failure: %s", msg)
+	}
+	if c := engine.ByName("sibling"); c == nil || !c.Running {
+		t.Error("the sibling should start once the broken container failed")
+	}
+}
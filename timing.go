@@ -0,0 +1,297 @@
+package testingdock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// PrintTimings controls whether to print the timing report of every suite once
+// it started, see Suite.Timings.
+var PrintTimings bool
+
+// Timings is the start-up timing report of a suite.
+type Timings struct {
+	Suite string    `json:"suite"`
+	Start time.Time `json:"start"`
+	// time from the start until all containers passed their health checks
+	Total time.Duration `json:"total"`
+	// phases of the suite itself, i.e. creating the volumes and the network
+	Phases     []Phase            `json:"phases"`
+	Containers []ContainerTimings `json:"containers"`
+	// names of the containers on the critical path, which determines the
+	// start-up time, starting with the network
+	CriticalPath []string `json:"criticalPath"`
+}
+
+// ContainerTimings are the start-up timings of a container.
+type ContainerTimings struct {
+	Name string `json:"name"`
+	// name of the parent container, empty if the container is started after the network
+	After string   `json:"after,omitempty"`
+	Needs []string `json:"needs,omitempty"`
+	// e.g. resolve, pull, create, start, health check and children
+	Phases []Phase `json:"phases"`
+	// time from the start of the suite until the container passed its health check
+	Ready time.Duration `json:"ready"`
+}
+
+// Phase is a step of starting a suite or container.
+type Phase struct {
+	Name string `json:"name"`
+	// time from the start of the suite
+	Start    time.Duration `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// timings records the phases of starting a suite, network or container.
+type timings struct {
+	mu     sync.Mutex
+	phases []timedPhase
+	ready  time.Time
+	// set once started, later phases, e.g. of a restart, are not recorded
+	done bool
+}
+
+type timedPhase struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// record records a phase, which started at the given time and ends now.
+func (tm *timings) record(name string, start time.Time) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if !tm.done {
+		tm.phases = append(tm.phases, timedPhase{name: name, start: start, end: time.Now()})
+	}
+}
+
+// markReady records the time the network or container got ready.
+func (tm *timings) markReady() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if !tm.done {
+		tm.ready = time.Now()
+	}
+}
+
+// finish stops recording phases.
+func (tm *timings) finish() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.done = true
+}
+
+// relative returns the phases relative to the given start and the ready time.
+func (tm *timings) relative(start time.Time) ([]Phase, time.Duration) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	phases := make([]Phase, 0, len(tm.phases))
+	for _, p := range tm.phases {
+		phases = append(phases, Phase{Name: p.name, Start: p.start.Sub(start), Duration: p.end.Sub(p.start)})
+	}
+	var ready time.Duration
+	if !tm.ready.IsZero() {
+		ready = tm.ready.Sub(start)
+	}
+	return phases, ready
+}
+
+// Timings returns the start-up timing report of the suite, once it started.
+func (s *Suite) Timings() *Timings {
+	s.mu.Lock()
+	start := s.started
+	s.mu.Unlock()
+
+	tr := &Timings{Suite: s.name, Start: start}
+	tr.Phases, _ = s.timings.relative(start)
+
+	n := s.getNetwork()
+	if n == nil {
+		return tr
+	}
+	networkPhases, networkReady := n.timings.relative(start)
+	tr.Phases = append(tr.Phases, networkPhases...)
+	var visit func(parent string, containers []*Container)
+	visit = func(parent string, containers []*Container) {
+		for _, c := range containers {
+			ct := ContainerTimings{Name: c.Name, After: parent}
+			for _, cc := range c.needs {
+				ct.Needs = append(ct.Needs, cc.Name)
+			}
+			ct.Phases, ct.Ready = c.timings.relative(start)
+			if ct.Ready > tr.Total {
+				tr.Total = ct.Ready
+			}
+			tr.Containers = append(tr.Containers, ct)
+			visit(c.Name, c.children)
+		}
+	}
+	visit("", n.children)
+	tr.CriticalPath = criticalPath(tr.Containers, n.name, networkReady)
+
+	return tr
+}
+
+// criticalPath returns the chain of containers, which ends with the container
+// ready last. Each one waited for the previous one, i.e. its parent or the
+// container it needs, which got ready last.
+func criticalPath(containers []ContainerTimings, network string, networkReady time.Duration) []string {
+	byName := make(map[string]ContainerTimings, len(containers))
+	var last *ContainerTimings
+	for i := range containers {
+		byName[containers[i].Name] = containers[i]
+		if last == nil || containers[i].Ready > last.Ready {
+			last = &containers[i]
+		}
+	}
+	if last == nil {
+		return []string{network}
+	}
+
+	path := []string{last.Name}
+	for c := *last; ; {
+		blocker, ready := "", networkReady
+		if c.After != "" {
+			blocker, ready = c.After, byName[c.After].Ready
+		}
+		for _, name := range c.Needs {
+			if byName[name].Ready > ready {
+				blocker, ready = name, byName[name].Ready
+			}
+		}
+		if blocker == "" {
+			break
+		}
+		path = append(path, blocker)
+		c = byName[blocker]
+	}
+	path = append(path, network)
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// WriteText writes the report as a table, in which the containers on the
+// critical path are marked with an asterisk.
+func (tr *Timings) WriteText(w io.Writer) error {
+	critical := make(map[string]bool, len(tr.CriticalPath))
+	for _, name := range tr.CriticalPath {
+		critical[name] = true
+	}
+
+	fmt.Fprintf(w, "suite %s started in %s\n", tr.Suite, round(tr.Total)) // nolint: errcheck
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tCONTAINER\tAFTER\tREADY\tPHASES")              // nolint: errcheck
+	fmt.Fprintf(tw, "\t%s\t\t\t%s\n", "(suite)", phaseList(tr.Phases)) // nolint: errcheck
+	for _, ct := range tr.Containers {
+		mark := ""
+		if critical[ct.Name] {
+			mark = "*"
+		}
+		after := ct.After
+		if after == "" {
+			after = "(network)"
+		}
+		if len(ct.Needs) > 0 {
+			after += ", needs " + strings.Join(ct.Needs, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", mark, ct.Name, after, round(ct.Ready), phaseList(ct.Phases)) // nolint: errcheck
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "critical path: %s\n", strings.Join(tr.CriticalPath, " -> "))
+	return err
+}
+
+// phaseList renders the phases with their durations, e.g. "pull 1.2s, create 50ms".
+func phaseList(phases []Phase) string {
+	list := make([]string, 0, len(phases))
+	for _, p := range phases {
+		list = append(list, p.Name+" "+round(p.Duration).String())
+	}
+	return strings.Join(list, ", ")
+}
+
+// round rounds the duration for display.
+func round(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// WriteJSON writes the report as JSON. Durations are given in nanoseconds.
+func (tr *Timings) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tr)
+}
+
+// traceEvent is an event of the Chrome trace event format, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name string            `json:"name"`
+	Ph   string            `json:"ph"`
+	Ts   int64             `json:"ts"`
+	Dur  int64             `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// WriteChromeTrace writes the report in the Chrome trace event format, which
+// can be opened in chrome://tracing or https://ui.perfetto.dev. Every container
+// is shown as a thread, the suite itself as the first one.
+func (tr *Timings) WriteChromeTrace(w io.Writer) error {
+	var events []traceEvent
+	add := func(tid int, name string, phases []Phase) {
+		events = append(events, traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tid, Args: map[string]string{"name": name}})
+		for _, p := range phases {
+			events = append(events, traceEvent{
+				Name: p.Name,
+				Ph:   "X",
+				Ts:   p.Start.Microseconds(),
+				Dur:  p.Duration.Microseconds(),
+				Pid:  1,
+				Tid:  tid,
+			})
+		}
+	}
+
+	add(0, tr.Suite, tr.Phases)
+	containers := append([]ContainerTimings(nil), tr.Containers...)
+	sort.SliceStable(containers, func(i, j int) bool {
+		return containers[i].Ready < containers[j].Ready
+	})
+	for i, ct := range containers {
+		add(i+1, ct.Name, ct.Phases)
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{"traceEvents": events})
+}
+
+// printTimings prints the timing report of the suite.
+func (s *Suite) printTimings() {
+	var b strings.Builder
+	if err := s.Timings().WriteText(&b); err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		printf("(timing) %s", line)
+	}
+}
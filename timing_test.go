@@ -0,0 +1,64 @@
+package testingdock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestSuite_Timings(t *testing.T) {
+	name := "TestSuite_Timings"
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: fake.NewEngine()})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	a := s.Container(testingdock.ContainerOpts{Name: "a", Config: &container.Config{Image: "fake"}})
+	b := s.Container(testingdock.ContainerOpts{Name: "b", Config: &container.Config{Image: "fake"}})
+	c := s.Container(testingdock.ContainerOpts{Name: "c", Config: &container.Config{Image: "fake"}})
+	n.After(a)
+	a.After(b)
+	n.After(c)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	tr := s.Timings()
+	if expected := []string{name, "a", "b"}; !reflect.DeepEqual(tr.CriticalPath, expected) {
+		t.Errorf("unexpected critical path: %v", tr.CriticalPath)
+	}
+	if len(tr.Containers) != 3 {
+		t.Fatalf("expected timings of 3 containers, got %d", len(tr.Containers))
+	}
+	var phases []string
+	for _, p := range tr.Containers[0].Phases {
+		phases = append(phases, p.Name)
+	}
+	if expected := []string{"resolve", "cleanup", "create", "start", "health check", "children"}; !reflect.DeepEqual(phases, expected) {
+		t.Errorf("unexpected phases: %v", phases)
+	}
+	if tr.Total != tr.Containers[1].Ready {
+		t.Errorf("total %s should equal the ready time of the last container %s", tr.Total, tr.Containers[1].Ready)
+	}
+
+	var buf bytes.Buffer
+	if err := tr.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "critical path: "+name+" -> a -> b") {
+		t.Errorf("unexpected text report:\n%s", buf.String())
+	}
+	for format, write := range map[string]func(*bytes.Buffer) error{
+		"json":  func(b *bytes.Buffer) error { return tr.WriteJSON(b) },
+		"trace": func(b *bytes.Buffer) error { return tr.WriteChromeTrace(b) },
+	} {
+		buf.Reset()
+		if err := write(&buf); err != nil || !json.Valid(buf.Bytes()) {
+			t.Errorf("invalid %s report (%v):\n%s", format, err, buf.String())
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// VolumeImage is the image of the helper container, which populates volumes.
+var VolumeImage = "busybox:1.31"
+
+// VolumeOpts is used when creating a new volume.
+type VolumeOpts struct {
+	Name string
+	// Source is a directory or a tar archive on the host, optionally compressed,
+	// the volume is populated with when it is created.
+	Source string
+	// Reuse keeps the volume after Close, so the next test run can adopt
+	// it together with its reused containers, see ContainerOpts.Reuse.
+	Reuse bool
+}
+
+// VolumeMount mounts a volume of the suite into a container.
+type VolumeMount struct {
+	Volume *Volume
+	// path in the container
+	Target   string
+	ReadOnly bool
+	// whether to not copy the content of the target directory of the image
+	// into the volume, if the volume is empty
+	NoCopy bool
+}
+
+// Volume is a docker volume configuration. It is created by the suite before
+// the network and removed after all containers have been removed.
+// This should usually be created via the Suite.
+type Volume struct {
+	t      Reporter
+	cli    client.APIClient
+	name   string
+	source string
+	labels map[string]string
+	// mu guards cancel and closed, which are accessed by start and close
+	// from different goroutines
+	mu     sync.Mutex
+	cancel func(ctx context.Context) error
+	closed bool
+	// dockerName is the name of the docker volume, which differs from
+	// the logical name if the suite isolates names
+	dockerName string
+	reuse      bool
+}
+
+// Creates a new docker volume configuration with the given options.
+func newVolume(t Reporter, c client.APIClient, opts VolumeOpts) *Volume {
+	labels := createTestingLabel()
+	if opts.Reuse {
+		labels = createReuseLabel()
+	}
+
+	return &Volume{
+		t:          t,
+		cli:        c,
+		name:       opts.Name,
+		source:     opts.Source,
+		dockerName: opts.Name,
+		labels:     labels,
+		reuse:      opts.Reuse,
+	}
+}
+
+// Creates and populates the actual docker volume, unless a reused one exists.
+func (v *Volume) start(ctx context.Context) {
+	if v.reuse && v.adopt(ctx) {
+		return
+	}
+
+	v.initialCleanup(ctx)
+	if _, err := v.cli.VolumeCreate(ctx, volume.VolumeCreateBody{
+		Name:   v.dockerName,
+		Labels: v.labels,
+	}); err != nil {
+		v.t.Fatalf("volume creation failure: %s", err.Error())
+	}
+	v.setCancel()
+	printf("(setup ) %-25s (%-64s) - volume created", v.name, v.dockerName)
+
+	if v.source != "" {
+		if err := v.populate(ctx); err != nil {
+			v.t.Fatalf("volume population failure from '%s': %s", v.source, err.Error())
+		}
+		printf("(setup ) %-25s (%-64s) - volume populated from: %s", v.name, v.dockerName, v.source)
+	}
+}
+
+// setCancel sets the cancel function, which removes the volume on close,
+// unless it is reused.
+func (v *Volume) setCancel() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.reuse {
+		v.cancel = func(ctx context.Context) error {
+			printf("(cancel) %-25s (%-64s) - volume kept for reuse", v.name, v.dockerName)
+			return nil
+		}
+		return
+	}
+
+	v.cancel = func(ctx context.Context) error {
+		if err := v.cli.VolumeRemove(ctx, v.dockerName, true); err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("volume removal failure of %s: %s", v.name, err.Error())
+		}
+		printf("(cancel) %-25s (%-64s) - volume removed", v.name, v.dockerName)
+		return nil
+	}
+}
+
+// adopt makes an existing reused or shared volume the docker volume of this
+// configuration. Returns false if there is no such volume.
+func (v *Volume) adopt(ctx context.Context) bool {
+	vv, err := findVolumeByName(ctx, v.cli, v.dockerName)
+	if err != nil {
+		v.t.Fatalf("volume listing failure: %s", err.Error())
+	}
+	if vv == nil || !isOwnedByTestingdock(vv.Labels) || vv.Labels[labelReuse] != v.labels[labelReuse] || vv.Labels[labelShared] != v.labels[labelShared] {
+		return false
+	}
+
+	v.setCancel()
+	printf("(setup ) %-25s (%-64s) - volume reused", v.name, v.dockerName)
+	return true
+}
+
+// Find the volume with the given name, if any. The name filter of docker matches
+// substrings, so the exact name is looked for.
+func findVolumeByName(ctx context.Context, cli client.APIClient, name string) (*types.Volume, error) {
+	volumes, err := cli.VolumeList(ctx, filters.NewArgs(filters.Arg("name", name)))
+	if err != nil {
+		return nil, err
+	}
+	for _, vv := range volumes.Volumes {
+		if vv.Name == name {
+			return vv, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// removes the volume if it already exists and all containers using it.
+func (v *Volume) initialCleanup(ctx context.Context) {
+	vv, err := findVolumeByName(ctx, v.cli, v.dockerName)
+	if err != nil {
+		v.t.Fatalf("volume listing failure: %s", err.Error())
+	}
+	if vv == nil {
+		return
+	}
+	if !isOwnedByTestingdock(vv.Labels) {
+		v.t.Fatalf("volume with name %s already exists, but wasn't created by tesingdock, aborting!", v.dockerName)
+	}
+
+	containers, err := v.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("volume", v.dockerName)),
+	})
+	if err != nil {
+		v.t.Fatalf("container list failure: %s", err.Error())
+	}
+	for _, cc := range containers {
+		if !isOwnedByTestingdock(cc.Labels) {
+			v.t.Fatalf("container with ID %s already exists, but wasn't started by tesingdock, aborting!", cc.ID)
+		}
+		if err = v.cli.ContainerRemove(ctx, cc.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			v.t.Fatalf("container removal failure: %s", err.Error())
+		}
+		printf("(setup ) %-25s (%s) - volume user removed: %s", v.name, cc.ID, cc.Names[0])
+	}
+
+	if err = v.cli.VolumeRemove(ctx, v.dockerName, true); err != nil {
+		v.t.Fatalf("volume removal failure: %s", err.Error())
+	}
+	printf("(setup ) %-25s (%-64s) - volume removed", v.name, v.dockerName)
+}
+
+// populate copies the source into the volume with a helper container, which
+// is created but never started.
+func (v *Volume) populate(ctx context.Context) error {
+	var content io.Reader
+	fi, err := os.Stat(v.source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if content, err = tarPath(v.source); err != nil {
+			return err
+		}
+	} else {
+		// docker extracts tar archives, also compressed ones
+		f, err := os.Open(v.source)
+		if err != nil {
+			return err
+		}
+		defer f.Close() // nolint: errcheck
+		content = f
+	}
+
+	if err = pullMissing(ctx, v.cli, VolumeImage); err != nil {
+		return err
+	}
+	cont, err := v.cli.ContainerCreate(ctx, &container.Config{
+		Image:  VolumeImage,
+		Labels: createTestingLabel(),
+	}, &container.HostConfig{
+		Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: v.dockerName, Target: "/data"}},
+	}, nil, "")
+	if err != nil {
+		return fmt.Errorf("container creation failure: %s", err.Error())
+	}
+	defer v.cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true}) // nolint: errcheck
+
+	return v.cli.CopyToContainer(ctx, cont.ID, "/data", content, types.CopyToContainerOptions{})
+}
+
+// pullMissing pulls the given image, unless it already exists locally.
+func pullMissing(ctx context.Context, cli client.APIClient, image string) error {
+	images, err := cli.ImageList(ctx, types.ImageListOptions{Filters: filters.NewArgs(filters.Arg("reference", image))})
+	if err != nil {
+		return fmt.Errorf("image listing failure: %s", err.Error())
+	}
+	if len(images) > 0 {
+		return nil
+	}
+
+	printf("(setup ) %-25s - pulling image", image)
+	img, err := imagePull(ctx, cli, image)
+	if err != nil {
+		return fmt.Errorf("image downloading failure of '%s': %s", image, err.Error())
+	}
+	_, err = io.Copy(ioutil.Discard, img)
+	img.Close() // nolint: errcheck
+	if err != nil {
+		return fmt.Errorf("image pull response read failure: %s", err.Error())
+	}
+	return nil
+}
+
+// Closes the docker volume using the given teardown context, the containers
+// using it have to be removed before. Closing a volume more than once is a no-op.
+func (v *Volume) close(ctx context.Context) error {
+	v.mu.Lock()
+	if v.closed {
+		v.mu.Unlock()
+		return nil
+	}
+	v.closed = true
+	cancel := v.cancel
+	v.mu.Unlock()
+
+	// if the volume failed to start cancel will not be set
+	if cancel != nil {
+		return cancel(ctx)
+	}
+
+	return nil
+}
+
+// DockerName returns the name of the docker volume. It equals the name given
+// in VolumeOpts, unless the suite isolates names.
+func (v *Volume) DockerName() string {
+	return v.dockerName
+}
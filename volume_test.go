@@ -0,0 +1,100 @@
+package testingdock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestSuite_Volume(t *testing.T) {
+	name := "TestSuite_Volume"
+
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	if err = ioutil.WriteFile(filepath.Join(dir, "init.sql"), []byte("SELECT 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	v := s.Volume(testingdock.VolumeOpts{Name: name, Source: dir})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name:    name,
+		Config:  &container.Config{Image: "postgres"},
+		Volumes: []testingdock.VolumeMount{{Volume: v, Target: "/docker-entrypoint-initdb.d", ReadOnly: true}},
+		Tmpfs:   []string{"/var/lib/postgresql/data"},
+	}))
+	s.Start(context.TODO())
+
+	volumes, err := engine.VolumeList(context.TODO(), filters.NewArgs(filters.Arg("label", "testingdock.suite="+name)))
+	if err != nil || len(volumes.Volumes) != 1 || volumes.Volumes[0].Labels["owner"] != "testingdock" {
+		t.Fatalf("volume should be created with the testingdock labels: %v", volumes.Volumes)
+	}
+	if files := engine.VolumeFiles[v.DockerName()]; !reflect.DeepEqual(files, []string{"init.sql"}) {
+		t.Errorf("volume should be populated, got %v", files)
+	}
+	if containers, _ := engine.Counts(); containers != 1 {
+		t.Errorf("populating container should be removed, got %d containers", containers)
+	}
+
+	hcfg := engine.ByName(name).HostConfig
+	if len(hcfg.Mounts) != 1 || hcfg.Mounts[0].Source != v.DockerName() || !hcfg.Mounts[0].ReadOnly {
+		t.Errorf("volume should be mounted: %v", hcfg.Mounts)
+	}
+	if _, ok := hcfg.Tmpfs["/var/lib/postgresql/data"]; !ok {
+		t.Errorf("tmpfs should be mounted: %v", hcfg.Tmpfs)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if volumes := engine.VolumeCount(); volumes != 0 {
+		t.Errorf("volume should be removed on close, got %d volumes", volumes)
+	}
+}
+
+func TestSuite_VolumeSharedHostConfig(t *testing.T) {
+	name := "TestSuite_VolumeSharedHostConfig"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+
+	// the mounts of one container must not pile up in the others
+	hcfg := &container.HostConfig{Tmpfs: map[string]string{"/tmp": ""}}
+	for _, c := range []string{"a", "b"} {
+		n.After(s.Container(testingdock.ContainerOpts{
+			Name:       c,
+			Config:     &container.Config{Image: "fake"},
+			HostConfig: hcfg,
+			Volumes:    []testingdock.VolumeMount{{Volume: s.Volume(testingdock.VolumeOpts{Name: name + "_" + c}), Target: "/data"}},
+			Tmpfs:      []string{"/" + c},
+		}))
+	}
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	for _, c := range []string{"a", "b"} {
+		created := engine.ByName(c).HostConfig
+		if len(created.Mounts) != 1 || created.Mounts[0].Source != name+"_"+c {
+			t.Errorf("one volume should be mounted into %s: %v", c, created.Mounts)
+		}
+		if expected := map[string]string{"/tmp": "", "/" + c: ""}; !reflect.DeepEqual(created.Tmpfs, expected) {
+			t.Errorf("unexpected tmpfs of %s: %v", c, created.Tmpfs)
+		}
+	}
+	if len(hcfg.Mounts) != 0 || len(hcfg.Tmpfs) != 1 || hcfg.AutoRemove {
+		t.Errorf("the host config of the caller should be left alone: %+v", hcfg)
+	}
+}
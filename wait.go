@@ -0,0 +1,125 @@
+package testingdock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// WaitOpts is used when waiting for a condition with Container.WaitFor.
+type WaitOpts struct {
+	// time between the checks, default is one second like the health check
+	Interval time.Duration
+	// time after which waiting fails, default is the health check timeout
+	// of the container
+	Timeout time.Duration
+}
+
+// WaitFor blocks until the given check returns no error, e.g. after an action of
+// the test. The check is polled like the health check of the container. Returns
+// the last failure of the check, if it does not pass in time.
+func (c *Container) WaitFor(ctx context.Context, check HealthCheckFunc, opts WaitOpts) error {
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = c.healthchecktimeout
+	}
+
+	return c.poll(ctx, check, opts, "(wait  ) %-25s (%s) - condition not met yet: %s")
+}
+
+// poll calls the check in the given interval until it returns no error. Every
+// failure is logged with the given format. Returns the last failure once the
+// timeout expired or the context is done.
+func (c *Container) poll(ctx context.Context, check HealthCheckFunc, opts WaitOpts, format string) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	var last error
+	for {
+		select {
+		case <-ctx.Done():
+			if last != nil {
+				return fmt.Errorf("%s, last failure: %s", ctx.Err(), last.Error())
+			}
+			return ctx.Err()
+		case <-time.After(opts.Interval):
+			if last = check(ctx, c); last == nil {
+				return nil
+			}
+			printf(format, c.Name, c.ID, last.Error())
+		}
+	}
+}
+
+// LogMark marks a point in time in the logs of a container, see Container.Mark.
+// The zero value marks the start of the container.
+type LogMark time.Time
+
+// Mark returns a mark of the current end of the logs of the container, e.g.
+// to look only at the logs caused by a following action with LogsSince or ExpectLog.
+// The clocks of the docker host and the tests are assumed to be in sync.
+func (c *Container) Mark() LogMark {
+	return LogMark(time.Now())
+}
+
+// LogsSince returns the stdout and stderr output of the container logged since
+// the given mark.
+func (c *Container) LogsSince(ctx context.Context, mark LogMark) (string, error) {
+	opts := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true}
+	if t := time.Time(mark); !t.IsZero() {
+		opts.Since = fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+	}
+
+	reader, err := c.cli.ContainerLogs(ctx, c.ID, opts)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close() // nolint: errcheck
+
+	var logs bytes.Buffer
+	// the output is only multiplexed, unless the container has a TTY
+	if c.ccfg.Tty {
+		_, err = io.Copy(&logs, reader)
+	} else {
+		_, err = stdcopy.StdCopy(&logs, &logs, reader)
+	}
+	return logs.String(), err
+}
+
+// HealthCheckLog is a pre-implemented HealthCheckFunc which checks if a line of
+// the logs of the container since the given mark matches the given regular
+// expression. The expression is matched against each line on its own, so ^ and
+// $ anchor at the start and end of the line.
+func HealthCheckLog(mark LogMark, re *regexp.Regexp) HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		logs, err := c.LogsSince(ctx, mark)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(logs, "\n") {
+			if re.MatchString(strings.TrimSuffix(line, "\r")) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no log line matches: %s", re.String())
+	}
+}
+
+// ExpectLog blocks until the container logged a line matching the given regular
+// expression since the given mark, see WaitFor.
+func (c *Container) ExpectLog(ctx context.Context, mark LogMark, pattern string, opts WaitOpts) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	return c.WaitFor(ctx, HealthCheckLog(mark, re), opts)
+}
@@ -0,0 +1,71 @@
+package testingdock_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/m4ksio/testingdock"
+	"github.com/m4ksio/testingdock/internal/fake"
+)
+
+func TestContainer_ExpectLog(t *testing.T) {
+	name := "TestContainer_ExpectLog"
+	engine := fake.NewEngine()
+	s, _ := testingdock.GetOrCreateSuite(t, name, testingdock.SuiteOpts{Client: engine})
+	n := s.Network(testingdock.NetworkOpts{Name: name})
+	c := s.Container(testingdock.ContainerOpts{Name: name, Config: &container.Config{Image: "fake"}})
+	n.After(c)
+	s.Start(context.TODO())
+	defer s.Close() // nolint: errcheck
+
+	engine.Log(c.ID, "booting")
+	time.Sleep(time.Millisecond)
+	mark := c.Mark()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		engine.Log(c.ID, "order 42 processed")
+	}()
+
+	opts := testingdock.WaitOpts{Interval: 50 * time.Millisecond, Timeout: 5 * time.Second}
+	if err := c.ExpectLog(context.TODO(), mark, `order \d+ processed`, opts); err != nil {
+		t.Fatal(err)
+	}
+	logs, err := c.LogsSince(context.TODO(), mark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs != "order 42 processed\n" {
+		t.Errorf("unexpected logs since the mark: %q", logs)
+	}
+	if logs, _ = c.LogsSince(context.TODO(), testingdock.LogMark{}); !strings.HasPrefix(logs, "booting\n") {
+		t.Errorf("unexpected logs since the start: %q", logs)
+	}
+
+	// patterns are matched against each line, so they can be anchored
+	for _, pattern := range []string{`^booting$`, `^order \d+ processed$`} {
+		if err = c.ExpectLog(context.TODO(), testingdock.LogMark{}, pattern, opts); err != nil {
+			t.Errorf("anchored pattern %s should match: %v", pattern, err)
+		}
+	}
+
+	// the last failure is returned on timeout
+	opts.Timeout = 200 * time.Millisecond
+	if err = c.ExpectLog(context.TODO(), mark, "never", opts); err == nil || !strings.Contains(err.Error(), "no log line matches: never") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	calls := 0
+	err = c.WaitFor(context.TODO(), func(ctx context.Context, c *testingdock.Container) error {
+		if calls++; calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	}, testingdock.WaitOpts{Interval: 10 * time.Millisecond})
+	if err != nil || calls != 3 {
+		t.Errorf("expected the condition to be met after 3 checks, got %d: %v", calls, err)
+	}
+}